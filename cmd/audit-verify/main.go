@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"agnos/internal/config"
+	"agnos/internal/jwtkeys"
+	"agnos/internal/repository"
+	"agnos/internal/service"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// audit-verify re-verifies the audit hash chain of one hospital, or of every
+// hospital, and prints the result as JSON. It exits with status 1 when a chain
+// is broken. Checkpoint signatures are checked with the keys in AUDIT_KEYS_DIR;
+// without it only the hash links are verified.
+func main() {
+	hospital := flag.String("hospital", "", "hospital code (default: all hospitals)")
+	checkpoint := flag.Bool("checkpoint", false, "sign a checkpoint of every chain head after verifying")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	db, err := sql.Open("pgx", cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		log.Fatalf("ping db: %v", err)
+	}
+
+	var keys service.TokenKeys
+	if cfg.AuditKeysDir != "" {
+		ks, err := jwtkeys.LoadDir(cfg.AuditKeysDir, cfg.AuditActiveKID)
+		if err != nil {
+			log.Fatalf("audit keys: %v", err)
+		}
+		keys = ks
+	} else {
+		log.Printf("AUDIT_KEYS_DIR is not set; checkpoint signatures are not verified")
+	}
+
+	repo := repository.NewPostgresAuditRepository(db)
+	audit := service.NewAuditService(repo, keys)
+
+	hospitals := []string{*hospital}
+	if *hospital == "" {
+		heads, err := repo.Heads()
+		if err != nil {
+			log.Fatalf("list chains: %v", err)
+		}
+		hospitals = hospitals[:0]
+		for _, h := range heads {
+			hospitals = append(hospitals, h.Hospital)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	valid := true
+	for _, h := range hospitals {
+		result, err := audit.Verify(h)
+		if err != nil {
+			log.Fatalf("verify %q: %v", h, err)
+		}
+		valid = valid && result.Valid
+		if result.Unverifiable > 0 {
+			log.Printf("%q: %d checkpoints signed with a key missing from AUDIT_KEYS_DIR", h, result.Unverifiable)
+		}
+		_ = enc.Encode(result)
+	}
+	if !valid {
+		os.Exit(1)
+	}
+
+	if *checkpoint {
+		checkpoints, err := audit.Checkpoint()
+		if err != nil {
+			log.Fatalf("checkpoint: %v", err)
+		}
+		for _, cp := range checkpoints {
+			log.Printf("signed checkpoint for %q at seq %d", cp.Hospital, cp.Seq)
+		}
+	}
+}
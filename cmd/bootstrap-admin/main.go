@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"agnos/internal/config"
+	"agnos/internal/repository"
+	"agnos/internal/service"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// bootstrap-admin creates the first admin of a hospital. The password is read
+// from BOOTSTRAP_ADMIN_PASSWORD or, if unset, from the first line of stdin so
+// it never appears in the process list.
+func main() {
+	username := flag.String("username", "", "admin username")
+	hospital := flag.String("hospital", "", "hospital code")
+	flag.Parse()
+
+	if *username == "" || *hospital == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	password := cfg.BootstrapAdminPassword
+	if password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	policy, err := cfg.PasswordPolicy()
+	if err != nil {
+		log.Fatalf("password policy: %v", err)
+	}
+
+	db, err := sql.Open("pgx", cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		log.Fatalf("ping db: %v", err)
+	}
+
+	// No tokens are issued here, so the service needs no signer.
+	staffSvc := service.NewStaffService(
+		repository.NewPostgresStaffRepository(db),
+		repository.NewPostgresSessionRepository(db),
+		repository.NewPostgresLoginThrottleRepository(db),
+		repository.NewPostgresSecurityEventRepository(db),
+		repository.NewPostgresAuditRepository(db),
+		nil,
+		service.StaffConfig{PasswordPolicy: policy, PasswordHash: cfg.PasswordHashParams},
+	)
+	admin, created, err := staffSvc.BootstrapAdmin(*username, password, *hospital)
+	if err != nil {
+		log.Fatalf("bootstrap admin: %v", err)
+	}
+	if !created {
+		log.Fatalf("hospital %q already has an active admin; use POST /staff/create instead", *hospital)
+	}
+	fmt.Printf("created admin %q (id %d) for hospital %q\n", admin.Username, admin.ID, admin.Hospital)
+}
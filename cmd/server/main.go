@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -13,7 +14,10 @@ import (
 	"agnos/internal/config"
 	"agnos/internal/his"
 	api "agnos/internal/http"
+	"agnos/internal/jwtkeys"
+	"agnos/internal/middleware"
 	"agnos/internal/repository"
+	"agnos/internal/secretbox"
 	"agnos/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -21,7 +25,10 @@ import (
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
 
 	db, err := sql.Open("pgx", cfg.DatabaseURL)
 	if err != nil {
@@ -36,15 +43,63 @@ func main() {
 	}
 
 	staffRepo := repository.NewPostgresStaffRepository(db)
+	sessionRepo := repository.NewPostgresSessionRepository(db)
 	patientRepo := repository.NewPostgresPatientRepository(db)
+	auditRepo := repository.NewPostgresAuditRepository(db)
+	mpiRepo := repository.NewPostgresMPIRepository(db)
 
-	staffSvc := service.NewStaffService(staffRepo, cfg.JWTSecret, cfg.TokenTTL)
-	hospitalClient := his.NewHospitalAClient(cfg.HospitalABaseURL, http.DefaultClient)
-	patientSvc := service.NewPatientService(patientRepo, hospitalClient)
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		log.Fatalf("jwt keys: %v", err)
+	}
+	staffCfg, err := staffConfig(cfg)
+	if err != nil {
+		log.Fatalf("staff config: %v", err)
+	}
+
+	staffSvc := service.NewStaffService(
+		staffRepo,
+		sessionRepo,
+		repository.NewPostgresLoginThrottleRepository(db),
+		repository.NewPostgresSecurityEventRepository(db),
+		auditRepo,
+		keys,
+		staffCfg,
+	)
+	if cfg.BootstrapAdminUsername != "" {
+		admin, created, err := staffSvc.BootstrapAdmin(cfg.BootstrapAdminUsername, cfg.BootstrapAdminPassword, cfg.BootstrapAdminHospital)
+		if err != nil {
+			log.Fatalf("bootstrap admin: %v", err)
+		}
+		if created {
+			log.Printf("bootstrap admin %q created for hospital %q", admin.Username, admin.Hospital)
+		}
+	}
+	hisRegistry, err := his.NewRegistry(cfg.HIS, his.NewHTTPClient())
+	if err != nil {
+		log.Fatalf("his registry: %v", err)
+	}
+	patientSvc := service.NewPatientService(patientRepo, auditRepo, hisRegistry, mpiRepo, cfg.PatientMasking)
+	mpiSvc := service.NewMPIService(mpiRepo, patientRepo, auditRepo, cfg.PatientMasking)
+	privacySvc := service.NewPrivacyService(repository.NewPostgresPrivacyRepository(db), patientRepo, mpiRepo, auditRepo, cfg.PrivacyRequestDue)
+	auditKeys, err := loadAuditKeys(cfg)
+	if err != nil {
+		log.Fatalf("audit keys: %v", err)
+	}
+	// A nil *KeySet must not reach the service as a non-nil TokenKeys.
+	auditSvc := service.NewAuditService(auditRepo, nil)
+	if auditKeys != nil {
+		auditSvc = service.NewAuditService(auditRepo, auditKeys)
+		go checkpointAudit(auditSvc, cfg.AuditCheckpointInterval)
+	}
+	go syncPatients(patientSvc, cfg.HISSyncInterval, cfg.HISSyncBatch, cfg.HISSyncPause)
 
 	r := gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
-	api.RegisterRoutes(r, staffSvc, patientSvc, cfg.JWTSecret)
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("trusted proxies: %v", err)
+	}
+	r.Use(middleware.RequestID(), gin.Logger(), gin.Recovery())
+	api.RegisterRoutes(r, staffSvc, patientSvc, mpiSvc, privacySvc, auditSvc, hisRegistry, keys)
 
 	srv := &http.Server{
 		Addr:              ":8080",
@@ -58,6 +113,26 @@ func main() {
 		}
 	}()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := keys.Reload(); err != nil {
+				log.Printf("reload jwt keys: %v", err)
+				continue
+			}
+			log.Printf("jwt keys reloaded")
+			if auditKeys == nil {
+				continue
+			}
+			if err := auditKeys.Reload(); err != nil {
+				log.Printf("reload audit keys: %v", err)
+				continue
+			}
+			log.Printf("audit keys reloaded")
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -66,3 +141,98 @@ func main() {
 	defer shutdownCancel()
 	_ = srv.Shutdown(shutdownCtx)
 }
+
+// checkpointAudit periodically signs the head of every audit chain so a
+// rewritten chain can be told apart from the original.
+func checkpointAudit(audit service.AuditService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkpoints, err := audit.Checkpoint()
+		if err != nil {
+			log.Printf("audit checkpoint: %v", err)
+		}
+		for _, cp := range checkpoints {
+			log.Printf("audit checkpoint: hospital %q at seq %d", cp.Hospital, cp.Seq)
+		}
+	}
+}
+
+// syncPatients periodically refreshes patients whose copy of the HIS record
+// is older than their hospital's sync TTL, a batch per hospital at a time.
+func syncPatients(patients service.PatientService, interval time.Duration, batch int, pause time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		runs, err := patients.RefreshStale(context.Background(), batch, pause)
+		if err != nil {
+			log.Printf("his sync: %v", err)
+		}
+		for _, run := range runs {
+			if run.Checked == 0 {
+				continue
+			}
+			log.Printf("his sync: hospital %q checked %d, refreshed %d, missing %d, failed %d, stopped %t",
+				run.Hospital, run.Checked, run.Refreshed, run.Missing, run.Failed, run.Stopped)
+		}
+	}
+}
+
+func loadKeys(cfg config.Config) (*jwtkeys.KeySet, error) {
+	if cfg.JWTKeysDir == "" {
+		log.Printf("JWT_KEYS_DIR is not set; using an ephemeral signing key, tokens will not survive a restart")
+		return jwtkeys.Generate()
+	}
+	return jwtkeys.LoadDir(cfg.JWTKeysDir, cfg.JWTActiveKID)
+}
+
+// loadAuditKeys returns the key set that signs audit checkpoints, or nil when
+// AUDIT_KEYS_DIR is not set. Checkpoints are never signed with an ephemeral
+// key: they must stay verifiable long after the process that wrote them.
+func loadAuditKeys(cfg config.Config) (*jwtkeys.KeySet, error) {
+	if cfg.AuditKeysDir == "" {
+		log.Printf("AUDIT_KEYS_DIR is not set; audit checkpoints are not signed")
+		return nil, nil
+	}
+	return jwtkeys.LoadDir(cfg.AuditKeysDir, cfg.AuditActiveKID)
+}
+
+func staffConfig(cfg config.Config) (service.StaffConfig, error) {
+	mfa := service.MFAConfig{
+		Issuer:            cfg.MFAIssuer,
+		RequiredHospitals: make(map[string]bool, len(cfg.MFARequiredHospitals)),
+		ChallengeTTL:      5 * time.Minute,
+	}
+	for _, h := range cfg.MFARequiredHospitals {
+		mfa.RequiredHospitals[h] = true
+	}
+	if cfg.MFAEncryptionKey != "" {
+		box, err := secretbox.NewFromBase64(cfg.MFAEncryptionKey)
+		if err != nil {
+			return service.StaffConfig{}, err
+		}
+		mfa.Secrets = box
+	} else if len(cfg.MFARequiredHospitals) > 0 {
+		return service.StaffConfig{}, errors.New("MFA_REQUIRED_HOSPITALS is set but MFA_ENCRYPTION_KEY is not")
+	}
+
+	policy, err := cfg.PasswordPolicy()
+	if err != nil {
+		return service.StaffConfig{}, err
+	}
+
+	return service.StaffConfig{
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		Lockout: service.LockoutPolicy{
+			MaxAccountFailures: cfg.LoginMaxFailures,
+			MaxIPFailures:      cfg.LoginIPMaxFailures,
+			LockoutDuration:    cfg.LoginLockout,
+			BaseDelay:          cfg.LoginDelayBase,
+			MaxDelay:           cfg.LoginDelayMax,
+		},
+		MFA:            mfa,
+		PasswordPolicy: policy,
+		PasswordHash:   cfg.PasswordHashParams,
+	}, nil
+}
@@ -0,0 +1,9 @@
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'nurse';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chk_staff_role') THEN
+        ALTER TABLE staffs ADD CONSTRAINT chk_staff_role
+            CHECK (role IN ('admin', 'doctor', 'nurse', 'registrar', 'auditor'));
+    END IF;
+END $$;
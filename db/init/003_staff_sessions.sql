@@ -0,0 +1,25 @@
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS tokens_revoked_before TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS staff_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    staff_id BIGINT NOT NULL REFERENCES staffs (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_staff_sessions_staff_id ON staff_sessions (staff_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES staff_sessions (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    scope VARCHAR(10) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    blocked_until TIMESTAMPTZ,
+    locked BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (scope, key),
+    CONSTRAINT chk_login_throttle_scope CHECK (scope IN ('account', 'ip'))
+);
+
+CREATE TABLE IF NOT EXISTS security_events (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    staff_id BIGINT REFERENCES staffs (id) ON DELETE SET NULL,
+    username VARCHAR(100),
+    client_ip VARCHAR(64),
+    actor_staff_id BIGINT REFERENCES staffs (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_hospital_created_at ON security_events (hospital, created_at DESC);
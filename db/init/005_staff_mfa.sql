@@ -0,0 +1,14 @@
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS mfa_secret TEXT;
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS mfa_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS mfa_last_step BIGINT;
+
+ALTER TABLE staff_sessions ADD COLUMN IF NOT EXISTS mfa_verified BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS staff_mfa_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    staff_id BIGINT NOT NULL REFERENCES staffs (id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (staff_id, code_hash)
+);
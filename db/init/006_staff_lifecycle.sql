@@ -0,0 +1,3 @@
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMPTZ;
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMPTZ;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS patient_access_logs (
+    id BIGSERIAL PRIMARY KEY,
+    staff_id BIGINT NOT NULL REFERENCES staffs (id),
+    hospital VARCHAR(100) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    criteria JSONB,
+    patient_ids JSONB NOT NULL DEFAULT '[]',
+    client_ip VARCHAR(64),
+    request_id VARCHAR(128),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_access_logs_hospital_created_at ON patient_access_logs (hospital, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_patient_access_logs_staff ON patient_access_logs (staff_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_patient_access_logs_patient_ids ON patient_access_logs USING GIN (patient_ids);
+
+-- The audit trail is append-only: reject every UPDATE, DELETE and TRUNCATE.
+CREATE OR REPLACE FUNCTION reject_audit_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_patient_access_logs_append_only ON patient_access_logs;
+CREATE TRIGGER trg_patient_access_logs_append_only
+    BEFORE UPDATE OR DELETE ON patient_access_logs
+    FOR EACH ROW EXECUTE FUNCTION reject_audit_change();
+
+DROP TRIGGER IF EXISTS trg_patient_access_logs_no_truncate ON patient_access_logs;
+CREATE TRIGGER trg_patient_access_logs_no_truncate
+    BEFORE TRUNCATE ON patient_access_logs
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_change();
@@ -0,0 +1,40 @@
+-- Re-running the init scripts recreates an empty patient_access_logs after
+-- the rename; it is dropped instead of renamed over audit_log.
+DO $$
+BEGIN
+    IF to_regclass('patient_access_logs') IS NOT NULL AND to_regclass('audit_log') IS NULL THEN
+        ALTER TABLE patient_access_logs RENAME TO audit_log;
+        ALTER INDEX IF EXISTS idx_patient_access_logs_hospital_created_at RENAME TO idx_audit_log_hospital_created_at;
+        ALTER INDEX IF EXISTS idx_patient_access_logs_staff RENAME TO idx_audit_log_staff;
+        ALTER INDEX IF EXISTS idx_patient_access_logs_patient_ids RENAME TO idx_audit_log_patient_ids;
+        ALTER TRIGGER trg_patient_access_logs_append_only ON audit_log RENAME TO trg_audit_log_append_only;
+        ALTER TRIGGER trg_patient_access_logs_no_truncate ON audit_log RENAME TO trg_audit_log_no_truncate;
+    ELSIF to_regclass('patient_access_logs') IS NOT NULL AND NOT EXISTS (SELECT 1 FROM patient_access_logs) THEN
+        DROP TABLE patient_access_logs;
+    END IF;
+END $$;
+
+-- Login events have no staff member when the username is unknown.
+ALTER TABLE audit_log ALTER COLUMN staff_id DROP NOT NULL;
+
+-- Each entry hashes the previous entry of the same hospital. Entries written
+-- before chaining was introduced have no seq and are not verified.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS seq BIGINT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash CHAR(64);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS entry_hash CHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_audit_log_hospital_seq ON audit_log (hospital, seq);
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    seq BIGINT NOT NULL,
+    entry_hash CHAR(64) NOT NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (hospital, seq)
+);
+
+DROP TRIGGER IF EXISTS trg_audit_checkpoints_append_only ON audit_checkpoints;
+CREATE TRIGGER trg_audit_checkpoints_append_only
+    BEFORE UPDATE OR DELETE ON audit_checkpoints
+    FOR EACH ROW EXECUTE FUNCTION reject_audit_change();
@@ -0,0 +1,24 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Leading-wildcard ILIKE cannot use btree indexes; trigram GIN indexes serve
+-- both ILIKE '%x%' and the word-similarity operator used for typo-tolerant
+-- name matching.
+DROP INDEX IF EXISTS idx_patients_name_en;
+DROP INDEX IF EXISTS idx_patients_name_th;
+
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_en_trgm ON patients USING GIN (first_name_en gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_th_trgm ON patients USING GIN (first_name_th gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_middle_name_en_trgm ON patients USING GIN (middle_name_en gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_middle_name_th_trgm ON patients USING GIN (middle_name_th gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_en_trgm ON patients USING GIN (last_name_en gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_th_trgm ON patients USING GIN (last_name_th gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_phone_number_trgm ON patients USING GIN (phone_number gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_email_trgm ON patients USING GIN (email gin_trgm_ops);
+
+-- The default word-similarity threshold (0.6) rejects most single-letter
+-- typos in short names such as "Somchia" for "Somchai".
+DO $$
+BEGIN
+    EXECUTE format('ALTER DATABASE %I SET pg_trgm.word_similarity_threshold = 0.4', current_database());
+END
+$$;
@@ -0,0 +1,23 @@
+-- Bring identifiers stored before normalization into canonical form. When
+-- several rows share a canonical ID, only the one with the smallest id is
+-- updated, and only if no row holds the canonical ID already; the others are
+-- left for manual review.
+UPDATE patients p
+SET national_id = regexp_replace(p.national_id, '[\s-]', '', 'g'), updated_at = now()
+WHERE p.national_id ~ '[\s-]'
+  AND NOT EXISTS (
+      SELECT 1 FROM patients o
+      WHERE o.hospital = p.hospital AND o.id <> p.id
+        AND regexp_replace(o.national_id, '[\s-]', '', 'g') = regexp_replace(p.national_id, '[\s-]', '', 'g')
+        AND (o.id < p.id OR o.national_id !~ '[\s-]')
+  );
+
+UPDATE patients p
+SET passport_id = upper(regexp_replace(p.passport_id, '\s', '', 'g')), updated_at = now()
+WHERE p.passport_id <> upper(regexp_replace(p.passport_id, '\s', '', 'g'))
+  AND NOT EXISTS (
+      SELECT 1 FROM patients o
+      WHERE o.hospital = p.hospital AND o.id <> p.id
+        AND upper(regexp_replace(o.passport_id, '\s', '', 'g')) = upper(regexp_replace(p.passport_id, '\s', '', 'g'))
+        AND (o.id < p.id OR o.passport_id = upper(regexp_replace(o.passport_id, '\s', '', 'g')))
+  );
@@ -0,0 +1,28 @@
+-- phone_number keeps the number as received for display; phone_e164 is the
+-- normalized form used for matching (see internal/phone).
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS phone_e164 VARCHAR(16);
+
+-- Backfill with the same rules as phone.Normalize for the default region TH:
+-- drop extensions and separators, then read "+..." and "00..." as
+-- international, "0..." as a Thai national number and "66..." as a Thai
+-- number without the plus. Numbers that do not parse stay NULL.
+WITH cleaned AS (
+    SELECT id,
+           regexp_replace(
+               regexp_replace(btrim(phone_number), '\s*(ext\.?|x|#|ต่อ).*$', '', 'i'),
+               '[\s().\-/]', '', 'g') AS d
+    FROM patients
+    WHERE phone_number IS NOT NULL AND phone_e164 IS NULL
+)
+UPDATE patients p
+SET phone_e164 = CASE
+        WHEN c.d ~ '^\+[1-9][0-9]{7,14}$' THEN c.d
+        WHEN c.d ~ '^00[1-9][0-9]{7,14}$' THEN '+' || substr(c.d, 3)
+        WHEN c.d ~ '^0[0-9]{8,9}$' AND c.d !~ '^00' THEN '+66' || substr(c.d, 2)
+        WHEN c.d ~ '^66[0-9]{8,9}$' THEN '+' || c.d
+    END
+FROM cleaned c
+WHERE p.id = c.id;
+
+CREATE INDEX IF NOT EXISTS idx_patients_phone_e164_trgm ON patients USING GIN (phone_e164 gin_trgm_ops);
+DROP INDEX IF EXISTS idx_patients_phone_number_trgm;
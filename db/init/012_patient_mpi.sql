@@ -0,0 +1,52 @@
+-- A merged patient stays as a tombstone: its identifiers move to the survivor
+-- and merged_into redirects readers there. A survivor cannot be deleted
+-- while tombstones point to it, or the merge could no longer be reverted.
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS merged_into BIGINT;
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_merged_into_fkey;
+ALTER TABLE patients ADD CONSTRAINT patients_merged_into_fkey
+    FOREIGN KEY (merged_into) REFERENCES patients (id) ON DELETE RESTRICT;
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS merged_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_patients_merged_into ON patients (merged_into) WHERE merged_into IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_patients_hospital_phone_e164 ON patients (hospital, phone_e164) WHERE phone_e164 IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_patients_hospital_dob ON patients (hospital, date_of_birth) WHERE date_of_birth IS NOT NULL;
+
+-- Review queue of likely duplicates; patient_id is always the lower ID of the
+-- pair so a pair is queued once.
+CREATE TABLE IF NOT EXISTS patient_duplicates (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+    duplicate_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+    score REAL NOT NULL,
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    reviewed_by BIGINT REFERENCES staffs (id),
+    reviewed_at TIMESTAMPTZ,
+    CONSTRAINT chk_patient_duplicates_order CHECK (patient_id < duplicate_id),
+    CONSTRAINT chk_patient_duplicates_status CHECK (status IN ('pending', 'merged', 'dismissed')),
+    UNIQUE (hospital, patient_id, duplicate_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_duplicates_pending ON patient_duplicates (hospital, score DESC) WHERE status = 'pending';
+
+-- One row per merge. merged_before is the merged patient as it was, which is
+-- what a revert restores; the table has no foreign keys so the history
+-- outlives the patients.
+CREATE TABLE IF NOT EXISTS patient_merges (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    survivor_id BIGINT NOT NULL,
+    merged_id BIGINT NOT NULL,
+    staff_id BIGINT NOT NULL,
+    reason TEXT,
+    filled_fields TEXT[] NOT NULL DEFAULT '{}',
+    merged_before JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    reverted_by BIGINT,
+    reverted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_merges_survivor ON patient_merges (hospital, survivor_id);
+CREATE INDEX IF NOT EXISTS idx_patient_merges_merged ON patient_merges (hospital, merged_id);
@@ -0,0 +1,49 @@
+-- Every change to a patient is kept as a version. record is the patient as it
+-- was after the change, in the JSON form of the API, and fields the names of
+-- the fields that changed. Like patient_merges the table has no foreign keys,
+-- so the history outlives the patient.
+CREATE TABLE IF NOT EXISTS patient_versions (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    patient_id BIGINT NOT NULL,
+    version INT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    staff_id BIGINT,
+    restored_from INT,
+    fields TEXT[] NOT NULL DEFAULT '{}',
+    record JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT chk_patient_versions_source CHECK (source IN ('staff', 'his', 'system')),
+    UNIQUE (hospital, patient_id, version)
+);
+
+-- Existing patients start with an imported version holding their current
+-- record, so the first change after the upgrade has something to diff against.
+INSERT INTO patient_versions (hospital, patient_id, version, action, source, fields, record, created_at)
+SELECT p.hospital, p.id, 1, 'import', 'system',
+    ARRAY(SELECT k FROM jsonb_object_keys(r.record) k WHERE k NOT IN ('id', 'hospital') ORDER BY k),
+    r.record, p.updated_at
+FROM patients p
+CROSS JOIN LATERAL (
+    SELECT jsonb_strip_nulls(jsonb_build_object(
+        'id', p.id,
+        'hospital', p.hospital,
+        'first_name_th', p.first_name_th,
+        'middle_name_th', p.middle_name_th,
+        'last_name_th', p.last_name_th,
+        'first_name_en', p.first_name_en,
+        'middle_name_en', p.middle_name_en,
+        'last_name_en', p.last_name_en,
+        'date_of_birth', to_char(p.date_of_birth, 'YYYY-MM-DD') || 'T00:00:00Z',
+        'patient_hn', p.patient_hn,
+        'national_id', p.national_id,
+        'passport_id', p.passport_id,
+        'phone_number', p.phone_number,
+        'phone_e164', p.phone_e164,
+        'email', p.email,
+        'gender', p.gender,
+        'merged_into', p.merged_into
+    )) AS record
+) r
+WHERE NOT EXISTS (SELECT 1 FROM patient_versions v WHERE v.hospital = p.hospital AND v.patient_id = p.id);
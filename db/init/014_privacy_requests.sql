@@ -0,0 +1,40 @@
+-- Erasure anonymizes a patient in place: the row keeps its ID so audit
+-- entries, merges, duplicates and versions still refer to it, and every
+-- personal field is cleared.
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS erased_at TIMESTAMPTZ;
+
+-- PDPA data subject requests (export or erasure of a patient's data). Like
+-- patient_merges they have no foreign key to patients, so they outlive them.
+CREATE TABLE IF NOT EXISTS privacy_requests (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    patient_id BIGINT NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    note TEXT,
+    requested_by BIGINT NOT NULL REFERENCES staffs (id),
+    due_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    closed_by BIGINT REFERENCES staffs (id),
+    closed_at TIMESTAMPTZ,
+    resolution TEXT,
+    CONSTRAINT chk_privacy_requests_kind CHECK (kind IN ('export', 'erasure')),
+    CONSTRAINT chk_privacy_requests_status CHECK (status IN ('pending', 'completed', 'rejected'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_pending ON privacy_requests (hospital, due_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_patient ON privacy_requests (hospital, patient_id);
+
+-- A patient under an active legal hold cannot be erased.
+CREATE TABLE IF NOT EXISTS patient_legal_holds (
+    id BIGSERIAL PRIMARY KEY,
+    hospital VARCHAR(100) NOT NULL,
+    patient_id BIGINT NOT NULL,
+    reason TEXT NOT NULL,
+    placed_by BIGINT NOT NULL REFERENCES staffs (id),
+    placed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    released_by BIGINT REFERENCES staffs (id),
+    released_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_legal_holds_active ON patient_legal_holds (hospital, patient_id) WHERE released_at IS NULL;
@@ -0,0 +1,13 @@
+-- last_synced_at is when the patient was last stored from the hospital's HIS;
+-- NULL for patients only ever entered by staff.
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS last_synced_at TIMESTAMPTZ;
+
+UPDATE patients p
+SET last_synced_at = v.synced_at
+FROM (
+    SELECT hospital, patient_id, MAX(created_at) AS synced_at
+    FROM patient_versions
+    WHERE action = 'his_sync'
+    GROUP BY hospital, patient_id
+) v
+WHERE p.hospital = v.hospital AND p.id = v.patient_id AND p.last_synced_at IS NULL;
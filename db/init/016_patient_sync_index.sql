@@ -0,0 +1,4 @@
+-- Serves the sync worker's scan for the patients synced longest ago.
+CREATE INDEX IF NOT EXISTS idx_patients_last_synced
+    ON patients (hospital, last_synced_at)
+    WHERE last_synced_at IS NOT NULL AND merged_into IS NULL AND erased_at IS NULL;
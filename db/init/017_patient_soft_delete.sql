@@ -0,0 +1,3 @@
+-- A deleted patient keeps its row, and its versions, so the delete can be
+-- undone with a restore. deleted_at hides it from search and reads.
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
@@ -0,0 +1,21 @@
+-- A deleted patient no longer holds its national ID or passport: the same
+-- person can be registered again, and restoring the deleted record conflicts
+-- while the new one exists. The new indexes are created before the old ones
+-- are dropped, so the identifiers stay unique throughout.
+CREATE UNIQUE INDEX IF NOT EXISTS ux_patients_hospital_national_id_live
+    ON patients (hospital, national_id)
+    WHERE national_id IS NOT NULL AND deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS ux_patients_hospital_passport_id_live
+    ON patients (hospital, passport_id)
+    WHERE passport_id IS NOT NULL AND deleted_at IS NULL;
+
+DROP INDEX IF EXISTS ux_patients_hospital_national_id;
+DROP INDEX IF EXISTS ux_patients_hospital_passport_id;
+
+-- Match the sync worker's scan, which skips deleted patients.
+CREATE INDEX IF NOT EXISTS idx_patients_last_synced_live
+    ON patients (hospital, last_synced_at)
+    WHERE last_synced_at IS NOT NULL AND merged_into IS NULL AND erased_at IS NULL AND deleted_at IS NULL;
+
+DROP INDEX IF EXISTS idx_patients_last_synced;
@@ -0,0 +1,79 @@
+// Package auditchain computes the hash chain that makes the audit log
+// tamper-evident. Each entry's hash covers its content and the hash of the
+// previous entry of the same hospital, so editing, removing or reordering an
+// entry breaks every later link.
+package auditchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"agnos/internal/model"
+)
+
+// GenesisHash is the previous hash of the first entry of every chain.
+var GenesisHash = strings.Repeat("0", 64)
+
+type hashedFields struct {
+	Seq        int64           `json:"seq"`
+	Hospital   string          `json:"hospital"`
+	StaffID    int64           `json:"staff_id"`
+	Action     string          `json:"action"`
+	Criteria   json.RawMessage `json:"criteria"`
+	PatientIDs []int64         `json:"patient_ids"`
+	ClientIP   string          `json:"client_ip"`
+	RequestID  string          `json:"request_id"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+// Hash returns the hash of e chained to prevHash. Criteria is canonicalised
+// first, because Postgres JSONB does not keep key order or whitespace.
+func Hash(prevHash string, e model.AuditEntry) (string, error) {
+	criteria, err := canonicalJSON(e.Criteria)
+	if err != nil {
+		return "", err
+	}
+	patientIDs := e.PatientIDs
+	if patientIDs == nil {
+		patientIDs = []int64{}
+	}
+	body, err := json.Marshal(hashedFields{
+		Seq:        e.Seq,
+		Hospital:   e.Hospital,
+		StaffID:    e.StaffID,
+		Action:     e.Action,
+		Criteria:   criteria,
+		PatientIDs: patientIDs,
+		ClientIP:   e.ClientIP,
+		RequestID:  e.RequestID,
+		CreatedAt:  Timestamp(e.CreatedAt).Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(prevHash+"\n"), body...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Timestamp truncates t to the precision Postgres stores, so a hash computed
+// before insert matches one computed from the stored row.
+func Timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func canonicalJSON(raw json.RawMessage) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
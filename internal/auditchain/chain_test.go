@@ -0,0 +1,52 @@
+package auditchain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"agnos/internal/model"
+)
+
+func TestHashIgnoresJSONFormatting(t *testing.T) {
+	at := time.Date(2026, 1, 1, 9, 0, 0, 123456789, time.FixedZone("ICT", 7*3600))
+	e := model.AuditEntry{
+		Seq:        3,
+		Hospital:   "hospital-a",
+		StaffID:    7,
+		Action:     model.AuditActionPatientSearch,
+		Criteria:   json.RawMessage(`{"national_id":"1234567890123","email":null}`),
+		PatientIDs: []int64{1, 2},
+		CreatedAt:  at,
+	}
+	a, err := Hash(GenesisHash, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As read back from Postgres: JSONB reorders keys, timestamps are UTC
+	// with microsecond precision.
+	e.Criteria = json.RawMessage(`{"email": null, "national_id": "1234567890123"}`)
+	e.CreatedAt = Timestamp(at)
+	b, err := Hash(GenesisHash, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatalf("hash changed after storage round trip: %s != %s", a, b)
+	}
+}
+
+func TestHashCoversContentAndPrevious(t *testing.T) {
+	e := model.AuditEntry{Seq: 1, Hospital: "hospital-a", StaffID: 7, Action: model.AuditActionPatientSearch, PatientIDs: []int64{1}}
+	base, _ := Hash(GenesisHash, e)
+
+	edited := e
+	edited.PatientIDs = []int64{2}
+	if h, _ := Hash(GenesisHash, edited); h == base {
+		t.Fatal("editing patient ids did not change the hash")
+	}
+	if h, _ := Hash(base, e); h == base {
+		t.Fatal("previous hash is not part of the hash")
+	}
+}
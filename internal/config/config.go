@@ -1,38 +1,302 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"agnos/internal/his"
+	"agnos/internal/masking"
+	"agnos/internal/passwd"
 )
 
 type Config struct {
 	DatabaseURL      string
-	JWTSecret        string
-	TokenTTL         time.Duration
+	JWTKeysDir       string
+	JWTActiveKID     string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	TrustedProxies   []string
 	HospitalABaseURL string
-}
+	HIS              []his.Config
 
-func Load() Config {
-	ttlHours := 24
-	if v := os.Getenv("TOKEN_TTL_HOURS"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			ttlHours = n
-		}
-	}
+	HISSyncInterval time.Duration
+	HISSyncBatch    int
+	HISSyncPause    time.Duration
+
+	BootstrapAdminUsername string
+	BootstrapAdminPassword string
+	BootstrapAdminHospital string
+
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginLockout       time.Duration
+	LoginDelayBase     time.Duration
+	LoginDelayMax      time.Duration
+
+	MFAEncryptionKey     string
+	MFAIssuer            string
+	MFARequiredHospitals []string
+
+	PasswordMinLength  int
+	PasswordMinClasses int
+	PasswordBannedFile string
+	PasswordHashParams passwd.Params
+
+	AuditKeysDir            string
+	AuditActiveKID          string
+	AuditCheckpointInterval time.Duration
 
+	PatientMasking masking.Policies
+
+	PrivacyRequestDue time.Duration
+}
+
+func Load() (Config, error) {
 	cfg := Config{
 		DatabaseURL:      getenv("DATABASE_URL", "postgres://postgres:postgres@db:5432/agnos?sslmode=disable"),
-		JWTSecret:        getenv("JWT_SECRET", "ky2>B(#0sB65D9Mj"),
-		TokenTTL:         time.Duration(ttlHours) * time.Hour,
+		JWTKeysDir:       os.Getenv("JWT_KEYS_DIR"),
+		JWTActiveKID:     os.Getenv("JWT_ACTIVE_KID"),
+		AccessTokenTTL:   time.Duration(getenvInt("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute,
+		RefreshTokenTTL:  time.Duration(getenvInt("REFRESH_TOKEN_TTL_HOURS", 168)) * time.Hour,
+		TrustedProxies:   splitList(getenv("TRUSTED_PROXIES", "127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16")),
 		HospitalABaseURL: getenv("HOSPITAL_A_BASE_URL", "https://hospital-a.api.co.th"),
+
+		BootstrapAdminUsername: os.Getenv("BOOTSTRAP_ADMIN_USERNAME"),
+		BootstrapAdminPassword: os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"),
+		BootstrapAdminHospital: os.Getenv("BOOTSTRAP_ADMIN_HOSPITAL"),
+
+		LoginMaxFailures:   getenvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: getenvInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginLockout:       time.Duration(getenvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		LoginDelayBase:     time.Duration(getenvInt("LOGIN_DELAY_BASE_SECONDS", 1)) * time.Second,
+		LoginDelayMax:      time.Duration(getenvInt("LOGIN_DELAY_MAX_SECONDS", 30)) * time.Second,
+
+		MFAEncryptionKey:     os.Getenv("MFA_ENCRYPTION_KEY"),
+		MFAIssuer:            getenv("MFA_ISSUER", "Agnos"),
+		MFARequiredHospitals: splitList(os.Getenv("MFA_REQUIRED_HOSPITALS")),
+
+		PasswordMinLength:  getenvInt("PASSWORD_MIN_LENGTH", 12),
+		PasswordMinClasses: getenvInt("PASSWORD_MIN_CLASSES", 3),
+		PasswordBannedFile: os.Getenv("PASSWORD_BANNED_FILE"),
+		PasswordHashParams: passwd.Params{
+			Memory:      uint32(getenvInt("ARGON2_MEMORY_KIB", int(passwd.DefaultParams.Memory))),
+			Iterations:  uint32(getenvInt("ARGON2_ITERATIONS", int(passwd.DefaultParams.Iterations))),
+			Parallelism: uint8(getenvInt("ARGON2_PARALLELISM", int(passwd.DefaultParams.Parallelism))),
+		},
+
+		AuditKeysDir:            os.Getenv("AUDIT_KEYS_DIR"),
+		AuditActiveKID:          os.Getenv("AUDIT_ACTIVE_KID"),
+		AuditCheckpointInterval: time.Duration(getenvInt("AUDIT_CHECKPOINT_INTERVAL_MINUTES", 60)) * time.Minute,
+
+		PrivacyRequestDue: time.Duration(getenvInt("PRIVACY_REQUEST_DUE_DAYS", 30)) * 24 * time.Hour,
+
+		HISSyncInterval: time.Duration(getenvInt("HIS_SYNC_INTERVAL_MINUTES", 15)) * time.Minute,
+		HISSyncBatch:    getenvInt("HIS_SYNC_BATCH_SIZE", 100),
+		HISSyncPause:    time.Duration(getenvInt("HIS_SYNC_PAUSE_MS", 200)) * time.Millisecond,
 	}
+	cfg.HIS = loadHIS(cfg.HospitalABaseURL)
+	masks, err := loadMasking()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.PatientMasking = masks
+	return cfg, nil
+}
+
+// PasswordPolicy builds the password policy, reading the banned list from
+// PASSWORD_BANNED_FILE when it is set.
+func (c Config) PasswordPolicy() (passwd.Policy, error) {
+	policy := passwd.Policy{MinLength: c.PasswordMinLength, MinClasses: c.PasswordMinClasses}
+	if c.PasswordBannedFile != "" {
+		banned, err := passwd.LoadBanned(c.PasswordBannedFile)
+		if err != nil {
+			return passwd.Policy{}, err
+		}
+		policy.Banned = banned
+	}
+	return policy, nil
+}
+
+// loadHIS reads one HIS adapter per hospital code listed in HIS_HOSPITALS.
+// Settings for a hospital live under HIS_<CODE>_*, where CODE is the hospital
+// code upper-cased with non-alphanumerics replaced by "_". Without
+// HIS_HOSPITALS only Hospital A is wired, as before.
+func loadHIS(hospitalABaseURL string) []his.Config {
+	codes := splitList(os.Getenv("HIS_HOSPITALS"))
+	if len(codes) == 0 {
+		cfg := his.Config{Hospital: "hospital-a", Adapter: his.AdapterHospitalA, BaseURL: hospitalABaseURL, Auth: loadHISAuth("HIS_HOSPITAL_A_")}
+		return []his.Config{withHISResilience(cfg, "HIS_HOSPITAL_A_")}
+	}
+
+	configs := make([]his.Config, 0, len(codes))
+	for _, code := range codes {
+		prefix := "HIS_" + envKey(code) + "_"
+		configs = append(configs, withHISResilience(his.Config{
+			Hospital: code,
+			Adapter:  getenv(prefix+"ADAPTER", his.AdapterHospitalA),
+			BaseURL:  os.Getenv(prefix + "BASE_URL"),
+			Path:     os.Getenv(prefix + "PATH"),
+			Headers:  splitPairs(os.Getenv(prefix+"HEADERS"), ";"),
+			FieldMap: splitPairs(os.Getenv(prefix+"FIELD_MAP"), ","),
+			Auth:     loadHISAuth(prefix),
+		}, prefix))
+	}
+	return configs
+}
+
+// loadHISAuth reads the credentials of a hospital. A secret is read from the
+// variable itself or from the file named by the variable with _FILE appended.
+func loadHISAuth(prefix string) his.Auth {
+	return his.Auth{
+		Type:           os.Getenv(prefix + "AUTH"),
+		APIKeyHeader:   os.Getenv(prefix + "API_KEY_HEADER"),
+		APIKey:         his.Secret(getenvSecret(prefix + "API_KEY")),
+		TokenURL:       os.Getenv(prefix + "TOKEN_URL"),
+		ClientID:       os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret:   his.Secret(getenvSecret(prefix + "CLIENT_SECRET")),
+		Scopes:         strings.Fields(os.Getenv(prefix + "SCOPES")),
+		ClientCertFile: os.Getenv(prefix + "CLIENT_CERT_FILE"),
+		ClientKeyFile:  os.Getenv(prefix + "CLIENT_KEY_FILE"),
+		CAFile:         os.Getenv(prefix + "CA_FILE"),
+	}
+}
+
+// withHISResilience reads the timeouts, retry, circuit breaker, miss cache and
+// refresh settings of a hospital. RETRIES, MISS_TTL_SECONDS and
+// SYNC_TTL_MINUTES may be 0 to turn retries, the cache or refreshes off.
+func withHISResilience(cfg his.Config, prefix string) his.Config {
+	cfg.Timeout = time.Duration(getenvInt(prefix+"TIMEOUT_MS", int(his.DefaultTimeout/time.Millisecond))) * time.Millisecond
+	cfg.CallTimeout = time.Duration(getenvInt(prefix+"CALL_TIMEOUT_MS", int(his.DefaultCallTimeout/time.Millisecond))) * time.Millisecond
+	cfg.Retries = getenvCount(prefix+"RETRIES", his.DefaultRetries)
+	cfg.RetryBackoff = time.Duration(getenvInt(prefix+"RETRY_BACKOFF_MS", int(his.DefaultRetryBackoff/time.Millisecond))) * time.Millisecond
+	cfg.BreakerFailures = getenvInt(prefix+"BREAKER_FAILURES", his.DefaultBreakerFailures)
+	cfg.BreakerCooldown = time.Duration(getenvInt(prefix+"BREAKER_COOLDOWN_SECONDS", int(his.DefaultBreakerCooldown/time.Second))) * time.Second
+	cfg.MissTTL = time.Duration(getenvCount(prefix+"MISS_TTL_SECONDS", int(his.DefaultMissTTL/time.Second))) * time.Second
+	cfg.SyncTTL = time.Duration(getenvCount(prefix+"SYNC_TTL_MINUTES", int(his.DefaultSyncTTL/time.Minute))) * time.Minute
 	return cfg
 }
 
+// loadMasking reads the default masking policy from PATIENT_MASK_FIELDS and
+// PATIENT_UNMASKED_ROLES, and overrides for the hospitals listed in
+// PATIENT_MASK_HOSPITALS from PATIENT_MASK_<CODE>_FIELDS and
+// PATIENT_MASK_<CODE>_UNMASKED_ROLES. A field list of "none" turns masking
+// off; an unknown field name is an error.
+func loadMasking() (masking.Policies, error) {
+	def := masking.DefaultPolicy()
+	fields, err := maskFields("PATIENT_MASK_FIELDS", def.Fields)
+	if err != nil {
+		return masking.Policies{}, err
+	}
+	def.Fields = fields
+	if roles, ok := os.LookupEnv("PATIENT_UNMASKED_ROLES"); ok {
+		def.UnmaskedRoles = splitList(roles)
+	}
+
+	policies := masking.Policies{Default: def, Hospitals: make(map[string]masking.Policy)}
+	for _, code := range splitList(os.Getenv("PATIENT_MASK_HOSPITALS")) {
+		prefix := "PATIENT_MASK_" + envKey(code) + "_"
+		fields, err := maskFields(prefix+"FIELDS", def.Fields)
+		if err != nil {
+			return masking.Policies{}, err
+		}
+		policy := masking.Policy{Fields: fields, UnmaskedRoles: def.UnmaskedRoles}
+		if roles, ok := os.LookupEnv(prefix + "UNMASKED_ROLES"); ok {
+			policy.UnmaskedRoles = splitList(roles)
+		}
+		policies.Hospitals[code] = policy
+	}
+	return policies, nil
+}
+
+func maskFields(key string, fallback []string) ([]string, error) {
+	v := os.Getenv(key)
+	switch strings.TrimSpace(v) {
+	case "":
+		return fallback, nil
+	case "none":
+		return nil, nil
+	}
+	fields := splitList(v)
+	if err := masking.ValidateFields(fields); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return fields, nil
+}
+
 func getenv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return fallback
 }
+
+func getenvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// getenvSecret returns key, or the trimmed content of the file named by
+// key_FILE. An unreadable file yields an empty secret, which the HIS registry
+// then rejects at startup.
+func getenvSecret(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		if b, err := os.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(b))
+		}
+	}
+	return ""
+}
+
+// getenvCount is getenvInt that also accepts 0.
+func getenvCount(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envKey(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func splitList(v string) []string {
+	out := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func splitPairs(v, sep string) map[string]string {
+	out := make(map[string]string)
+	for _, item := range strings.Split(v, sep) {
+		k, val, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			continue
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	return out
+}
@@ -0,0 +1,299 @@
+package his
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	AuthNone   = ""
+	AuthAPIKey = "api-key"
+	AuthOAuth2 = "oauth2"
+
+	defaultAPIKeyHeader = "X-API-Key"
+
+	// tokenExpirySkew renews an OAuth2 token this long before it expires, so
+	// it does not expire in flight.
+	tokenExpirySkew = 30 * time.Second
+	// defaultTokenLifetime is used when the token endpoint does not say when
+	// the token expires.
+	defaultTokenLifetime = 5 * time.Minute
+	// tokenRequestTimeout bounds a token request, which is shared by every
+	// caller waiting for a token and so does not end with any one of them.
+	tokenRequestTimeout = 10 * time.Second
+)
+
+// Secret is a credential. It formats as "[redacted]", so a Config can be
+// printed or logged without leaking it.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func (s Secret) GoString() string { return `"` + s.String() + `"` }
+
+func (s Secret) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// Auth is how the service authenticates to a hospital's HIS. Type selects a
+// static API key sent in APIKeyHeader, or an OAuth2 client-credentials token
+// from TokenURL sent as a bearer token. Independently of Type, a client
+// certificate is presented for mutual TLS if ClientCertFile is set, and the
+// server certificate is checked against CAFile if set.
+type Auth struct {
+	Type string
+
+	APIKeyHeader string
+	APIKey       Secret
+
+	TokenURL     string
+	ClientID     string
+	ClientSecret Secret
+	Scopes       []string
+
+	ClientCertFile string
+	ClientKeyFile  string
+	CAFile         string
+}
+
+func validateAuth(cfg Config) error {
+	a := cfg.Auth
+	switch a.Type {
+	case AuthNone:
+	case AuthAPIKey:
+		if a.APIKey == "" {
+			return fmt.Errorf("his: api key is required for hospital %q", cfg.Hospital)
+		}
+	case AuthOAuth2:
+		if a.TokenURL == "" || a.ClientID == "" || a.ClientSecret == "" {
+			return fmt.Errorf("his: token url, client id and client secret are required for hospital %q", cfg.Hospital)
+		}
+	default:
+		return fmt.Errorf("his: unknown auth %q for hospital %q", a.Type, cfg.Hospital)
+	}
+	if (a.ClientCertFile == "") != (a.ClientKeyFile == "") {
+		return fmt.Errorf("his: client certificate and key must be set together for hospital %q", cfg.Hospital)
+	}
+	return nil
+}
+
+// withTLS returns client, or a copy of it whose transport presents the
+// client certificate and trusts the CA of auth. The files are read once, at
+// startup.
+func withTLS(auth Auth, hospital string, client *http.Client) (*http.Client, error) {
+	if auth.ClientCertFile == "" && auth.CAFile == "" {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if auth.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(auth.ClientCertFile, auth.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("his: client certificate for hospital %q: %w", hospital, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if auth.CAFile != "" {
+		pem, err := os.ReadFile(auth.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("his: ca file for hospital %q: %w", hospital, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("his: ca file for hospital %q has no certificates", hospital)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	base, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		base, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return nil, fmt.Errorf("his: mutual tls for hospital %q needs an *http.Transport", hospital)
+	}
+	transport := base.Clone()
+	transport.TLSClientConfig = tlsConfig
+	copied := *client
+	copied.Transport = transport
+	return &copied, nil
+}
+
+// authorizer adds credentials to a HIS request.
+type authorizer interface {
+	authorize(ctx context.Context, req *http.Request) error
+	// reset drops cached credentials after the HIS rejected them in req. It
+	// reports whether new ones may be obtained, so the request is worth
+	// repeating.
+	reset(req *http.Request) bool
+}
+
+func newAuthorizer(cfg Config, client *http.Client) authorizer {
+	switch cfg.Auth.Type {
+	case AuthAPIKey:
+		header := cfg.Auth.APIKeyHeader
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		return apiKeyAuth{header: header, key: cfg.Auth.APIKey}
+	case AuthOAuth2:
+		return &clientCredentialsAuth{
+			name:     cfg.Hospital,
+			tokenURL: cfg.Auth.TokenURL,
+			clientID: cfg.Auth.ClientID,
+			secret:   cfg.Auth.ClientSecret,
+			scopes:   cfg.Auth.Scopes,
+			client:   client,
+			now:      time.Now,
+		}
+	}
+	return nil
+}
+
+type apiKeyAuth struct {
+	header string
+	key    Secret
+}
+
+func (a apiKeyAuth) authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set(a.header, string(a.key))
+	return nil
+}
+
+func (a apiKeyAuth) reset(req *http.Request) bool { return false }
+
+// clientCredentialsAuth sends an OAuth2 access token obtained with the
+// client-credentials grant (RFC 6749 section 4.4). The token is cached until
+// shortly before it expires, or until the HIS rejects it. Callers that need a
+// new token at the same time share one token request, made without holding
+// the lock, so a slow token endpoint only delays the calls that need a token.
+type clientCredentialsAuth struct {
+	name     string
+	tokenURL string
+	clientID string
+	secret   Secret
+	scopes   []string
+	client   *http.Client
+	now      func() time.Time
+	fetches  singleflight.Group
+
+	mu      sync.Mutex
+	token   Secret
+	expires time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (a *clientCredentialsAuth) authorize(ctx context.Context, req *http.Request) error {
+	token, err := a.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	return nil
+}
+
+// reset drops the cached token only if it is the one req was rejected with;
+// a token fetched since then by another call is kept.
+func (a *clientCredentialsAuth) reset(req *http.Request) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req != nil && req.Header.Get("Authorization") == "Bearer "+string(a.token) {
+		a.token = ""
+	}
+	return true
+}
+
+func (a *clientCredentialsAuth) cached() (Secret, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.token, a.token != "" && a.now().Before(a.expires)
+}
+
+func (a *clientCredentialsAuth) currentToken(ctx context.Context) (Secret, error) {
+	if token, ok := a.cached(); ok {
+		return token, nil
+	}
+	ch := a.fetches.DoChan("token", func() (any, error) {
+		if token, ok := a.cached(); ok {
+			return token, nil
+		}
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenRequestTimeout)
+		defer cancel()
+		return a.fetch(fetchCtx)
+	})
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(Secret), nil
+	}
+}
+
+// fetch requests a new token and caches it.
+func (a *clientCredentialsAuth) fetch(ctx context.Context) (Secret, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.scopes) > 0 {
+		form.Set("scope", strings.Join(a.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(string(a.secret)))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	// The body of a failed token request is not read: it may echo the
+	// credentials back.
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Hospital: a.name + " token endpoint", Code: resp.StatusCode}
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("his: token response of %q: malformed json", a.name)
+	}
+	if tr.AccessToken == "" || (tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer")) {
+		return "", fmt.Errorf("his: token response of %q has no bearer token", a.name)
+	}
+
+	lifetime := defaultTokenLifetime
+	if tr.ExpiresIn > 0 {
+		lifetime = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	if lifetime > 2*tokenExpirySkew {
+		lifetime -= tokenExpirySkew
+	} else {
+		lifetime /= 2
+	}
+	token := Secret(tr.AccessToken)
+	a.mu.Lock()
+	a.token, a.expires = token, a.now().Add(lifetime)
+	a.mu.Unlock()
+	return token, nil
+}
@@ -0,0 +1,267 @@
+package his
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hospital-Key") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"first_name_en":"Somchai"}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{
+		Hospital: "hospital-a",
+		BaseURL:  srv.URL,
+		Auth:     Auth{Type: AuthAPIKey, APIKeyHeader: "X-Hospital-Key", APIKey: "s3cret"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := client.FetchByID(context.Background(), "1234567890121"); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var issued int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "agnos" || secret != "client-s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "patient.read" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+	}))
+	defer tokenSrv.Close()
+
+	// The HIS accepts the second token only, as if the first was revoked.
+	var hisCalls int32
+	hisSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hisCalls, 1)
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"first_name_en":"Somchai"}`))
+	}))
+	defer hisSrv.Close()
+
+	cfg := Config{
+		Hospital: "hospital-a",
+		BaseURL:  hisSrv.URL,
+		Auth:     Auth{Type: AuthOAuth2, TokenURL: tokenSrv.URL, ClientID: "agnos", ClientSecret: "client-s3cret", Scopes: []string{"patient.read"}},
+	}
+	client, err := NewClient(cfg, nil)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.FetchByID(context.Background(), "1234567890121"); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&issued); got != 2 {
+		t.Fatalf("expected the token to be cached and renewed once after a 401, got %d tokens", got)
+	}
+	if got := atomic.LoadInt32(&hisCalls); got != 4 {
+		t.Fatalf("expected one repeated call after the 401, got %d calls", got)
+	}
+
+	if s := fmt.Sprintf("%v %+v %#v", cfg, cfg, cfg); strings.Contains(s, "client-s3cret") {
+		t.Fatalf("secret leaked when formatting config: %s", s)
+	}
+}
+
+func TestOAuth2SharesTokenRequests(t *testing.T) {
+	var issued int32
+	release := make(chan struct{})
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		n := atomic.AddInt32(&issued, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, n)
+	}))
+	defer tokenSrv.Close()
+
+	a := newAuthorizer(Config{
+		Hospital: "hospital-a",
+		Auth:     Auth{Type: AuthOAuth2, TokenURL: tokenSrv.URL, ClientID: "agnos", ClientSecret: "client-s3cret"},
+	}, http.DefaultClient).(*clientCredentialsAuth)
+
+	// A caller that gives up does not hold up the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.currentToken(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]Secret, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = a.currentToken(context.Background())
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, token := range tokens {
+		if token != "token-1" {
+			t.Fatalf("expected one shared token, got %v", tokens)
+		}
+	}
+
+	// A 401 for a token that was already replaced keeps the new one.
+	stale := httptest.NewRequest(http.MethodGet, "/", nil)
+	stale.Header.Set("Authorization", "Bearer token-0")
+	a.reset(stale)
+	if token, ok := a.cached(); !ok || token != "token-1" {
+		t.Fatalf("expected the current token to survive a stale reset, got %q", token)
+	}
+	current := httptest.NewRequest(http.MethodGet, "/", nil)
+	current.Header.Set("Authorization", "Bearer token-1")
+	a.reset(current)
+	if _, ok := a.cached(); ok {
+		t.Fatal("expected the rejected token to be dropped")
+	}
+	if got := atomic.LoadInt32(&issued); got != 1 {
+		t.Fatalf("expected a single token request, got %d", got)
+	}
+}
+
+func TestOAuth2TokenFailureDoesNotLeakSecret(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_client","client_secret":"client-s3cret"}`))
+	}))
+	defer tokenSrv.Close()
+
+	client, err := NewClient(Config{
+		Hospital: "hospital-a",
+		BaseURL:  "http://his.invalid",
+		Auth:     Auth{Type: AuthOAuth2, TokenURL: tokenSrv.URL, ClientID: "agnos", ClientSecret: "client-s3cret"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	_, err = client.FetchByID(context.Background(), "1234567890121")
+	if err == nil || strings.Contains(err.Error(), "client-s3cret") {
+		t.Fatalf("expected a token error without the secret, got %v", err)
+	}
+}
+
+func TestRejectsIncompleteAuth(t *testing.T) {
+	for _, auth := range []Auth{
+		{Type: AuthAPIKey},
+		{Type: AuthOAuth2, TokenURL: "https://idp.example/token", ClientID: "agnos"},
+		{Type: "basic"},
+		{ClientCertFile: "client.pem"},
+	} {
+		if _, err := NewClient(Config{Hospital: "hospital-a", BaseURL: "https://his.example", Auth: auth}, nil); err == nil {
+			t.Fatalf("expected error for %+v", auth)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := newTestCert(t, nil, nil, "test ca")
+	clientCert, clientKey := newTestCert(t, caCert, caKey, "agnos")
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caCert.Raw)
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", clientCert.Raw)
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", keyDER)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"first_name_en":"Somchai"}`))
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+	writePEM(t, filepath.Join(dir, "server-ca.pem"), "CERTIFICATE", srv.Certificate().Raw)
+
+	cfg := Config{Hospital: "hospital-a", BaseURL: srv.URL, Auth: Auth{CAFile: filepath.Join(dir, "server-ca.pem")}}
+	client, err := NewClient(cfg, nil)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := client.FetchByID(context.Background(), "1234567890121"); err == nil {
+		t.Fatalf("expected the server to require a client certificate")
+	}
+
+	cfg.Auth.ClientCertFile, cfg.Auth.ClientKeyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	client, err = NewClient(cfg, nil)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := client.FetchByID(context.Background(), "1234567890121"); err != nil {
+		t.Fatalf("fetch with client certificate: %v", err)
+	}
+}
+
+func newTestCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,108 @@
+package his
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var ErrBreakerOpen = errors.New("his: circuit breaker is open")
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored is a call that says nothing about the HIS, such as one
+	// abandoned by the caller.
+	outcomeIgnored
+)
+
+// breaker opens after threshold consecutive failed calls and then fails fast
+// until cooldown has passed. The next call is let through as a trial: it
+// closes the breaker if it succeeds and opens it again if it fails.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	counts   map[string]int64
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now, state: BreakerClosed, counts: map[string]int64{}}
+}
+
+// allow reports whether a call may go ahead.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			b.counts[ResultRejected]++
+			return ErrBreakerOpen
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		// A trial call is in flight.
+		b.counts[ResultRejected]++
+		return ErrBreakerOpen
+	}
+	return nil
+}
+
+// record reports the outcome of a call allowed by allow.
+func (b *breaker) record(o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch o {
+	case outcomeSuccess:
+		b.counts[ResultSuccess]++
+		b.state, b.failures = BreakerClosed, 0
+	case outcomeFailure:
+		b.counts[ResultFailure]++
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+			b.state, b.openedAt = BreakerOpen, b.now()
+		}
+	case outcomeIgnored:
+		if b.state == BreakerHalfOpen {
+			// Let the next call be the trial instead.
+			b.state, b.openedAt = BreakerOpen, b.now().Add(-b.cooldown)
+		}
+	}
+}
+
+func (b *breaker) retried() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts[resultRetry]++
+}
+
+func (b *breaker) status(hospital string) Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Status{
+		Hospital:            hospital,
+		Breaker:             b.state,
+		ConsecutiveFailures: b.failures,
+		Requests:            map[string]int64{ResultSuccess: b.counts[ResultSuccess], ResultFailure: b.counts[ResultFailure], ResultRejected: b.counts[ResultRejected]},
+		Retries:             b.counts[resultRetry],
+	}
+	if b.state != BreakerClosed {
+		opened := b.openedAt
+		s.OpenedAt = &opened
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package his
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"agnos/internal/model"
+)
+
+// maxMisses bounds the misses remembered per hospital, so a stream of unknown
+// identifiers cannot grow the cache without limit.
+const maxMisses = 10000
+
+// missCachingClient remembers for ttl that the HIS has no patient with an
+// identifier, so repeated searches for it do not reach the HIS. Once max
+// misses are remembered the oldest is forgotten first.
+type missCachingClient struct {
+	client Client
+	ttl    time.Duration
+	max    int
+	now    func() time.Time
+
+	mu     sync.Mutex
+	misses map[string]missEntry
+	// order holds the misses oldest first. An identifier recorded again is
+	// listed twice; only its latest entry removes it from misses.
+	order []missKey
+}
+
+type missEntry struct {
+	err     error
+	expires time.Time
+}
+
+type missKey struct {
+	id      string
+	expires time.Time
+}
+
+func newMissCachingClient(client Client, ttl time.Duration) *missCachingClient {
+	return &missCachingClient{client: client, ttl: ttl, max: maxMisses, now: time.Now, misses: map[string]missEntry{}}
+}
+
+func (c *missCachingClient) FetchByID(ctx context.Context, id string) (model.Patient, error) {
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.misses[id]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return model.Patient{}, entry.err
+	}
+
+	p, err := c.client.FetchByID(ctx, id)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		c.remember(id, err, now)
+	}
+	return p, err
+}
+
+// remember records a miss, first dropping the misses that expired and, while
+// the cache is full, the oldest ones. Every miss lives for the same ttl, so
+// the oldest entry is also the first to expire.
+func (c *missCachingClient) remember(id string, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.order) > 0 && (!now.Before(c.order[0].expires) || len(c.misses) >= c.max) {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		if e, ok := c.misses[oldest.id]; ok && e.expires.Equal(oldest.expires) {
+			delete(c.misses, oldest.id)
+		}
+	}
+	expires := now.Add(c.ttl)
+	c.misses[id] = missEntry{err: err, expires: expires}
+	c.order = append(c.order, missKey{id: id, expires: expires})
+}
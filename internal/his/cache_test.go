@@ -0,0 +1,71 @@
+package his
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMissCacheRemembersNotFound(t *testing.T) {
+	client, _, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/patient/search/1234567890121" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, Config{Retries: -1, MissTTL: time.Minute})
+
+	cache := client.(*missCachingClient)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.FetchByID(context.Background(), "1234567890121"); err == nil {
+			t.Fatalf("expected not found")
+		}
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("expected one HIS call for repeated misses, got %d", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _ = client.FetchByID(context.Background(), "AA123456")
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Fatalf("expected failures other than 404 not to be cached, got %d calls", got)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := client.FetchByID(context.Background(), "1234567890121"); err == nil {
+		t.Fatalf("expected not found")
+	}
+	if got := atomic.LoadInt32(calls); got != 4 {
+		t.Fatalf("expected the miss to expire, got %d calls", got)
+	}
+}
+
+func TestMissCacheIsBounded(t *testing.T) {
+	client, _, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}, Config{Retries: -1, MissTTL: time.Hour})
+
+	cache := client.(*missCachingClient)
+	cache.max = 2
+	for _, id := range []string{"AA1", "AA2", "AA3"} {
+		_, _ = client.FetchByID(context.Background(), id)
+	}
+	if len(cache.misses) != 2 {
+		t.Fatalf("expected the cache to hold 2 misses, got %d", len(cache.misses))
+	}
+
+	_, _ = client.FetchByID(context.Background(), "AA3")
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Fatalf("expected the newest miss to stay cached, got %d calls", got)
+	}
+	_, _ = client.FetchByID(context.Background(), "AA1")
+	if got := atomic.LoadInt32(calls); got != 4 {
+		t.Fatalf("expected the oldest miss to be evicted, got %d calls", got)
+	}
+}
@@ -0,0 +1,111 @@
+package his
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"agnos/internal/identifier"
+	"agnos/internal/model"
+)
+
+// Client fetches a patient from a hospital's HIS. The fetch is abandoned when
+// ctx is done.
+type Client interface {
+	FetchByID(ctx context.Context, id string) (model.Patient, error)
+}
+
+// Config describes one hospital's HIS. Timeout bounds each attempt and
+// CallTimeout the whole call, retries and backoff included; a call is retried
+// up to Retries times on transient failures, starting after RetryBackoff.
+// After BreakerFailures failed calls in a row the breaker fails calls fast for
+// BreakerCooldown. Zero values take the Default* settings. A 404 for an
+// identifier is remembered for MissTTL, and a stored patient is refreshed once
+// it is older than SyncTTL, if positive. Auth holds the credentials; Headers
+// must not, as they are not redacted.
+type Config struct {
+	Hospital string
+	Adapter  string
+	BaseURL  string
+	Path     string
+	Headers  map[string]string
+	FieldMap map[string]string
+	Auth     Auth
+
+	Timeout         time.Duration
+	CallTimeout     time.Duration
+	Retries         int
+	RetryBackoff    time.Duration
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	MissTTL         time.Duration
+	SyncTTL         time.Duration
+}
+
+type AdapterFactory func(cfg Config, httpClient *http.Client) (Client, error)
+
+const (
+	AdapterHospitalA = "hospital-a"
+	AdapterRESTJSON  = "rest-json"
+)
+
+var adapters = map[string]AdapterFactory{
+	AdapterHospitalA: newHospitalAAdapter,
+	AdapterRESTJSON:  newRESTJSONAdapter,
+}
+
+func NewClient(cfg Config, httpClient *http.Client) (Client, error) {
+	name := strings.TrimSpace(cfg.Adapter)
+	if name == "" {
+		name = AdapterHospitalA
+	}
+	factory, ok := adapters[name]
+	if !ok {
+		return nil, fmt.Errorf("his: unknown adapter %q for hospital %q", name, cfg.Hospital)
+	}
+	if strings.TrimSpace(cfg.BaseURL) == "" {
+		return nil, fmt.Errorf("his: base url is required for hospital %q", cfg.Hospital)
+	}
+	if err := validateAuth(cfg); err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = NewHTTPClient()
+	}
+	httpClient, err := withTLS(cfg.Auth, cfg.Hospital, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return factory(cfg, httpClient)
+}
+
+// NewHTTPClient returns the client used for HIS calls. Unlike
+// http.DefaultClient it gives up on a connection or TLS handshake that hangs,
+// even when the caller set no deadline.
+func NewHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = dialTimeout
+	transport.MaxIdleConnsPerHost = 16
+	return &http.Client{Transport: transport}
+}
+
+// normalizeIdentifiers rewrites the IDs returned by a HIS into their canonical
+// form. A record with an invalid national ID is rejected rather than stored.
+func normalizeIdentifiers(p model.Patient) (model.Patient, error) {
+	if p.NationalID != nil && strings.TrimSpace(*p.NationalID) != "" {
+		id, err := identifier.NationalID(*p.NationalID)
+		if err != nil {
+			return model.Patient{}, fmt.Errorf("his: %w", err)
+		}
+		p.NationalID = &id
+	}
+	if p.PassportID != nil && strings.TrimSpace(*p.PassportID) != "" {
+		id := identifier.Passport(*p.PassportID)
+		p.PassportID = &id
+	}
+	return p, nil
+}
@@ -0,0 +1,91 @@
+package his
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type endpoint struct {
+	name    string
+	baseURL string
+	path    string
+	headers map[string]string
+	auth    authorizer
+	client  *http.Client
+}
+
+func newEndpoint(cfg Config, defaultPath string, client *http.Client) endpoint {
+	path := strings.TrimSpace(cfg.Path)
+	if path == "" {
+		path = defaultPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	headers := make(map[string]string, len(cfg.Headers))
+	for k, v := range cfg.Headers {
+		headers[k] = v
+	}
+	return endpoint{
+		name:    cfg.Hospital,
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		path:    path,
+		headers: headers,
+		auth:    newAuthorizer(cfg, client),
+		client:  client,
+	}
+}
+
+// StatusError is a HIS response other than 200.
+type StatusError struct {
+	Hospital string
+	Code     int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s responded with %d", e.Hospital, e.Code)
+}
+
+// Temporary reports whether the status is worth retrying.
+func (e *StatusError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+func (e endpoint) get(ctx context.Context, id string) (*http.Response, error) {
+	resp, err := e.do(ctx, id)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && e.auth != nil && e.auth.reset(resp.Request) {
+		// The cached token was revoked or expired early; try once with a
+		// new one.
+		resp.Body.Close()
+		resp, err = e.do(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{Hospital: e.name, Code: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+func (e endpoint) do(ctx context.Context, id string) (*http.Response, error) {
+	u := e.baseURL + strings.ReplaceAll(e.path, "{id}", url.PathEscape(id))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	if e.auth != nil {
+		if err := e.auth.authorize(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	return e.client.Do(req)
+}
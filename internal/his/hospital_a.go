@@ -1,23 +1,20 @@
 package his
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
 	"agnos/internal/model"
 )
 
-type HospitalAClient interface {
-	FetchByID(id string) (model.Patient, error)
-}
+const hospitalADefaultPath = "/patient/search/{id}"
 
 type hospitalAClient struct {
-	baseURL string
-	client  *http.Client
+	endpoint endpoint
 }
 
 type hospitalAResponse struct {
@@ -36,60 +33,58 @@ type hospitalAResponse struct {
 	Gender       *string `json:"gender"`
 }
 
-func NewHospitalAClient(baseURL string, client *http.Client) HospitalAClient {
+func NewHospitalAClient(baseURL string, client *http.Client) Client {
 	if client == nil {
 		client = http.DefaultClient
 	}
-	return &hospitalAClient{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+	return &hospitalAClient{endpoint: newEndpoint(Config{Hospital: AdapterHospitalA, BaseURL: baseURL}, hospitalADefaultPath, client)}
+}
+
+func newHospitalAAdapter(cfg Config, httpClient *http.Client) (Client, error) {
+	return &hospitalAClient{endpoint: newEndpoint(cfg, hospitalADefaultPath, httpClient)}, nil
 }
 
-func (c *hospitalAClient) FetchByID(id string) (model.Patient, error) {
+func (c *hospitalAClient) FetchByID(ctx context.Context, id string) (model.Patient, error) {
 	id = strings.TrimSpace(id)
 	if id == "" {
 		return model.Patient{}, fmt.Errorf("id is required")
 	}
 
-	u := c.baseURL + "/patient/search/" + url.PathEscape(id)
-	req, err := http.NewRequest(http.MethodGet, u, nil)
-	if err != nil {
-		return model.Patient{}, err
-	}
-
-	resp, err := c.client.Do(req)
+	resp, err := c.endpoint.get(ctx, id)
 	if err != nil {
 		return model.Patient{}, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return model.Patient{}, fmt.Errorf("hospital-a responded with %d", resp.StatusCode)
-	}
-
 	var payload hospitalAResponse
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
 		return model.Patient{}, err
 	}
 
-	var dob *time.Time
-	if payload.DateOfBirth != nil && strings.TrimSpace(*payload.DateOfBirth) != "" {
-		if t, err := time.Parse("2006-01-02", strings.TrimSpace(*payload.DateOfBirth)); err == nil {
-			dob = &t
-		}
-	}
-
-	return model.Patient{
+	return normalizeIdentifiers(model.Patient{
 		FirstNameTH:  payload.FirstNameTH,
 		MiddleNameTH: payload.MiddleNameTH,
 		LastNameTH:   payload.LastNameTH,
 		FirstNameEN:  payload.FirstNameEN,
 		MiddleNameEN: payload.MiddleNameEN,
 		LastNameEN:   payload.LastNameEN,
-		DateOfBirth:  dob,
+		DateOfBirth:  parseDate(payload.DateOfBirth),
 		PatientHN:    payload.PatientHN,
 		NationalID:   payload.NationalID,
 		PassportID:   payload.PassportID,
 		PhoneNumber:  payload.PhoneNumber,
 		Email:        payload.Email,
 		Gender:       payload.Gender,
-	}, nil
+	})
+}
+
+func parseDate(v *string) *time.Time {
+	if v == nil || strings.TrimSpace(*v) == "" {
+		return nil
+	}
+	t, err := time.Parse("2006-01-02", strings.TrimSpace(*v))
+	if err != nil {
+		return nil
+	}
+	return &t
 }
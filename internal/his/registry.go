@@ -0,0 +1,91 @@
+package his
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Registry selects the HIS client of a hospital and reports the state of
+// their circuit breakers. SyncTTL is how long a patient stored from a
+// hospital's HIS stays fresh; zero means it is never refreshed.
+type Registry interface {
+	ClientFor(hospital string) (Client, bool)
+	Hospitals() []string
+	SyncTTL(hospital string) time.Duration
+	Status() []Status
+}
+
+type registry struct {
+	clients  map[string]Client
+	breakers map[string]*breaker
+	syncTTLs map[string]time.Duration
+}
+
+func NewRegistry(configs []Config, httpClient *http.Client) (Registry, error) {
+	clients := make(map[string]Client, len(configs))
+	breakers := make(map[string]*breaker, len(configs))
+	syncTTLs := make(map[string]time.Duration, len(configs))
+	for _, cfg := range configs {
+		hospital := strings.TrimSpace(cfg.Hospital)
+		if hospital == "" {
+			return nil, fmt.Errorf("his: hospital code is required")
+		}
+		if _, exists := clients[hospital]; exists {
+			return nil, fmt.Errorf("his: duplicate configuration for hospital %q", hospital)
+		}
+		client, err := NewClient(cfg, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		resilient := newResilientClient(client, cfg)
+		clients[hospital], breakers[hospital] = resilient, resilient.breaker
+		if cfg.MissTTL > 0 {
+			clients[hospital] = newMissCachingClient(resilient, cfg.MissTTL)
+		}
+		syncTTLs[hospital] = cfg.SyncTTL
+	}
+	return &registry{clients: clients, breakers: breakers, syncTTLs: syncTTLs}, nil
+}
+
+// NewStaticRegistry returns a registry of clients used as they are, without
+// timeouts, retries, breakers or refreshes.
+func NewStaticRegistry(clients map[string]Client) Registry {
+	copied := make(map[string]Client, len(clients))
+	for hospital, client := range clients {
+		copied[hospital] = client
+	}
+	return &registry{clients: copied}
+}
+
+func (r *registry) ClientFor(hospital string) (Client, bool) {
+	client, ok := r.clients[strings.TrimSpace(hospital)]
+	return client, ok
+}
+
+// Hospitals returns the codes of the configured hospitals, sorted.
+func (r *registry) Hospitals() []string {
+	out := make([]string, 0, len(r.clients))
+	for hospital := range r.clients {
+		out = append(out, hospital)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *registry) SyncTTL(hospital string) time.Duration {
+	return r.syncTTLs[strings.TrimSpace(hospital)]
+}
+
+// Status returns the breaker state of every configured hospital, by hospital
+// code.
+func (r *registry) Status() []Status {
+	out := make([]Status, 0, len(r.breakers))
+	for hospital, b := range r.breakers {
+		out = append(out, b.status(hospital))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hospital < out[j].Hospital })
+	return out
+}
@@ -0,0 +1,104 @@
+package his
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"agnos/internal/identifier"
+)
+
+func TestRegistryRoutesByHospital(t *testing.T) {
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/patient/search/1234567890121" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"first_name_en":"Somchai","national_id":"1234567890121"}`))
+	}))
+	defer srvA.Close()
+
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/people/P123" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "k" {
+			t.Fatalf("expected configured header")
+		}
+		_, _ = w.Write([]byte(`{"name":{"given":"Jane"},"dob":"1990-01-02","passport":"P123"}`))
+	}))
+	defer srvB.Close()
+
+	reg, err := NewRegistry([]Config{
+		{Hospital: "hospital-a", Adapter: AdapterHospitalA, BaseURL: srvA.URL, SyncTTL: time.Hour},
+		{
+			Hospital: "hospital-b",
+			Adapter:  AdapterRESTJSON,
+			BaseURL:  srvB.URL,
+			Path:     "/v2/people/{id}",
+			Headers:  map[string]string{"X-Api-Key": "k"},
+			FieldMap: map[string]string{"first_name_en": "name.given", "date_of_birth": "dob", "passport_id": "passport"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+
+	a, ok := reg.ClientFor("hospital-a")
+	if !ok {
+		t.Fatalf("expected client for hospital-a")
+	}
+	p, err := a.FetchByID(context.Background(), "1234567890121")
+	if err != nil || p.FirstNameEN == nil || *p.FirstNameEN != "Somchai" {
+		t.Fatalf("unexpected hospital-a result %+v, err=%v", p, err)
+	}
+
+	b, ok := reg.ClientFor("hospital-b")
+	if !ok {
+		t.Fatalf("expected client for hospital-b")
+	}
+	p, err = b.FetchByID(context.Background(), "P123")
+	if err != nil {
+		t.Fatalf("fetch hospital-b: %v", err)
+	}
+	if p.FirstNameEN == nil || *p.FirstNameEN != "Jane" || p.PassportID == nil || *p.PassportID != "P123" || p.DateOfBirth == nil {
+		t.Fatalf("field map not applied: %+v", p)
+	}
+
+	if _, ok := reg.ClientFor("hospital-c"); ok {
+		t.Fatalf("expected no client for unconfigured hospital")
+	}
+	if got := reg.Hospitals(); len(got) != 2 || got[0] != "hospital-a" || got[1] != "hospital-b" {
+		t.Fatalf("unexpected hospitals %v", got)
+	}
+	if reg.SyncTTL("hospital-a") != time.Hour || reg.SyncTTL("hospital-b") != 0 {
+		t.Fatalf("unexpected sync TTLs")
+	}
+}
+
+func TestRegistryRejectsUnknownAdapter(t *testing.T) {
+	if _, err := NewRegistry([]Config{{Hospital: "x", Adapter: "soap", BaseURL: "http://x"}}, nil); err == nil {
+		t.Fatalf("expected error for unknown adapter")
+	}
+}
+
+func TestFetchNormalizesIdentifiers(t *testing.T) {
+	body := `{"national_id":"1-2345-67890-12-1","passport_id":"aa 123456"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	client := NewHospitalAClient(srv.URL, nil)
+	p, err := client.FetchByID(context.Background(), "1234567890121")
+	if err != nil || p.NationalID == nil || *p.NationalID != "1234567890121" || p.PassportID == nil || *p.PassportID != "AA123456" {
+		t.Fatalf("unexpected result %+v, err=%v", p, err)
+	}
+
+	body = `{"national_id":"1234567890123"}`
+	if _, err := client.FetchByID(context.Background(), "1234567890123"); !errors.Is(err, identifier.ErrInvalidNationalID) {
+		t.Fatalf("expected invalid national id error, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package his
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"agnos/internal/model"
+)
+
+const (
+	DefaultTimeout         = 3 * time.Second
+	DefaultCallTimeout     = 5 * time.Second
+	DefaultRetries         = 2
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30 * time.Second
+	DefaultMissTTL         = time.Minute
+	DefaultSyncTTL         = 24 * time.Hour
+
+	maxRetryBackoff = 2 * time.Second
+	dialTimeout     = 5 * time.Second
+)
+
+// Results counted per hospital. A rejected call was failed fast by the open
+// circuit breaker without reaching the HIS.
+const (
+	ResultSuccess  = "success"
+	ResultFailure  = "failure"
+	ResultRejected = "rejected"
+
+	resultRetry = "retry"
+)
+
+// Status is the circuit breaker state of a hospital's HIS and the number of
+// calls by result since the server started.
+type Status struct {
+	Hospital            string           `json:"hospital"`
+	Breaker             string           `json:"breaker"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	OpenedAt            *time.Time       `json:"opened_at,omitempty"`
+	Requests            map[string]int64 `json:"requests"`
+	Retries             int64            `json:"retries"`
+}
+
+// resilientClient bounds every attempt of an adapter with a timeout, retries
+// transient failures with jittered exponential backoff within an overall
+// deadline and fails fast through a circuit breaker while the HIS is down.
+type resilientClient struct {
+	client      Client
+	hospital    string
+	timeout     time.Duration
+	callTimeout time.Duration
+	retries     int
+	backoff     time.Duration
+	breaker     *breaker
+	sleep       func(ctx context.Context, d time.Duration) error
+}
+
+func newResilientClient(client Client, cfg Config) *resilientClient {
+	cfg = withDefaults(cfg)
+	return &resilientClient{
+		client:      client,
+		hospital:    cfg.Hospital,
+		timeout:     cfg.Timeout,
+		callTimeout: cfg.CallTimeout,
+		retries:     cfg.Retries,
+		backoff:     cfg.RetryBackoff,
+		breaker:     newBreaker(cfg.BreakerFailures, cfg.BreakerCooldown),
+		sleep:       sleep,
+	}
+}
+
+func withDefaults(cfg Config) Config {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.CallTimeout <= 0 {
+		cfg.CallTimeout = DefaultCallTimeout
+	}
+	if cfg.CallTimeout < cfg.Timeout {
+		cfg.CallTimeout = cfg.Timeout
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.BreakerFailures <= 0 {
+		cfg.BreakerFailures = DefaultBreakerFailures
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = DefaultBreakerCooldown
+	}
+	return cfg
+}
+
+func (c *resilientClient) FetchByID(ctx context.Context, id string) (model.Patient, error) {
+	if err := c.breaker.allow(); err != nil {
+		return model.Patient{}, fmt.Errorf("%w for hospital %q", err, c.hospital)
+	}
+	// ctx tells whether the caller went away; callCtx also ends at the
+	// overall deadline, which is a failure of the HIS.
+	callCtx, cancel := context.WithTimeout(ctx, c.callTimeout)
+	defer cancel()
+	var p model.Patient
+	var err error
+	for attempt := 0; ; attempt++ {
+		p, err = c.attempt(callCtx, id)
+		if err == nil || attempt >= c.retries || !transient(ctx, err) || callCtx.Err() != nil {
+			break
+		}
+		if werr := c.sleep(callCtx, retryDelay(c.backoff, attempt)); werr != nil {
+			break
+		}
+		c.breaker.retried()
+	}
+	c.breaker.record(classify(ctx, err))
+	return p, err
+}
+
+func (c *resilientClient) attempt(ctx context.Context, id string) (model.Patient, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.client.FetchByID(ctx, id)
+}
+
+// transient reports whether a failed attempt may succeed if repeated: the
+// HIS could not be reached, did not answer in time, or answered with a
+// server error or 429. A caller that went away is never retried.
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// classify maps the result of a call to its effect on the breaker. Only
+// transient failures count against the HIS; a 404 or a record that fails
+// validation still shows the HIS is up.
+func classify(ctx context.Context, err error) outcome {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case ctx.Err() != nil:
+		return outcomeIgnored
+	case transient(ctx, err):
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// retryDelay is the backoff before retry attempt+1: base doubled per attempt,
+// capped, with the upper half randomized so callers do not retry in step.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d > maxRetryBackoff || d <= 0 {
+		d = maxRetryBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package his
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRegistry(t *testing.T, handler http.HandlerFunc, cfg Config) (Client, Registry, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg.Hospital, cfg.Adapter, cfg.BaseURL = "hospital-a", AdapterHospitalA, srv.URL
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	reg, err := NewRegistry([]Config{cfg}, nil)
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	client, _ := reg.ClientFor("hospital-a")
+	return client, reg, &calls
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	var n int32
+	client, reg, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"first_name_en":"Somchai"}`))
+	}, Config{Retries: 2})
+
+	p, err := client.FetchByID(context.Background(), "1234567890121")
+	if err != nil || p.FirstNameEN == nil {
+		t.Fatalf("expected success after retries, got %+v, err=%v", p, err)
+	}
+	if atomic.LoadInt32(calls) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", atomic.LoadInt32(calls))
+	}
+	s := reg.Status()[0]
+	if s.Retries != 2 || s.Requests[ResultSuccess] != 1 || s.Breaker != BreakerClosed {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	client, reg, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}, Config{Retries: 2, BreakerFailures: 1})
+
+	_, err := client.FetchByID(context.Background(), "1234567890121")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 status error, got %v", err)
+	}
+	if atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("expected a single attempt, got %d", atomic.LoadInt32(calls))
+	}
+	if s := reg.Status()[0]; s.Breaker != BreakerClosed {
+		t.Fatalf("a 404 must not open the breaker: %+v", s)
+	}
+}
+
+func TestTimesOutEachAttempt(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	client, _, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}, Config{Timeout: 20 * time.Millisecond, Retries: 1})
+
+	start := time.Now()
+	_, err := client.FetchByID(context.Background(), "1234567890121")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if atomic.LoadInt32(calls) != 2 || time.Since(start) > time.Second {
+		t.Fatalf("expected 2 bounded attempts, got %d in %s", atomic.LoadInt32(calls), time.Since(start))
+	}
+}
+
+func TestCallTimeoutBoundsRetries(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	client, reg, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}, Config{Timeout: 30 * time.Millisecond, CallTimeout: 50 * time.Millisecond, Retries: 5})
+
+	start := time.Now()
+	_, err := client.FetchByID(context.Background(), "1234567890121")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if n := atomic.LoadInt32(calls); n > 2 || time.Since(start) > time.Second {
+		t.Fatalf("expected the call deadline to stop retries, got %d attempts in %s", n, time.Since(start))
+	}
+	if s := reg.Status()[0]; s.Requests[ResultFailure] != 1 {
+		t.Fatalf("expected the deadline to count as a failure: %+v", s)
+	}
+}
+
+func TestBreakerOpensAndFailsFast(t *testing.T) {
+	client, reg, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}, Config{Retries: -1, BreakerFailures: 2, BreakerCooldown: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.FetchByID(context.Background(), "1234567890121"); err == nil {
+			t.Fatalf("expected failure")
+		}
+	}
+	if _, err := client.FetchByID(context.Background(), "1234567890121"); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected breaker open, got %v", err)
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("expected the open breaker to skip the HIS, got %d calls", atomic.LoadInt32(calls))
+	}
+	s := reg.Status()[0]
+	if s.Breaker != BreakerOpen || s.OpenedAt == nil || s.Requests[ResultFailure] != 2 || s.Requests[ResultRejected] != 1 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("closed breaker rejected a call: %v", err)
+	}
+	b.record(outcomeFailure)
+	if err := b.allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected open breaker, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a trial call after the cooldown, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected a single trial call, got %v", err)
+	}
+	b.record(outcomeSuccess)
+	if s := b.status("x"); s.Breaker != BreakerClosed || s.ConsecutiveFailures != 0 {
+		t.Fatalf("expected the trial to close the breaker: %+v", s)
+	}
+}
+
+func TestCallerCancellationIsNotRetried(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, reg, calls := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}, Config{Retries: 2, BreakerFailures: 1})
+
+	if _, err := client.FetchByID(ctx, "1234567890121"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	if atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("expected a single attempt, got %d", atomic.LoadInt32(calls))
+	}
+	if s := reg.Status()[0]; s.Breaker != BreakerClosed || s.Requests[ResultFailure] != 0 {
+		t.Fatalf("a cancelled call must not count against the HIS: %+v", s)
+	}
+}
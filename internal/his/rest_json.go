@@ -0,0 +1,108 @@
+package his
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"agnos/internal/model"
+)
+
+const restJSONDefaultPath = "/patients/{id}"
+
+var patientFields = []string{
+	"first_name_th", "middle_name_th", "last_name_th",
+	"first_name_en", "middle_name_en", "last_name_en",
+	"date_of_birth", "patient_hn", "national_id", "passport_id",
+	"phone_number", "email", "gender",
+}
+
+type restJSONClient struct {
+	endpoint endpoint
+	fieldMap map[string]string
+}
+
+func newRESTJSONAdapter(cfg Config, httpClient *http.Client) (Client, error) {
+	fieldMap := make(map[string]string, len(patientFields))
+	for _, f := range patientFields {
+		fieldMap[f] = f
+	}
+	for field, source := range cfg.FieldMap {
+		if _, ok := fieldMap[field]; !ok {
+			return nil, fmt.Errorf("his: unknown patient field %q in field map for hospital %q", field, cfg.Hospital)
+		}
+		fieldMap[field] = strings.TrimSpace(source)
+	}
+	return &restJSONClient{endpoint: newEndpoint(cfg, restJSONDefaultPath, httpClient), fieldMap: fieldMap}, nil
+}
+
+func (c *restJSONClient) FetchByID(ctx context.Context, id string) (model.Patient, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return model.Patient{}, fmt.Errorf("id is required")
+	}
+
+	resp, err := c.endpoint.get(ctx, id)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	defer resp.Body.Close()
+
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return model.Patient{}, err
+	}
+
+	field := func(name string) *string {
+		return lookupString(payload, c.fieldMap[name])
+	}
+	return normalizeIdentifiers(model.Patient{
+		FirstNameTH:  field("first_name_th"),
+		MiddleNameTH: field("middle_name_th"),
+		LastNameTH:   field("last_name_th"),
+		FirstNameEN:  field("first_name_en"),
+		MiddleNameEN: field("middle_name_en"),
+		LastNameEN:   field("last_name_en"),
+		DateOfBirth:  parseDate(field("date_of_birth")),
+		PatientHN:    field("patient_hn"),
+		NationalID:   field("national_id"),
+		PassportID:   field("passport_id"),
+		PhoneNumber:  field("phone_number"),
+		Email:        field("email"),
+		Gender:       field("gender"),
+	})
+}
+
+// lookupString resolves a dotted path such as "name.first_th" in a decoded
+// JSON object. An empty path means the HIS does not provide the field.
+func lookupString(payload map[string]any, path string) *string {
+	if path == "" {
+		return nil
+	}
+	var cur any = payload
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur, ok = obj[part]
+		if !ok {
+			return nil
+		}
+	}
+	var s string
+	switch v := cur.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return nil
+	}
+	return &s
+}
@@ -1,12 +1,21 @@
 package http
 
 import (
-	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"agnos/internal/his"
+	"agnos/internal/jwtkeys"
 	"agnos/internal/middleware"
 	"agnos/internal/model"
+	"agnos/internal/passwd"
+	"agnos/internal/rbac"
 	"agnos/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -15,26 +24,158 @@ import (
 type handler struct {
 	staffService   service.StaffService
 	patientService service.PatientService
+	mpiService     service.MPIService
+	privacyService service.PrivacyService
+	auditService   service.AuditService
+	hisRegistry    his.Registry
 }
 
-func RegisterRoutes(r *gin.Engine, staffService service.StaffService, patientService service.PatientService, jwtSecret string) {
-	h := &handler{staffService: staffService, patientService: patientService}
+func RegisterRoutes(r *gin.Engine, staffService service.StaffService, patientService service.PatientService, mpiService service.MPIService, privacyService service.PrivacyService, auditService service.AuditService, hisRegistry his.Registry, keys *jwtkeys.KeySet) {
+	h := &handler{staffService: staffService, patientService: patientService, mpiService: mpiService, privacyService: privacyService, auditService: auditService, hisRegistry: hisRegistry}
 
-	r.GET("/healthz", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	r.GET("/healthz", h.health)
+	r.GET("/metrics", h.metrics)
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keys.JWKS())
 	})
 
-	r.POST("/staff/create", h.staffCreate)
 	r.POST("/staff/login", h.staffLogin)
+	r.POST("/staff/refresh", h.staffRefresh)
+	r.POST("/staff/mfa/verify", h.staffMFAVerify)
 
-	authed := r.Group("", middleware.JWTAuth(jwtSecret))
-	authed.POST("/patient/search", h.patientSearch)
+	authed := r.Group("", middleware.JWTAuth(keys, staffService))
+	authed.POST("/staff/logout", h.staffLogout)
+	authed.POST("/staff/password", h.staffChangePassword)
+	authed.POST("/staff/mfa/enroll", h.staffMFAEnroll)
+	authed.POST("/staff/mfa/activate", h.staffMFAActivate)
+
+	mfaChecked := authed.Group("", middleware.RequireMFA(staffService))
+
+	patients := mfaChecked.Group("/patient", middleware.RequirePermission(rbac.PermPatientRead))
+	patients.POST("/search", h.patientSearch)
+	patients.GET("/:id", h.patientGet)
+	patients.POST("/:id/reveal", h.patientReveal)
+	patients.GET("/:id/history", h.patientHistory)
+
+	patientWriters := mfaChecked.Group("/patient", middleware.RequirePermission(rbac.PermPatientWrite))
+	patientWriters.POST("", h.patientCreate)
+	patientWriters.PATCH("/:id", h.patientUpdate)
+	patientWriters.DELETE("/:id", h.patientDelete)
+	patientWriters.POST("/:id/restore", h.patientRestore)
+
+	patientMergers := mfaChecked.Group("/patient", middleware.RequirePermission(rbac.PermPatientMerge))
+	patientMergers.GET("/duplicates", h.patientDuplicates)
+	patientMergers.POST("/duplicates/:id/dismiss", h.patientDismissDuplicate)
+	patientMergers.POST("/merge", h.patientMerge)
+	patientMergers.POST("/merges/:id/revert", h.patientUnmerge)
+	patientMergers.GET("/:id/merges", h.patientMerges)
+
+	privacy := mfaChecked.Group("/privacy", middleware.RequirePermission(rbac.PermPrivacyManage))
+	privacy.POST("/requests", h.privacyCreateRequest)
+	privacy.GET("/requests", h.privacyRequests)
+	privacy.GET("/requests/:id", h.privacyRequest)
+	privacy.POST("/requests/:id/export", h.privacyExport)
+	privacy.POST("/requests/:id/erase", h.privacyErase)
+	privacy.POST("/requests/:id/reject", h.privacyReject)
+	privacy.POST("/holds", h.privacyPlaceHold)
+	privacy.GET("/holds", h.privacyHolds)
+	privacy.POST("/holds/:id/release", h.privacyReleaseHold)
+
+	audit := mfaChecked.Group("/audit", middleware.RequirePermission(rbac.PermAuditRead))
+	audit.GET("/patient-access", h.auditList(
+		model.AuditActionPatientSearch,
+		model.AuditActionPatientView,
+		model.AuditActionPatientCreate,
+		model.AuditActionPatientUpdate,
+		model.AuditActionPatientDelete,
+		model.AuditActionPatientReveal,
+		model.AuditActionPatientHistory,
+		model.AuditActionPatientRestore,
+		model.AuditActionPatientExport,
+		model.AuditActionPatientErase,
+		model.AuditActionHoldPlace,
+		model.AuditActionHoldRelease,
+		model.AuditActionPatientMerge,
+		model.AuditActionPatientUnmerge,
+		model.AuditActionMergeList,
+		model.AuditActionDuplicateList,
+		model.AuditActionDuplicateSkip,
+	))
+	audit.GET("/entries", h.auditList())
+	audit.GET("/verify", h.auditVerify)
+
+	staffAdmin := mfaChecked.Group("/staff", middleware.RequireRole(rbac.RoleAdmin))
+	staffAdmin.GET("", h.staffList)
+	staffAdmin.POST("/create", h.staffCreate)
+	staffAdmin.POST("/:id/deactivate", h.staffSetActive(false))
+	staffAdmin.POST("/:id/reactivate", h.staffSetActive(true))
+	staffAdmin.POST("/:id/password/reset", h.staffResetPassword)
+	staffAdmin.POST("/:id/sessions/revoke", h.staffRevokeSessions)
+	staffAdmin.POST("/:id/unlock", h.staffUnlock)
+	staffAdmin.GET("/security-events", h.staffSecurityEvents)
+	staffAdmin.POST("/:id/mfa/reset", h.staffMFAReset)
+}
+
+// health reports the service as degraded while the circuit breaker of any
+// HIS is not closed. Searches still work then, from local records only, so
+// the status code stays 200.
+// health is public, so it only says whether every HIS breaker is closed; the
+// per-hospital detail is in metrics, which Nginx does not expose.
+func (h *handler) health(c *gin.Context) {
+	status := "ok"
+	for _, s := range h.hisRegistry.Status() {
+		if s.Breaker != his.BreakerClosed {
+			status = "degraded"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// metrics serves the HIS client counters in the Prometheus text format.
+func (h *handler) metrics(c *gin.Context) {
+	var b strings.Builder
+	statuses := h.hisRegistry.Status()
+	b.WriteString("# HELP his_breaker_state Circuit breaker state of the HIS client: 0 closed, 1 half-open, 2 open.\n")
+	b.WriteString("# TYPE his_breaker_state gauge\n")
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "his_breaker_state{hospital=%q} %d\n", s.Hospital, breakerStateValue(s.Breaker))
+	}
+	b.WriteString("# HELP his_consecutive_failures HIS client calls failed in a row.\n")
+	b.WriteString("# TYPE his_consecutive_failures gauge\n")
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "his_consecutive_failures{hospital=%q} %d\n", s.Hospital, s.ConsecutiveFailures)
+	}
+	b.WriteString("# HELP his_requests_total HIS client calls by result.\n")
+	b.WriteString("# TYPE his_requests_total counter\n")
+	for _, s := range statuses {
+		for _, result := range []string{his.ResultSuccess, his.ResultFailure, his.ResultRejected} {
+			fmt.Fprintf(&b, "his_requests_total{hospital=%q,result=%q} %d\n", s.Hospital, result, s.Requests[result])
+		}
+	}
+	b.WriteString("# HELP his_retries_total HIS client attempts repeated after a transient failure.\n")
+	b.WriteString("# TYPE his_retries_total counter\n")
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "his_retries_total{hospital=%q} %d\n", s.Hospital, s.Retries)
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+func breakerStateValue(state string) int {
+	switch state {
+	case his.BreakerHalfOpen:
+		return 1
+	case his.BreakerOpen:
+		return 2
+	}
+	return 0
 }
 
 type staffCreateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Hospital string `json:"hospital"`
+	Role     string `json:"role"`
 }
 
 func (h *handler) staffCreate(c *gin.Context) {
@@ -44,16 +185,23 @@ func (h *handler) staffCreate(c *gin.Context) {
 		return
 	}
 
-	staff, err := h.staffService.Create(req.Username, req.Password, req.Hospital)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	hospital := middleware.HospitalFromContext(c)
+	if req.Hospital != "" && strings.TrimSpace(req.Hospital) != hospital {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot create staff for another hospital"})
 		return
 	}
-	c.JSON(http.StatusCreated, staff)
+
+	staff, err := h.staffService.Create(req.Username, req.Password, hospital, req.Role)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, staff)
+	case errors.Is(err, service.ErrInvalidStaff), errors.Is(err, service.ErrInvalidRole), errors.Is(err, passwd.ErrPolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrStaffConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "staff creation failed"})
+	}
 }
 
 type staffLoginRequest struct {
@@ -68,16 +216,291 @@ func (h *handler) staffLogin(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
-	jwt, err := h.staffService.Login(req.Username, req.Password, req.Hospital)
+	result, err := h.staffService.Login(req.Username, req.Password, req.Hospital, c.ClientIP())
 	if err != nil {
+		if writeLoginBlocked(c, err) {
+			return
+		}
 		if errors.Is(err, service.ErrInvalidCredentials) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
+		if errors.Is(err, service.ErrAccountDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": jwt})
+	c.JSON(http.StatusOK, result)
+}
+
+func writeLoginBlocked(c *gin.Context, err error) bool {
+	var blocked *service.LoginBlockedError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+	msg := "too many failed login attempts, try again later"
+	if blocked.Locked {
+		msg = "account temporarily locked after repeated failed logins"
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": msg})
+	return true
+}
+
+type staffMFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (h *handler) staffMFAVerify(c *gin.Context) {
+	var req staffMFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	tokens, err := h.staffService.VerifyMFA(req.MFAToken, req.Code, req.RecoveryCode, c.ClientIP())
+	if err != nil {
+		if writeLoginBlocked(c, err) {
+			return
+		}
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *handler) staffMFAEnroll(c *gin.Context) {
+	enrollment, err := h.staffService.EnrollMFA(middleware.StaffIDFromContext(c))
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+type staffMFAActivateRequest struct {
+	Code string `json:"code"`
+}
+
+func (h *handler) staffMFAActivate(c *gin.Context) {
+	var req staffMFAActivateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	codes, err := h.staffService.ActivateMFA(middleware.StaffIDFromContext(c), req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *handler) staffMFAReset(c *gin.Context) {
+	staffID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid staff id"})
+		return
+	}
+	if err := h.staffService.ResetMFA(middleware.HospitalFromContext(c), middleware.StaffIDFromContext(c), staffID); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func writeMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidMFAToken), errors.Is(err, service.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrStaffNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "staff not found"})
+	case errors.Is(err, service.ErrMFANotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "mfa operation failed"})
+	}
+}
+
+type staffRefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h *handler) staffRefresh(c *gin.Context) {
+	var req staffRefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	tokens, err := h.staffService.Refresh(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "refresh failed"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+type staffLogoutRequest struct {
+	All bool `json:"all"`
+}
+
+func (h *handler) staffLogout(c *gin.Context) {
+	var req staffLogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+	claims := middleware.ClaimsFromContext(c)
+
+	var err error
+	if req.All {
+		err = h.staffService.RevokeAllSessions(claims.Hospital, claims.StaffID)
+	} else {
+		err = h.staffService.Logout(claims.StaffID, claims.SessionID, claims.TokenID, claims.ExpiresAt)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "logout failed"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) staffRevokeSessions(c *gin.Context) {
+	staffID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid staff id"})
+		return
+	}
+	if err := h.staffService.RevokeAllSessions(middleware.HospitalFromContext(c), staffID); err != nil {
+		if errors.Is(err, service.ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "staff not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "revoke sessions failed"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) staffUnlock(c *gin.Context) {
+	staffID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid staff id"})
+		return
+	}
+	if err := h.staffService.Unlock(middleware.HospitalFromContext(c), middleware.StaffIDFromContext(c), staffID); err != nil {
+		if errors.Is(err, service.ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "staff not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unlock failed"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) staffList(c *gin.Context) {
+	staff, err := h.staffService.List(middleware.HospitalFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list staff failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"staff": staff})
+}
+
+func (h *handler) staffSetActive(active bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		staffID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid staff id"})
+			return
+		}
+		err = h.staffService.SetActive(middleware.HospitalFromContext(c), middleware.StaffIDFromContext(c), staffID, active)
+		if err != nil {
+			writeStaffError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type staffChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (h *handler) staffChangePassword(c *gin.Context) {
+	var req staffChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := h.staffService.ChangePassword(middleware.StaffIDFromContext(c), req.OldPassword, req.NewPassword, c.ClientIP()); err != nil {
+		if writeLoginBlocked(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "old password is incorrect"})
+			return
+		}
+		writeStaffError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type staffResetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+func (h *handler) staffResetPassword(c *gin.Context) {
+	staffID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid staff id"})
+		return
+	}
+	var req staffResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := h.staffService.ResetPassword(middleware.HospitalFromContext(c), middleware.StaffIDFromContext(c), staffID, req.Password); err != nil {
+		writeStaffError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func writeStaffError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrStaffNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "staff not found"})
+	case errors.Is(err, passwd.ErrPolicy), errors.Is(err, service.ErrCannotDeactivateSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "staff update failed"})
+	}
+}
+
+func (h *handler) staffSecurityEvents(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	events, err := h.staffService.ListSecurityEvents(middleware.HospitalFromContext(c), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list security events failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
 }
 
 func (h *handler) patientSearch(c *gin.Context) {
@@ -86,11 +509,479 @@ func (h *handler) patientSearch(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
-	hospital := middleware.HospitalFromContext(c)
-	result, err := h.patientService.Search(hospital, criteria)
-	if err != nil {
+	page, err := h.patientService.Search(c.Request.Context(), middleware.ActorFromContext(c), criteria)
+	switch {
+	case errors.Is(err, service.ErrInvalidSearch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrHISTimeout):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrHISUnavailable):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"patients": result})
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *handler) patientGet(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	patient, err := h.patientService.Get(middleware.ActorFromContext(c), id)
+	if errors.Is(err, service.ErrPatientMerged) {
+		writePatientMerged(c, http.StatusTemporaryRedirect, patient)
+		return
+	}
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, patient)
+}
+
+type patientRevealRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *handler) patientReveal(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	var req patientRevealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	patient, err := h.patientService.Reveal(middleware.ActorFromContext(c), id, req.Reason)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, patient)
+}
+
+func (h *handler) patientCreate(c *gin.Context) {
+	var req model.Patient
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	patient, err := h.patientService.Create(middleware.ActorFromContext(c), req)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, patient)
+}
+
+func (h *handler) patientUpdate(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil || !json.Valid(patch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	patient, err := h.patientService.Update(middleware.ActorFromContext(c), id, patch)
+	if errors.Is(err, service.ErrPatientMerged) {
+		writePatientMerged(c, http.StatusConflict, patient)
+		return
+	}
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, patient)
+}
+
+func (h *handler) patientDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	if err := h.patientService.Delete(middleware.ActorFromContext(c), id); err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func writePatientError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPatient), errors.Is(err, service.ErrReasonRequired), errors.Is(err, service.ErrInvalidPrivacyRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+	case errors.Is(err, service.ErrPatientConflict), errors.Is(err, service.ErrPatientMerged), errors.Is(err, service.ErrMergeConflict), errors.Is(err, service.ErrHasMergedPatients),
+		errors.Is(err, service.ErrPrivacyRequestClosed), errors.Is(err, service.ErrLegalHold):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMergeNotFound), errors.Is(err, service.ErrDuplicateNotFound), errors.Is(err, service.ErrVersionNotFound),
+		errors.Is(err, service.ErrPrivacyRequestNotFound), errors.Is(err, service.ErrLegalHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "patient operation failed"})
+	}
+}
+
+// writePatientMerged answers a request for a merged patient with the ID of
+// the patient it was merged into.
+func writePatientMerged(c *gin.Context, status int, p model.Patient) {
+	if p.MergedInto != nil {
+		c.Header("Location", fmt.Sprintf("/patient/%d", *p.MergedInto))
+	}
+	c.JSON(status, gin.H{"error": service.ErrPatientMerged.Error(), "merged_into": p.MergedInto})
+}
+
+func (h *handler) patientHistory(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	versions, err := h.patientService.History(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+type patientRestoreRequest struct {
+	Version int `json:"version"`
+}
+
+func (h *handler) patientRestore(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	var req patientRestoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version is required"})
+		return
+	}
+	patient, err := h.patientService.Restore(middleware.ActorFromContext(c), id, req.Version)
+	if errors.Is(err, service.ErrPatientMerged) {
+		writePatientMerged(c, http.StatusConflict, patient)
+		return
+	}
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, patient)
+}
+
+func (h *handler) patientDuplicates(c *gin.Context) {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+	duplicates, err := h.mpiService.Duplicates(middleware.ActorFromContext(c), limit)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"duplicates": duplicates})
+}
+
+func (h *handler) patientDismissDuplicate(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duplicate id"})
+		return
+	}
+	if err := h.mpiService.DismissDuplicate(middleware.ActorFromContext(c), id); err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type patientMergeRequest struct {
+	SurvivorID int64  `json:"survivor_id"`
+	MergedID   int64  `json:"merged_id"`
+	Reason     string `json:"reason"`
+}
+
+func (h *handler) patientMerge(c *gin.Context) {
+	var req patientMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.SurvivorID <= 0 || req.MergedID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "survivor_id and merged_id are required"})
+		return
+	}
+	merge, err := h.mpiService.Merge(middleware.ActorFromContext(c), req.SurvivorID, req.MergedID, req.Reason)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, merge)
+}
+
+func (h *handler) patientUnmerge(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merge id"})
+		return
+	}
+	merge, err := h.mpiService.Unmerge(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, merge)
+}
+
+func (h *handler) patientMerges(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient id"})
+		return
+	}
+	merges, err := h.mpiService.Merges(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"merges": merges})
+}
+
+type privacyRequestBody struct {
+	PatientID int64  `json:"patient_id"`
+	Kind      string `json:"kind"`
+	Note      string `json:"note"`
+}
+
+func (h *handler) privacyCreateRequest(c *gin.Context) {
+	var req privacyRequestBody
+	if err := c.ShouldBindJSON(&req); err != nil || req.PatientID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "patient_id and kind are required"})
+		return
+	}
+	created, err := h.privacyService.CreateRequest(middleware.ActorFromContext(c), req.PatientID, req.Kind, req.Note)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *handler) privacyRequests(c *gin.Context) {
+	requests, err := h.privacyService.Requests(middleware.ActorFromContext(c), c.Query("status"))
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+func (h *handler) privacyRequest(c *gin.Context) {
+	id, ok := privacyRequestID(c)
+	if !ok {
+		return
+	}
+	req, err := h.privacyService.Request(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+func (h *handler) privacyExport(c *gin.Context) {
+	id, ok := privacyRequestID(c)
+	if !ok {
+		return
+	}
+	req, export, err := h.privacyService.Export(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="patient-%d-export.json"`, req.PatientID))
+	c.JSON(http.StatusOK, gin.H{"request": req, "export": export})
+}
+
+func (h *handler) privacyErase(c *gin.Context) {
+	id, ok := privacyRequestID(c)
+	if !ok {
+		return
+	}
+	req, err := h.privacyService.Erase(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+type privacyRejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *handler) privacyReject(c *gin.Context) {
+	id, ok := privacyRequestID(c)
+	if !ok {
+		return
+	}
+	var body privacyRejectRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req, err := h.privacyService.Reject(middleware.ActorFromContext(c), id, body.Reason)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+func privacyRequestID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request id"})
+		return 0, false
+	}
+	return id, true
+}
+
+type legalHoldRequest struct {
+	PatientID int64  `json:"patient_id"`
+	Reason    string `json:"reason"`
+}
+
+func (h *handler) privacyPlaceHold(c *gin.Context) {
+	var req legalHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.PatientID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "patient_id and reason are required"})
+		return
+	}
+	hold, err := h.privacyService.PlaceHold(middleware.ActorFromContext(c), req.PatientID, req.Reason)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, hold)
+}
+
+func (h *handler) privacyHolds(c *gin.Context) {
+	patientID, err := strconv.ParseInt(c.Query("patient_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient_id"})
+		return
+	}
+	holds, err := h.privacyService.Holds(middleware.ActorFromContext(c), patientID)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"holds": holds})
+}
+
+func (h *handler) privacyReleaseHold(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hold id"})
+		return
+	}
+	hold, err := h.privacyService.ReleaseHold(middleware.ActorFromContext(c), id)
+	if err != nil {
+		writePatientError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, hold)
+}
+
+// auditList lists audit entries limited to actions, or to the "action" query
+// parameters when no actions are given.
+func (h *handler) auditList(actions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := model.AuditFilter{Actions: actions}
+		if len(actions) == 0 {
+			f.Actions = c.QueryArray("action")
+		}
+		if !bindAuditFilter(c, &f) {
+			return
+		}
+		entries, err := h.auditService.List(middleware.HospitalFromContext(c), f)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "list audit log failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries})
+	}
+}
+
+func bindAuditFilter(c *gin.Context, f *model.AuditFilter) bool {
+	var err error
+	if f.StaffID, err = optionalInt64(c.Query("staff_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid staff_id"})
+		return false
+	}
+	if f.PatientID, err = optionalInt64(c.Query("patient_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid patient_id"})
+		return false
+	}
+	if f.From, err = optionalTime(c.Query("from")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, expected RFC 3339"})
+		return false
+	}
+	if f.To, err = optionalTime(c.Query("to")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, expected RFC 3339"})
+		return false
+	}
+	f.Limit, _ = strconv.Atoi(c.Query("limit"))
+	return true
+}
+
+func (h *handler) auditVerify(c *gin.Context) {
+	result, err := h.auditService.Verify(middleware.HospitalFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "verify audit log failed"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func optionalInt64(v string) (*int64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func optionalTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
@@ -2,14 +2,22 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"agnos/internal/his"
+	"agnos/internal/jwtkeys"
+	"agnos/internal/middleware"
 	"agnos/internal/model"
+	"agnos/internal/passwd"
+	"agnos/internal/rbac"
 	"agnos/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -17,47 +25,339 @@ import (
 )
 
 type fakeStaffService struct {
-	createFn func(username, password, hospital string) (model.Staff, error)
-	loginFn  func(username, password, hospital string) (string, error)
+	createFn    func(username, password, hospital, role string) (model.Staff, error)
+	bootstrapFn func(username, password, hospital string) (model.Staff, bool, error)
+	loginFn     func(username, password, hospital, clientIP string) (model.LoginResult, error)
+	refreshFn   func(refreshToken string) (model.TokenPair, error)
+	logoutFn    func(staffID int64, sessionID, tokenID string, expiresAt time.Time) error
+	revokeAllFn func(hospital string, staffID int64) error
+	revokedFn   func(tokenID, sessionID string, staffID int64, issuedAt time.Time) (bool, error)
+	unlockFn    func(hospital string, actorID, staffID int64) error
+	eventsFn    func(hospital string, limit int) ([]model.SecurityEvent, error)
+	verifyFn    func(mfaToken, code, recoveryCode, clientIP string) (model.TokenPair, error)
+	enrollFn    func(staffID int64) (model.MFAEnrollment, error)
+	activateFn  func(staffID int64, code string) ([]string, error)
+	resetMFAFn  func(hospital string, actorID, staffID int64) error
+	mfaRequired map[string]bool
+	listFn      func(hospital string) ([]model.Staff, error)
+	setActiveFn func(hospital string, actorID, staffID int64, active bool) error
+	changePwFn  func(staffID int64, oldPassword, newPassword, clientIP string) error
+	resetPwFn   func(hospital string, actorID, staffID int64, newPassword string) error
 }
 
-func (f *fakeStaffService) Create(username, password, hospital string) (model.Staff, error) {
-	return f.createFn(username, password, hospital)
+func (f *fakeStaffService) Create(username, password, hospital, role string) (model.Staff, error) {
+	return f.createFn(username, password, hospital, role)
 }
 
-func (f *fakeStaffService) Login(username, password, hospital string) (string, error) {
-	return f.loginFn(username, password, hospital)
+func (f *fakeStaffService) BootstrapAdmin(username, password, hospital string) (model.Staff, bool, error) {
+	return f.bootstrapFn(username, password, hospital)
+}
+
+func (f *fakeStaffService) Login(username, password, hospital, clientIP string) (model.LoginResult, error) {
+	return f.loginFn(username, password, hospital, clientIP)
+}
+
+func (f *fakeStaffService) Refresh(refreshToken string) (model.TokenPair, error) {
+	return f.refreshFn(refreshToken)
+}
+
+func (f *fakeStaffService) Logout(staffID int64, sessionID, tokenID string, expiresAt time.Time) error {
+	return f.logoutFn(staffID, sessionID, tokenID, expiresAt)
+}
+
+func (f *fakeStaffService) RevokeAllSessions(hospital string, staffID int64) error {
+	return f.revokeAllFn(hospital, staffID)
+}
+
+func (f *fakeStaffService) Unlock(hospital string, actorID, staffID int64) error {
+	return f.unlockFn(hospital, actorID, staffID)
+}
+
+func (f *fakeStaffService) ListSecurityEvents(hospital string, limit int) ([]model.SecurityEvent, error) {
+	return f.eventsFn(hospital, limit)
+}
+
+func (f *fakeStaffService) VerifyMFA(mfaToken, code, recoveryCode, clientIP string) (model.TokenPair, error) {
+	return f.verifyFn(mfaToken, code, recoveryCode, clientIP)
+}
+
+func (f *fakeStaffService) EnrollMFA(staffID int64) (model.MFAEnrollment, error) {
+	return f.enrollFn(staffID)
+}
+
+func (f *fakeStaffService) ActivateMFA(staffID int64, code string) ([]string, error) {
+	return f.activateFn(staffID, code)
+}
+
+func (f *fakeStaffService) ResetMFA(hospital string, actorID, staffID int64) error {
+	return f.resetMFAFn(hospital, actorID, staffID)
+}
+
+func (f *fakeStaffService) MFARequired(hospital string) bool {
+	return f.mfaRequired[hospital]
+}
+
+func (f *fakeStaffService) List(hospital string) ([]model.Staff, error) {
+	return f.listFn(hospital)
+}
+
+func (f *fakeStaffService) SetActive(hospital string, actorID, staffID int64, active bool) error {
+	return f.setActiveFn(hospital, actorID, staffID, active)
+}
+
+func (f *fakeStaffService) ChangePassword(staffID int64, oldPassword, newPassword, clientIP string) error {
+	return f.changePwFn(staffID, oldPassword, newPassword, clientIP)
+}
+
+func (f *fakeStaffService) ResetPassword(hospital string, actorID, staffID int64, newPassword string) error {
+	return f.resetPwFn(hospital, actorID, staffID, newPassword)
+}
+
+func (f *fakeStaffService) IsRevoked(tokenID, sessionID string, staffID int64, issuedAt time.Time) (bool, error) {
+	if f.revokedFn == nil {
+		return false, nil
+	}
+	return f.revokedFn(tokenID, sessionID, staffID, issuedAt)
 }
 
 type fakePatientService struct {
-	searchFn func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error)
+	searchFn  func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error)
+	pageFn    func(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error)
+	getFn     func(hospital string, id int64) (model.Patient, error)
+	createFn  func(hospital string, p model.Patient) (model.Patient, error)
+	updateFn  func(hospital string, id int64, patch json.RawMessage) (model.Patient, error)
+	deleteFn  func(hospital string, id int64) error
+	revealFn  func(hospital string, id int64, reason string) (model.Patient, error)
+	historyFn func(hospital string, id int64) ([]model.PatientVersion, error)
+	restoreFn func(hospital string, id int64, version int) (model.Patient, error)
+	lastActor model.Actor
+}
+
+func (f *fakePatientService) Search(ctx context.Context, actor model.Actor, c model.PatientSearchCriteria) (model.PatientPage, error) {
+	f.lastActor = actor
+	if f.pageFn != nil {
+		return f.pageFn(actor.Hospital, c)
+	}
+	patients, err := f.searchFn(actor.Hospital, c)
+	return model.PatientPage{Patients: patients}, err
+}
+
+var testKeys = func() *jwtkeys.KeySet {
+	ks, err := jwtkeys.Generate()
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}()
+
+func (f *fakePatientService) Get(actor model.Actor, id int64) (model.Patient, error) {
+	return f.getFn(actor.Hospital, id)
+}
+
+func (f *fakePatientService) Create(actor model.Actor, p model.Patient) (model.Patient, error) {
+	return f.createFn(actor.Hospital, p)
+}
+
+func (f *fakePatientService) Update(actor model.Actor, id int64, patch json.RawMessage) (model.Patient, error) {
+	return f.updateFn(actor.Hospital, id, patch)
+}
+
+func (f *fakePatientService) Delete(actor model.Actor, id int64) error {
+	return f.deleteFn(actor.Hospital, id)
+}
+
+func (f *fakePatientService) Reveal(actor model.Actor, id int64, reason string) (model.Patient, error) {
+	f.lastActor = actor
+	return f.revealFn(actor.Hospital, id, reason)
+}
+
+func (f *fakePatientService) History(actor model.Actor, id int64) ([]model.PatientVersion, error) {
+	return f.historyFn(actor.Hospital, id)
+}
+
+func (f *fakePatientService) Restore(actor model.Actor, id int64, version int) (model.Patient, error) {
+	return f.restoreFn(actor.Hospital, id, version)
+}
+
+func (f *fakePatientService) RefreshStale(ctx context.Context, batch int, pause time.Duration) ([]model.HISSyncRun, error) {
+	return nil, nil
+}
+
+type fakeAuditService struct {
+	listFn   func(hospital string, f model.AuditFilter) ([]model.AuditEntry, error)
+	verifyFn func(hospital string) (model.AuditVerification, error)
+}
+
+func (f *fakeAuditService) List(hospital string, filter model.AuditFilter) ([]model.AuditEntry, error) {
+	return f.listFn(hospital, filter)
+}
+
+func (f *fakeAuditService) Verify(hospital string) (model.AuditVerification, error) {
+	return f.verifyFn(hospital)
+}
+
+func (f *fakeAuditService) Checkpoint() ([]model.AuditCheckpoint, error) {
+	return nil, nil
+}
+
+type fakeMPIService struct {
+	duplicatesFn func(hospital string, limit int) ([]model.DuplicateCandidate, error)
+	mergeFn      func(hospital string, survivorID, mergedID int64, reason string) (model.PatientMerge, error)
+	unmergeFn    func(hospital string, mergeID int64) (model.PatientMerge, error)
+}
+
+func (f *fakeMPIService) Duplicates(actor model.Actor, limit int) ([]model.DuplicateCandidate, error) {
+	return f.duplicatesFn(actor.Hospital, limit)
+}
+
+func (f *fakeMPIService) DismissDuplicate(actor model.Actor, id int64) error {
+	return nil
+}
+
+func (f *fakeMPIService) Merge(actor model.Actor, survivorID, mergedID int64, reason string) (model.PatientMerge, error) {
+	return f.mergeFn(actor.Hospital, survivorID, mergedID, reason)
+}
+
+func (f *fakeMPIService) Unmerge(actor model.Actor, mergeID int64) (model.PatientMerge, error) {
+	return f.unmergeFn(actor.Hospital, mergeID)
+}
+
+func (f *fakeMPIService) Merges(actor model.Actor, patientID int64) ([]model.PatientMerge, error) {
+	return nil, nil
+}
+
+type fakePrivacyService struct {
+	createFn func(hospital string, patientID int64, kind, note string) (model.PrivacyRequest, error)
+	exportFn func(hospital string, id int64) (model.PrivacyRequest, model.PatientExport, error)
+	eraseFn  func(hospital string, id int64) (model.PrivacyRequest, error)
+}
+
+func (f *fakePrivacyService) CreateRequest(actor model.Actor, patientID int64, kind, note string) (model.PrivacyRequest, error) {
+	return f.createFn(actor.Hospital, patientID, kind, note)
+}
+
+func (f *fakePrivacyService) Requests(actor model.Actor, status string) ([]model.PrivacyRequest, error) {
+	return nil, nil
+}
+
+func (f *fakePrivacyService) Request(actor model.Actor, id int64) (model.PrivacyRequest, error) {
+	return model.PrivacyRequest{}, service.ErrPrivacyRequestNotFound
+}
+
+func (f *fakePrivacyService) Export(actor model.Actor, id int64) (model.PrivacyRequest, model.PatientExport, error) {
+	return f.exportFn(actor.Hospital, id)
+}
+
+func (f *fakePrivacyService) Erase(actor model.Actor, id int64) (model.PrivacyRequest, error) {
+	return f.eraseFn(actor.Hospital, id)
+}
+
+func (f *fakePrivacyService) Reject(actor model.Actor, id int64, reason string) (model.PrivacyRequest, error) {
+	return model.PrivacyRequest{}, nil
+}
+
+func (f *fakePrivacyService) PlaceHold(actor model.Actor, patientID int64, reason string) (model.LegalHold, error) {
+	return model.LegalHold{}, nil
+}
+
+func (f *fakePrivacyService) ReleaseHold(actor model.Actor, id int64) (model.LegalHold, error) {
+	return model.LegalHold{}, nil
+}
+
+func (f *fakePrivacyService) Holds(actor model.Actor, patientID int64) ([]model.LegalHold, error) {
+	return nil, nil
 }
 
-func (f *fakePatientService) Search(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-	return f.searchFn(hospital, c)
+// testServices holds the fakes a test router is built from; nil fields get
+// an empty fake.
+type testServices struct {
+	staff    service.StaffService
+	patient  service.PatientService
+	mpi      service.MPIService
+	privacy  service.PrivacyService
+	audit    service.AuditService
+	registry his.Registry
 }
 
-func setupRouter(staff service.StaffService, patient service.PatientService) *gin.Engine {
+func setupRouter(s testServices) *gin.Engine {
+	if s.staff == nil {
+		s.staff = &fakeStaffService{}
+	}
+	if s.patient == nil {
+		s.patient = &fakePatientService{}
+	}
+	if s.mpi == nil {
+		s.mpi = &fakeMPIService{}
+	}
+	if s.privacy == nil {
+		s.privacy = &fakePrivacyService{}
+	}
+	if s.audit == nil {
+		s.audit = &fakeAuditService{}
+	}
+	if s.registry == nil {
+		s.registry = his.NewStaticRegistry(nil)
+	}
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	RegisterRoutes(r, staff, patient, "secret")
+	r.Use(middleware.RequestID())
+	RegisterRoutes(r, s.staff, s.patient, s.mpi, s.privacy, s.audit, s.registry, testKeys)
 	return r
 }
 
+type fakeHISRegistry struct {
+	statuses []his.Status
+}
+
+func (f *fakeHISRegistry) ClientFor(hospital string) (his.Client, bool) { return nil, false }
+
+func (f *fakeHISRegistry) Hospitals() []string { return nil }
+
+func (f *fakeHISRegistry) SyncTTL(hospital string) time.Duration { return 0 }
+
+func (f *fakeHISRegistry) Status() []his.Status { return f.statuses }
+
+func testClaims(hospital, role string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"typ":      "access",
+		"amr":      []string{"pwd"},
+		"jti":      "test-token",
+		"sid":      "test-session",
+		"staff_id": 1,
+		"hospital": hospital,
+		"role":     role,
+		"iat":      time.Now().Unix(),
+		"exp":      time.Now().Add(1 * time.Hour).Unix(),
+	}
+}
+
+func testToken(t *testing.T, hospital, role string) string {
+	t.Helper()
+	token, err := testKeys.Sign(testClaims(hospital, role))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
 func TestStaffCreateSuccess(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) {
-			return model.Staff{ID: 1, Username: username, Hospital: hospital}, nil
-		},
-		loginFn: func(username, password, hospital string) (string, error) {
-			return "", nil
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				return model.Staff{ID: 1, Username: username, Hospital: hospital}, nil
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
 		},
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }})
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
 
 	body := map[string]string{"username": "john", "password": "secret", "hospital": "A"}
 	b, _ := json.Marshal(body)
 	req := httptest.NewRequest(http.MethodPost, "/staff/create", bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
@@ -67,17 +367,21 @@ func TestStaffCreateSuccess(t *testing.T) {
 }
 
 func TestStaffCreateBadRequest(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) {
-			return model.Staff{}, errors.New("invalid")
-		},
-		loginFn: func(username, password, hospital string) (string, error) {
-			return "", nil
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				return model.Staff{}, service.ErrInvalidStaff
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
 		},
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }})
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
 
 	req := httptest.NewRequest(http.MethodPost, "/staff/create", bytes.NewReader([]byte(`{}`)))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
@@ -86,15 +390,49 @@ func TestStaffCreateBadRequest(t *testing.T) {
 	}
 }
 
+func TestStaffCreateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{service.ErrStaffConflict, http.StatusConflict},
+		{errors.New("pq: connection refused"), http.StatusInternalServerError},
+	} {
+		r := setupRouter(testServices{
+			staff: &fakeStaffService{
+				createFn: func(username, password, hospital, role string) (model.Staff, error) {
+					return model.Staff{}, tc.err
+				},
+			},
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/staff/create", bytes.NewReader([]byte(`{"username":"john","password":"secret"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.code {
+			t.Fatalf("%v: expected %d got %d", tc.err, tc.code, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "connection refused") {
+			t.Fatalf("database error leaked: %s", w.Body.String())
+		}
+	}
+}
+
 func TestStaffLoginSuccess(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) {
-			return model.Staff{}, nil
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				return model.Staff{}, nil
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{TokenPair: &model.TokenPair{AccessToken: "token", RefreshToken: "refresh"}}, nil
+			},
 		},
-		loginFn: func(username, password, hospital string) (string, error) {
-			return "token", nil
-		},
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }})
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
 
 	req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewReader([]byte(`{"username":"john","password":"secret","hospital":"A"}`)))
 	req.Header.Set("Content-Type", "application/json")
@@ -107,14 +445,17 @@ func TestStaffLoginSuccess(t *testing.T) {
 }
 
 func TestStaffLoginUnauthorized(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) {
-			return model.Staff{}, nil
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				return model.Staff{}, nil
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, service.ErrInvalidCredentials
+			},
 		},
-		loginFn: func(username, password, hospital string) (string, error) {
-			return "", service.ErrInvalidCredentials
-		},
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }})
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
 
 	req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewReader([]byte(`{"username":"john","password":"wrong","hospital":"A"}`)))
 	req.Header.Set("Content-Type", "application/json")
@@ -131,25 +472,22 @@ func TestPatientSearchSuccess(t *testing.T) {
 	dob, _ := time.Parse("2006-01-02", "1990-01-01")
 	patient.DateOfBirth = &dob
 
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) { return model.Staff{}, nil },
-		loginFn:  func(username, password, hospital string) (string, error) { return "", nil },
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-		if hospital != "A" {
-			t.Fatalf("expected hospital A, got %s", hospital)
-		}
-		return []model.Patient{patient}, nil
-	}})
-
-	tokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"hospital": "A",
-		"iat":      time.Now().Unix(),
-		"exp":      time.Now().Add(1 * time.Hour).Unix(),
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) { return model.Staff{}, nil },
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			if hospital != "A" {
+				t.Fatalf("expected hospital A, got %s", hospital)
+			}
+			return []model.Patient{patient}, nil
+		}},
 	})
-	token, err := tokenObj.SignedString([]byte("secret"))
-	if err != nil {
-		t.Fatalf("sign token: %v", err)
-	}
+
+	token := testToken(t, "A", rbac.RoleNurse)
 	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"first_name":"Jo"}`)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -161,13 +499,126 @@ func TestPatientSearchSuccess(t *testing.T) {
 	}
 }
 
+func TestPatientSearchPagination(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{pageFn: func(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+			if c.Sort != model.PatientSortName || c.Limit != 1 || c.Cursor != "abc" || c.Total != model.TotalExact {
+				t.Fatalf("unexpected paging criteria: %+v", c)
+			}
+			total := int64(2)
+			return model.PatientPage{Patients: []model.Patient{{ID: 1, Hospital: "A"}}, NextCursor: "def", Total: &total}, nil
+		}},
+	})
+
+	token := testToken(t, "A", rbac.RoleNurse)
+	body := `{"sort":"name","limit":1,"cursor":"abc","total":"exact"}`
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d, body=%s", w.Code, w.Body.String())
+	}
+	var resp model.PatientPage
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Patients) != 1 || resp.NextCursor != "def" || resp.Total == nil || *resp.Total != 2 {
+		t.Fatalf("unexpected page: %s", w.Body.String())
+	}
+}
+
+func TestPatientSearchInvalidCursor(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{pageFn: func(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+			return model.PatientPage{}, service.ErrInvalidSearch
+		}},
+	})
+
+	token := testToken(t, "A", rbac.RoleNurse)
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"cursor":"not-a-cursor"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 got %d, body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatientSearchStrictHISFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: HIS did not respond in time", service.ErrHISTimeout), http.StatusGatewayTimeout},
+		{fmt.Errorf("%w: HIS responded with 503", service.ErrHISUnavailable), http.StatusBadGateway},
+	} {
+		r := setupRouter(testServices{
+			patient: &fakePatientService{pageFn: func(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+				if !c.HISStrict {
+					t.Fatalf("expected a strict search")
+				}
+				return model.PatientPage{}, tc.err
+			}},
+		})
+
+		token := testToken(t, "A", rbac.RoleNurse)
+		req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"national_id":"1234567890121","his_strict":true}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.err.Error()) {
+			t.Fatalf("expected %d got %d, body=%s", tc.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPatientSearchReportsHISStatus(t *testing.T) {
+	synced := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	r := setupRouter(testServices{
+		patient: &fakePatientService{pageFn: func(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+			return model.PatientPage{
+				Patients:  []model.Patient{{ID: 1, Hospital: "A", Source: model.PatientSourceHIS, LastSyncedAt: &synced}},
+				HISStatus: model.HISStatusHit,
+			}, nil
+		}},
+	})
+
+	token := testToken(t, "A", rbac.RoleNurse)
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"national_id":"1234567890121"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	patient := body["patients"].([]any)[0].(map[string]any)
+	if body["his_status"] != "hit" || patient["source"] != "his" || patient["last_synced_at"] != "2026-10-01T08:00:00Z" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+}
+
 func TestPatientSearchUnauthorized(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) { return model.Staff{}, nil },
-		loginFn:  func(username, password, hospital string) (string, error) { return "", nil },
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-		return nil, nil
-	}})
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) { return model.Staff{}, nil },
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			return nil, nil
+		}},
+	})
 
 	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
 	req.Header.Set("Content-Type", "application/json")
@@ -180,23 +631,20 @@ func TestPatientSearchUnauthorized(t *testing.T) {
 }
 
 func TestPatientSearchBadRequestInvalidBody(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) { return model.Staff{}, nil },
-		loginFn:  func(username, password, hospital string) (string, error) { return "", nil },
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-		t.Fatalf("search service should not be called on invalid request body")
-		return nil, nil
-	}})
-
-	tokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"hospital": "A",
-		"iat":      time.Now().Unix(),
-		"exp":      time.Now().Add(1 * time.Hour).Unix(),
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) { return model.Staff{}, nil },
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			t.Fatalf("search service should not be called on invalid request body")
+			return nil, nil
+		}},
 	})
-	token, err := tokenObj.SignedString([]byte("secret"))
-	if err != nil {
-		t.Fatalf("sign token: %v", err)
-	}
+
+	token := testToken(t, "A", rbac.RoleNurse)
 
 	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"date_of_birth":123}`)))
 	req.Header.Set("Content-Type", "application/json")
@@ -210,22 +658,19 @@ func TestPatientSearchBadRequestInvalidBody(t *testing.T) {
 }
 
 func TestPatientSearchInternalServerError(t *testing.T) {
-	r := setupRouter(&fakeStaffService{
-		createFn: func(username, password, hospital string) (model.Staff, error) { return model.Staff{}, nil },
-		loginFn:  func(username, password, hospital string) (string, error) { return "", nil },
-	}, &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-		return nil, errors.New("db error")
-	}})
-
-	tokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"hospital": "A",
-		"iat":      time.Now().Unix(),
-		"exp":      time.Now().Add(1 * time.Hour).Unix(),
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) { return model.Staff{}, nil },
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			return nil, errors.New("db error")
+		}},
 	})
-	token, err := tokenObj.SignedString([]byte("secret"))
-	if err != nil {
-		t.Fatalf("sign token: %v", err)
-	}
+
+	token := testToken(t, "A", rbac.RoleNurse)
 
 	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"first_name":"Jo"}`)))
 	req.Header.Set("Content-Type", "application/json")
@@ -237,3 +682,1140 @@ func TestPatientSearchInternalServerError(t *testing.T) {
 		t.Fatalf("expected 500 got %d, body=%s", w.Code, w.Body.String())
 	}
 }
+
+func TestStaffCreateForbiddenForNonAdmin(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				t.Fatalf("create should not be called for non-admin")
+				return model.Staff{}, nil
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/create", bytes.NewReader([]byte(`{"username":"bob","password":"secret","hospital":"A"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleDoctor))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestPatientSearchForbiddenWithoutReadPermission(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) { return model.Staff{}, nil },
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			t.Fatalf("search should not be called for auditor")
+			return nil, nil
+		}},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{"first_name":"Jo"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAuditor))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestStaffCreateForbiddenForOtherHospital(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				t.Fatalf("create should not be called for another hospital")
+				return model.Staff{}, nil
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/create", bytes.NewReader([]byte(`{"username":"bob","password":"secret","hospital":"B"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestStaffCreateUsesTokenHospital(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			createFn: func(username, password, hospital, role string) (model.Staff, error) {
+				if hospital != "A" {
+					t.Fatalf("expected hospital A from token, got %q", hospital)
+				}
+				return model.Staff{ID: 2, Username: username, Hospital: hospital, Role: role}, nil
+			},
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) { return nil, nil }},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/create", bytes.NewReader([]byte(`{"username":"bob","password":"secret","role":"doctor"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 got %d, body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestStaffRefreshSuccess(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			refreshFn: func(refreshToken string) (model.TokenPair, error) {
+				if refreshToken != "old" {
+					t.Fatalf("unexpected refresh token %q", refreshToken)
+				}
+				return model.TokenPair{AccessToken: "access", RefreshToken: "new"}, nil
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/refresh", bytes.NewReader([]byte(`{"refresh_token":"old"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+	var resp model.TokenPair
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.RefreshToken != "new" {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+}
+
+func TestStaffRefreshInvalid(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			refreshFn: func(refreshToken string) (model.TokenPair, error) {
+				return model.TokenPair{}, service.ErrInvalidRefreshToken
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/refresh", bytes.NewReader([]byte(`{"refresh_token":"reused"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got %d", w.Code)
+	}
+}
+
+func TestStaffLogoutRevokesCurrentSession(t *testing.T) {
+	called := false
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			logoutFn: func(staffID int64, sessionID, tokenID string, expiresAt time.Time) error {
+				called = true
+				if sessionID != "test-session" || tokenID != "test-token" {
+					t.Fatalf("unexpected session %q token %q", sessionID, tokenID)
+				}
+				return nil
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleNurse))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent || !called {
+		t.Fatalf("expected 204 with logout called, got %d", w.Code)
+	}
+}
+
+func TestRevokedTokenRejected(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			revokedFn: func(tokenID, sessionID string, staffID int64, issuedAt time.Time) (bool, error) {
+				return true, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			t.Fatalf("search should not be called with a revoked token")
+			return nil, nil
+		}},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleNurse))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got %d", w.Code)
+	}
+}
+
+func TestJWKSPublishesSigningKey(t *testing.T) {
+	r := setupRouter(testServices{})
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+	var jwks jwtkeys.JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("decode jwks: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[0].X == "" {
+		t.Fatalf("unexpected jwks %s", w.Body.String())
+	}
+}
+
+func TestTokenFromUntrustedKeyRejected(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			t.Fatalf("search should not be called with an untrusted token")
+			return nil, nil
+		}},
+	})
+
+	other, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("generate keys: %v", err)
+	}
+	untrusted, err := other.Sign(testClaims("A", rbac.RoleNurse))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("A", rbac.RoleNurse)).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	for _, token := range []string{untrusted, hmac} {
+		req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 got %d", w.Code)
+		}
+	}
+}
+
+func TestTokenWithoutStaffOrSessionRejected(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			t.Fatalf("search should not be called without staff_id or sid")
+			return nil, nil
+		}},
+	})
+
+	for _, claim := range []string{"staff_id", "sid"} {
+		claims := testClaims("A", rbac.RoleNurse)
+		delete(claims, claim)
+		token, err := testKeys.Sign(claims)
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("without %s: expected 401 got %d", claim, w.Code)
+		}
+	}
+}
+
+func TestStaffLoginLocked(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, &service.LoginBlockedError{RetryAfter: 90 * time.Second, Locked: true}
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewReader([]byte(`{"username":"john","password":"guess","hospital":"A"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "90" {
+		t.Fatalf("expected Retry-After 90, got %q", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestStaffUnlockByAdmin(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			unlockFn: func(hospital string, actorID, staffID int64) error {
+				if hospital != "A" || staffID != 7 {
+					t.Fatalf("unexpected unlock hospital=%s staff=%d", hospital, staffID)
+				}
+				return nil
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/7/unlock", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 got %d", w.Code)
+	}
+}
+
+func TestStaffLoginReturnsMFAChallenge(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{MFARequired: true, MFAToken: "challenge"}, nil
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewReader([]byte(`{"username":"john","password":"secret","hospital":"A"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusOK || body["mfa_required"] != true || body["mfa_token"] != "challenge" || body["token"] != nil {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStaffMFAVerifyInvalidCode(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			verifyFn: func(mfaToken, code, recoveryCode, clientIP string) (model.TokenPair, error) {
+				return model.TokenPair{}, service.ErrInvalidMFACode
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/mfa/verify", bytes.NewReader([]byte(`{"mfa_token":"challenge","code":"000000"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got %d", w.Code)
+	}
+}
+
+func TestMandatoryMFABlocksUntilEnrolled(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			mfaRequired: map[string]bool{"A": true},
+			enrollFn: func(staffID int64) (model.MFAEnrollment, error) {
+				return model.MFAEnrollment{Secret: "S", ProvisioningURI: "otpauth://totp/x"}, nil
+			},
+		},
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			t.Fatalf("search should not be called without mfa")
+			return nil, nil
+		}},
+	})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/staff/mfa/enroll", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected enrollment to be allowed, got %d", w.Code)
+	}
+}
+
+func TestStaffDeactivate(t *testing.T) {
+	gotActive := true
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			setActiveFn: func(hospital string, actorID, staffID int64, active bool) error {
+				if hospital != "A" || staffID != 7 {
+					return service.ErrStaffNotFound
+				}
+				gotActive = active
+				return nil
+			},
+		},
+	})
+	token := testToken(t, "A", rbac.RoleAdmin)
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/7/deactivate", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || gotActive {
+		t.Fatalf("expected deactivation, got %d active=%v", w.Code, gotActive)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/staff/8/deactivate", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", w.Code)
+	}
+}
+
+func TestStaffLoginDeactivated(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			loginFn: func(username, password, hospital, clientIP string) (model.LoginResult, error) {
+				return model.LoginResult{}, service.ErrAccountDisabled
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewReader([]byte(`{"username":"john","password":"secret","hospital":"A"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestStaffChangePasswordWrongOld(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			changePwFn: func(staffID int64, oldPassword, newPassword, clientIP string) error {
+				if oldPassword != "old" {
+					return service.ErrInvalidCredentials
+				}
+				return nil
+			},
+		},
+	})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/password", bytes.NewReader([]byte(`{"old_password":"wrong","new_password":"next"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestStaffChangePasswordLocked(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			changePwFn: func(staffID int64, oldPassword, newPassword, clientIP string) error {
+				return &service.LoginBlockedError{RetryAfter: time.Minute, Locked: true}
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/password", bytes.NewReader([]byte(`{"old_password":"guess","new_password":"next"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleNurse))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 429 with Retry-After got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestStaffResetPasswordRequiresAdmin(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			resetPwFn: func(hospital string, actorID, staffID int64, newPassword string) error {
+				t.Fatalf("reset should not be called")
+				return nil
+			},
+		},
+	})
+	token := testToken(t, "A", rbac.RoleDoctor)
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/7/password/reset", bytes.NewReader([]byte(`{"password":"next"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestStaffResetPasswordPolicyViolation(t *testing.T) {
+	r := setupRouter(testServices{
+		staff: &fakeStaffService{
+			resetPwFn: func(hospital string, actorID, staffID int64, newPassword string) error {
+				return fmt.Errorf("%w: must be at least 12 characters", passwd.ErrPolicy)
+			},
+		},
+	})
+	token := testToken(t, "A", rbac.RoleAdmin)
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/7/password/reset", bytes.NewReader([]byte(`{"password":"short"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at least 12 characters") {
+		t.Fatalf("expected 400 with policy message, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatientSearchPassesActor(t *testing.T) {
+	patients := &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+		return []model.Patient{}, nil
+	}}
+	r := setupRouter(testServices{patient: patients})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+	got := patients.lastActor
+	if got.StaffID != 1 || got.Hospital != "A" || got.Role != rbac.RoleNurse || got.RequestID != "req-123" || got.ClientIP == "" {
+		t.Fatalf("unexpected actor %+v", got)
+	}
+	if w.Header().Get("X-Request-ID") != "req-123" {
+		t.Fatalf("expected request id to be echoed")
+	}
+}
+
+func TestPatientSearchAuditFailure(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{searchFn: func(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
+			return nil, service.ErrAuditFailed
+		}},
+	})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodPost, "/patient/search", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "patients") {
+		t.Fatalf("expected 500 without results, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuditPatientAccessFilters(t *testing.T) {
+	var got model.AuditFilter
+	audit := &fakeAuditService{listFn: func(hospital string, f model.AuditFilter) ([]model.AuditEntry, error) {
+		if hospital != "A" {
+			t.Fatalf("expected token hospital, got %q", hospital)
+		}
+		got = f
+		return []model.AuditEntry{{ID: 1, StaffID: 7, Hospital: "A", Action: model.AuditActionPatientSearch, PatientIDs: []int64{42}}}, nil
+	}}
+	r := setupRouter(testServices{audit: audit})
+	token := testToken(t, "A", rbac.RoleAuditor)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit/patient-access?staff_id=7&patient_id=42&from=2026-01-01T00:00:00Z&limit=10", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d %s", w.Code, w.Body.String())
+	}
+	if len(got.Actions) != 17 || got.Actions[0] != model.AuditActionPatientSearch {
+		t.Fatalf("expected patient access actions only, got %v", got.Actions)
+	}
+	for _, a := range got.Actions {
+		if !strings.HasPrefix(a, "patient_") {
+			t.Fatalf("unexpected action %q in patient access filter", a)
+		}
+	}
+	if got.StaffID == nil || *got.StaffID != 7 || got.PatientID == nil || *got.PatientID != 42 || got.From == nil || got.To != nil || got.Limit != 10 {
+		t.Fatalf("unexpected filter %+v", got)
+	}
+}
+
+func TestAuditPatientAccessForbiddenForNurse(t *testing.T) {
+	r := setupRouter(testServices{})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit/patient-access", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", w.Code)
+	}
+}
+
+func TestAuditPatientAccessInvalidTime(t *testing.T) {
+	r := setupRouter(testServices{})
+	token := testToken(t, "A", rbac.RoleAuditor)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit/patient-access?from=yesterday", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 got %d", w.Code)
+	}
+}
+
+func TestAuditEntriesActionFilter(t *testing.T) {
+	var got model.AuditFilter
+	audit := &fakeAuditService{listFn: func(hospital string, f model.AuditFilter) ([]model.AuditEntry, error) {
+		got = f
+		return []model.AuditEntry{}, nil
+	}}
+	r := setupRouter(testServices{audit: audit})
+	token := testToken(t, "A", rbac.RoleAuditor)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit/entries?action=staff_login_failed&action=staff_login_succeeded", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+	if len(got.Actions) != 2 || got.Actions[0] != model.AuditActionLoginFailed {
+		t.Fatalf("unexpected actions %v", got.Actions)
+	}
+}
+
+func TestAuditVerifyReportsBrokenLink(t *testing.T) {
+	audit := &fakeAuditService{verifyFn: func(hospital string) (model.AuditVerification, error) {
+		return model.AuditVerification{
+			Hospital: hospital,
+			Entries:  4,
+			BrokenAt: &model.AuditBreak{Seq: 5, EntryID: 42, Reason: "entry content does not match its hash"},
+		}, nil
+	}}
+	r := setupRouter(testServices{audit: audit})
+	token := testToken(t, "A", rbac.RoleAuditor)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit/verify", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body model.AuditVerification
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusOK || body.Valid || body.BrokenAt == nil || body.BrokenAt.Seq != 5 || body.Hospital != "A" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatientGetNotFound(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{getFn: func(hospital string, id int64) (model.Patient, error) {
+			if hospital != "A" || id != 5 {
+				t.Fatalf("unexpected lookup %s/%d", hospital, id)
+			}
+			return model.Patient{}, service.ErrPatientNotFound
+		}},
+	})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodGet, "/patient/5", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", w.Code)
+	}
+}
+
+func TestPatientGetMergedRedirects(t *testing.T) {
+	survivor := int64(7)
+	r := setupRouter(testServices{
+		patient: &fakePatientService{getFn: func(hospital string, id int64) (model.Patient, error) {
+			return model.Patient{ID: id, MergedInto: &survivor}, service.ErrPatientMerged
+		}},
+	})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodGet, "/patient/5", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/patient/7" {
+		t.Fatalf("expected redirect to /patient/7, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["merged_into"] != float64(7) {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+}
+
+func TestPatientReveal(t *testing.T) {
+	nationalID := "1234567890121"
+	fake := &fakePatientService{revealFn: func(hospital string, id int64, reason string) (model.Patient, error) {
+		if reason == "" {
+			return model.Patient{}, service.ErrReasonRequired
+		}
+		return model.Patient{ID: id, Hospital: hospital, NationalID: &nationalID}, nil
+	}}
+	r := setupRouter(testServices{patient: fake})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	for body, want := range map[string]int{`{"reason":"verify identity at admission"}`: http.StatusOK, `{}`: http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodPost, "/patient/5/reveal", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("%s: expected %d got %d, body=%s", body, want, w.Code, w.Body.String())
+		}
+		if want == http.StatusOK && w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatal("expected revealed record not to be cached")
+		}
+	}
+	if fake.lastActor.Role != rbac.RoleNurse {
+		t.Fatalf("expected actor role to reach the service, got %+v", fake.lastActor)
+	}
+}
+
+func TestPatientHistory(t *testing.T) {
+	staffID := int64(3)
+	fake := &fakePatientService{historyFn: func(hospital string, id int64) ([]model.PatientVersion, error) {
+		if id != 5 {
+			return nil, service.ErrPatientNotFound
+		}
+		return []model.PatientVersion{
+			{PatientID: 5, Version: 2, Action: model.PatientVersionHISSync, Source: model.PatientSourceHIS,
+				Changes: map[string]model.FieldChange{"email": {From: "a@example.com", To: nil}}},
+			{PatientID: 5, Version: 1, Action: model.PatientVersionCreate, Source: model.PatientSourceStaff, StaffID: &staffID,
+				Changes: map[string]model.FieldChange{"email": {From: nil, To: "a@example.com"}}},
+		}, nil
+	}}
+	r := setupRouter(testServices{patient: fake})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	req := httptest.NewRequest(http.MethodGet, "/patient/5/history", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d, body=%s", w.Code, w.Body.String())
+	}
+	var got struct {
+		Versions []struct {
+			Version int                           `json:"version"`
+			Source  string                        `json:"source"`
+			Changes map[string]map[string]*string `json:"changes"`
+		} `json:"versions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Versions) != 2 || got.Versions[0].Source != model.PatientSourceHIS || got.Versions[0].Changes["email"]["to"] != nil {
+		t.Fatalf("unexpected history %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/patient/6/history", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", w.Code)
+	}
+}
+
+func TestPatientRestore(t *testing.T) {
+	fake := &fakePatientService{restoreFn: func(hospital string, id int64, version int) (model.Patient, error) {
+		if version != 1 {
+			return model.Patient{}, service.ErrVersionNotFound
+		}
+		return model.Patient{ID: id, Hospital: hospital}, nil
+	}}
+	r := setupRouter(testServices{patient: fake})
+
+	cases := []struct {
+		role string
+		body string
+		want int
+	}{
+		{rbac.RoleRegistrar, `{"version":1}`, http.StatusOK},
+		{rbac.RoleRegistrar, `{"version":9}`, http.StatusNotFound},
+		{rbac.RoleRegistrar, `{}`, http.StatusBadRequest},
+		{rbac.RoleNurse, `{"version":1}`, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/patient/5/restore", bytes.NewReader([]byte(tc.body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "A", tc.role))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s %s: expected %d got %d, body=%s", tc.role, tc.body, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPrivacyRequestCreate(t *testing.T) {
+	privacy := &fakePrivacyService{createFn: func(hospital string, patientID int64, kind, note string) (model.PrivacyRequest, error) {
+		if kind != model.PrivacyRequestExport && kind != model.PrivacyRequestErasure {
+			return model.PrivacyRequest{}, service.ErrInvalidPrivacyRequest
+		}
+		return model.PrivacyRequest{ID: 1, Hospital: hospital, PatientID: patientID, Kind: kind, Status: model.PrivacyRequestPending}, nil
+	}}
+	r := setupRouter(testServices{privacy: privacy})
+
+	cases := []struct {
+		role string
+		body string
+		want int
+	}{
+		{rbac.RoleAdmin, `{"patient_id":5,"kind":"erasure"}`, http.StatusCreated},
+		{rbac.RoleAdmin, `{"patient_id":5,"kind":"delete"}`, http.StatusBadRequest},
+		{rbac.RoleAdmin, `{"kind":"export"}`, http.StatusBadRequest},
+		{rbac.RoleRegistrar, `{"patient_id":5,"kind":"export"}`, http.StatusForbidden},
+		{rbac.RoleAuditor, `{"patient_id":5,"kind":"export"}`, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/privacy/requests", bytes.NewReader([]byte(tc.body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "A", tc.role))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s %s: expected %d got %d, body=%s", tc.role, tc.body, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPrivacyExport(t *testing.T) {
+	nationalID := "1234567890121"
+	privacy := &fakePrivacyService{exportFn: func(hospital string, id int64) (model.PrivacyRequest, model.PatientExport, error) {
+		req := model.PrivacyRequest{ID: id, Hospital: hospital, PatientID: 5, Kind: model.PrivacyRequestExport, Status: model.PrivacyRequestCompleted}
+		return req, model.PatientExport{RequestID: id, Patient: model.Patient{ID: 5, NationalID: &nationalID}}, nil
+	}}
+	r := setupRouter(testServices{privacy: privacy})
+
+	req := httptest.NewRequest(http.MethodPost, "/privacy/requests/3/export", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d, body=%s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || !strings.Contains(w.Header().Get("Content-Disposition"), "patient-5-export.json") {
+		t.Fatalf("unexpected headers %v", w.Header())
+	}
+	if !strings.Contains(w.Body.String(), nationalID) {
+		t.Fatalf("expected unmasked export, body=%s", w.Body.String())
+	}
+}
+
+func TestPrivacyEraseLegalHold(t *testing.T) {
+	privacy := &fakePrivacyService{eraseFn: func(hospital string, id int64) (model.PrivacyRequest, error) {
+		return model.PrivacyRequest{}, service.ErrLegalHold
+	}}
+	r := setupRouter(testServices{privacy: privacy})
+
+	req := httptest.NewRequest(http.MethodPost, "/privacy/requests/3/erase", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 got %d, body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatientMerge(t *testing.T) {
+	mpi := &fakeMPIService{mergeFn: func(hospital string, survivorID, mergedID int64, reason string) (model.PatientMerge, error) {
+		if hospital != "A" || survivorID != 1 || mergedID != 2 || reason != "same person" {
+			t.Fatalf("unexpected merge %s %d<-%d %q", hospital, survivorID, mergedID, reason)
+		}
+		return model.PatientMerge{ID: 9, Hospital: hospital, SurvivorID: 1, MergedID: 2, FilledFields: []string{"passport_id"}}, nil
+	}}
+	r := setupRouter(testServices{mpi: mpi})
+	body := `{"survivor_id":1,"merged_id":2,"reason":"same person"}`
+
+	for role, want := range map[string]int{rbac.RoleRegistrar: http.StatusCreated, rbac.RoleNurse: http.StatusForbidden, rbac.RoleDoctor: http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, "/patient/merge", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "A", role))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("%s: expected %d got %d, body=%s", role, want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPatientUnmergeConflict(t *testing.T) {
+	mpi := &fakeMPIService{unmergeFn: func(hospital string, mergeID int64) (model.PatientMerge, error) {
+		if mergeID != 9 {
+			t.Fatalf("unexpected merge id %d", mergeID)
+		}
+		return model.PatientMerge{}, service.ErrMergeConflict
+	}}
+	r := setupRouter(testServices{mpi: mpi})
+
+	req := httptest.NewRequest(http.MethodPost, "/patient/merges/9/revert", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleAdmin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 got %d", w.Code)
+	}
+}
+
+func TestPatientDuplicates(t *testing.T) {
+	mpi := &fakeMPIService{duplicatesFn: func(hospital string, limit int) ([]model.DuplicateCandidate, error) {
+		if limit != 20 {
+			t.Fatalf("expected limit 20, got %d", limit)
+		}
+		return []model.DuplicateCandidate{{ID: 3, PatientID: 1, DuplicateID: 2, Score: 0.7, Reasons: []string{"name"}, Status: model.DuplicatePending}}, nil
+	}}
+	r := setupRouter(testServices{mpi: mpi})
+
+	req := httptest.NewRequest(http.MethodGet, "/patient/duplicates?limit=20", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "A", rbac.RoleRegistrar))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d, body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Duplicates []model.DuplicateCandidate `json:"duplicates"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Duplicates) != 1 || resp.Duplicates[0].DuplicateID != 2 {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+}
+
+func TestPatientCreate(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{createFn: func(hospital string, p model.Patient) (model.Patient, error) {
+			if p.NationalID != nil && *p.NationalID == "1111111111111" {
+				return model.Patient{}, service.ErrPatientConflict
+			}
+			p.ID, p.Hospital = 10, hospital
+			return p, nil
+		}},
+	})
+	token := testToken(t, "A", rbac.RoleRegistrar)
+
+	body := `{"first_name_en":"Somchai","last_name_en":"Jaidee","national_id":"1234567890123","date_of_birth":"1990-01-01T00:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPost, "/patient", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"id":10`) {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+
+	body = `{"first_name_en":"Somchai","last_name_en":"Jaidee","national_id":"1111111111111"}`
+	req = httptest.NewRequest(http.MethodPost, "/patient", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 got %d", w.Code)
+	}
+}
+
+func TestPatientCreateInvalid(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{createFn: func(hospital string, p model.Patient) (model.Patient, error) {
+			return model.Patient{}, fmt.Errorf("%w: national_id must be 13 digits", service.ErrInvalidPatient)
+		}},
+	})
+	token := testToken(t, "A", rbac.RoleRegistrar)
+
+	req := httptest.NewRequest(http.MethodPost, "/patient", bytes.NewReader([]byte(`{"national_id":"12"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "13 digits") {
+		t.Fatalf("expected 400 with reason, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatientWriteForbiddenForNurse(t *testing.T) {
+	r := setupRouter(testServices{
+		patient: &fakePatientService{
+			updateFn: func(hospital string, id int64, patch json.RawMessage) (model.Patient, error) {
+				t.Fatalf("update should not be called")
+				return model.Patient{}, nil
+			},
+			deleteFn: func(hospital string, id int64) error {
+				t.Fatalf("delete should not be called")
+				return nil
+			},
+		},
+	})
+	token := testToken(t, "A", rbac.RoleNurse)
+
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/patient/5", bytes.NewReader([]byte(`{"email":null}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403 got %d", method, w.Code)
+		}
+	}
+}
+
+func TestPatientUpdateAndDelete(t *testing.T) {
+	var gotPatch string
+	r := setupRouter(testServices{
+		patient: &fakePatientService{
+			updateFn: func(hospital string, id int64, patch json.RawMessage) (model.Patient, error) {
+				gotPatch = string(patch)
+				return model.Patient{ID: id, Hospital: hospital}, nil
+			},
+			deleteFn: func(hospital string, id int64) error {
+				switch id {
+				case 5:
+					return nil
+				case 7:
+					return service.ErrLegalHold
+				}
+				return service.ErrPatientNotFound
+			},
+		},
+	})
+	token := testToken(t, "A", rbac.RoleDoctor)
+
+	req := httptest.NewRequest(http.MethodPatch, "/patient/5", bytes.NewReader([]byte(`{"email":null}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || gotPatch != `{"email":null}` {
+		t.Fatalf("expected 200 with raw patch, got %d %q", w.Code, gotPatch)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/patient/5", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/patient/6", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/patient/7", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a held patient got %d", w.Code)
+	}
+}
+
+func TestHealthReportsOpenBreaker(t *testing.T) {
+	registry := &fakeHISRegistry{statuses: []his.Status{
+		{Hospital: "A", Breaker: his.BreakerClosed, Requests: map[string]int64{his.ResultSuccess: 3}},
+		{Hospital: "B", Breaker: his.BreakerOpen, ConsecutiveFailures: 5, Requests: map[string]int64{his.ResultFailure: 5, his.ResultRejected: 2}, Retries: 4},
+	}}
+	r := setupRouter(testServices{registry: registry})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body) != 1 || body["status"] != "degraded" {
+		t.Fatalf("expected only a degraded status, got %v", body)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`his_breaker_state{hospital="B"} 2`,
+		`his_consecutive_failures{hospital="B"} 5`,
+		`his_requests_total{hospital="A",result="success"} 3`,
+		`his_requests_total{hospital="B",result="rejected"} 2`,
+		`his_retries_total{hospital="B"} 4`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Fatalf("expected metric %s in:\n%s", line, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Package identifier normalizes and validates patient identifiers so that the
+// same person is stored and matched under one spelling of their ID.
+package identifier
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidNationalID = errors.New("national_id must be 13 digits with a valid check digit")
+
+// NationalID strips spaces and dashes from a Thai citizen ID
+// ("1-2345-67890-12-1") and checks its length and check digit.
+func NationalID(s string) (string, error) {
+	id := strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if len(id) != 13 {
+		return "", ErrInvalidNationalID
+	}
+	sum := 0
+	for i := 0; i < 13; i++ {
+		d := id[i]
+		if d < '0' || d > '9' {
+			return "", ErrInvalidNationalID
+		}
+		if i < 12 {
+			sum += int(d-'0') * (13 - i)
+		}
+	}
+	if int(id[12]-'0') != (11-sum%11)%10 {
+		return "", ErrInvalidNationalID
+	}
+	return id, nil
+}
+
+// Passport uppercases a passport number and removes all whitespace.
+func Passport(s string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s))
+}
@@ -0,0 +1,26 @@
+package identifier
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNationalID(t *testing.T) {
+	for _, in := range []string{"1234567890121", "1-2345-67890-12-1", " 1 2345 67890 12 1 "} {
+		got, err := NationalID(in)
+		if err != nil || got != "1234567890121" {
+			t.Fatalf("NationalID(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "1234567890123", "123456789012", "12345678901210", "12345678901a1"} {
+		if _, err := NationalID(in); !errors.Is(err, ErrInvalidNationalID) {
+			t.Fatalf("NationalID(%q) accepted, err=%v", in, err)
+		}
+	}
+}
+
+func TestPassport(t *testing.T) {
+	if got := Passport(" aa 123\t456 "); got != "AA123456" {
+		t.Fatalf("Passport() = %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeEd25519(t *testing.T, dir, kid string, publicOnly bool) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	var block *pem.Block
+	name := kid + ".pem"
+	if publicOnly {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatalf("marshal public: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+		name = kid + ".pub.pem"
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatalf("marshal private: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+func TestRotationKeepsOldTokensValid(t *testing.T) {
+	dir := t.TempDir()
+	writeEd25519(t, dir, "k1", false)
+
+	ks, err := LoadDir(dir, "")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	claims := jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}
+	old, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	writeEd25519(t, dir, "k2", false)
+	ks.activeKID = "k2"
+	if err := ks.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	fresh, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	for _, tok := range []string{old, fresh} {
+		parsed, err := jwt.Parse(tok, ks.Keyfunc, jwt.WithValidMethods(ks.Methods()))
+		if err != nil || !parsed.Valid {
+			t.Fatalf("expected token to verify after rotation: %v", err)
+		}
+	}
+	if len(ks.JWKS().Keys) != 2 {
+		t.Fatalf("expected both keys published")
+	}
+}
+
+func TestLoadDirRequiresActivePrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	writeEd25519(t, dir, "retired", true)
+	if _, err := LoadDir(dir, "retired"); err == nil {
+		t.Fatalf("expected error when active key is public only")
+	}
+}
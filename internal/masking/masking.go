@@ -0,0 +1,150 @@
+// Package masking hides sensitive patient identifiers from staff who do not
+// need to see them in full.
+package masking
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"agnos/internal/model"
+	"agnos/internal/rbac"
+)
+
+const (
+	FieldNationalID = "national_id"
+	FieldPassportID = "passport_id"
+	FieldPhone      = "phone_number"
+	FieldEmail      = "email"
+)
+
+var DefaultFields = []string{FieldNationalID, FieldPassportID, FieldPhone, FieldEmail}
+
+// ValidateFields rejects a field that cannot be masked, so a misspelt name
+// does not silently leave that field in clear.
+func ValidateFields(fields []string) error {
+	for _, f := range fields {
+		if !slices.Contains(DefaultFields, f) {
+			return fmt.Errorf("masking: unknown field %q, expected one of %s", f, strings.Join(DefaultFields, ", "))
+		}
+	}
+	return nil
+}
+
+// Policy says which fields are masked and which roles see them in clear.
+type Policy struct {
+	Fields        []string
+	UnmaskedRoles []string
+}
+
+// DefaultPolicy masks DefaultFields for every role without the
+// patient:unmask permission.
+func DefaultPolicy() Policy {
+	return Policy{Fields: DefaultFields, UnmaskedRoles: rbac.RolesWith(rbac.PermPatientUnmask)}
+}
+
+// Policies holds the default policy and per-hospital overrides.
+type Policies struct {
+	Default   Policy
+	Hospitals map[string]Policy
+}
+
+func (p Policies) For(hospital string) Policy {
+	if policy, ok := p.Hospitals[hospital]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Masks reports whether role sees masked values under p.
+func (p Policy) Masks(role string) bool {
+	if len(p.Fields) == 0 {
+		return false
+	}
+	for _, r := range p.UnmaskedRoles {
+		if r == role {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns pt with the policy's fields masked. pt itself is not
+// modified.
+func (p Policy) Apply(pt model.Patient) model.Patient {
+	for _, f := range p.Fields {
+		switch f {
+		case FieldNationalID:
+			pt.NationalID = mask(pt.NationalID, NationalID)
+		case FieldPassportID:
+			pt.PassportID = mask(pt.PassportID, Passport)
+		case FieldPhone:
+			pt.PhoneNumber = mask(pt.PhoneNumber, Phone)
+			pt.PhoneE164 = mask(pt.PhoneE164, Phone)
+		case FieldEmail:
+			pt.Email = mask(pt.Email, Email)
+		}
+	}
+	return pt
+}
+
+func mask(v *string, fn func(string) string) *string {
+	if v == nil {
+		return nil
+	}
+	m := fn(*v)
+	return &m
+}
+
+// NationalID shows the first five and last three digits in the usual
+// grouping: 1234567890121 becomes 1-2345-xxxxx-12-1.
+func NationalID(id string) string {
+	if len(id) != 13 {
+		return keepEnds(id, 0, 2)
+	}
+	return id[:1] + "-" + id[1:5] + "-xxxxx-" + id[10:12] + "-" + id[12:]
+}
+
+// Passport shows the first two and last two characters.
+func Passport(id string) string {
+	return keepEnds(id, 2, 2)
+}
+
+// Phone keeps the last four digits and the formatting: 081-234-5678 becomes
+// xxx-xxx-5678.
+func Phone(number string) string {
+	digits := 0
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	var b strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits--
+			if digits >= 4 {
+				r = 'x'
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Email keeps the first character of the local part and the domain.
+func Email(addr string) string {
+	local, domain, ok := strings.Cut(addr, "@")
+	if !ok || local == "" {
+		return keepEnds(addr, 0, 0)
+	}
+	return local[:1] + "***@" + domain
+}
+
+func keepEnds(s string, head, tail int) string {
+	r := []rune(s)
+	if len(r) <= head+tail {
+		return strings.Repeat("x", len(r))
+	}
+	return string(r[:head]) + strings.Repeat("x", len(r)-head-tail) + string(r[len(r)-tail:])
+}
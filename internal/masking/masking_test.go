@@ -0,0 +1,57 @@
+package masking
+
+import (
+	"testing"
+
+	"agnos/internal/model"
+	"agnos/internal/rbac"
+)
+
+func TestMaskValues(t *testing.T) {
+	cases := []struct {
+		fn   func(string) string
+		in   string
+		want string
+	}{
+		{NationalID, "1234567890121", "1-2345-xxxxx-12-1"},
+		{Passport, "AA123456", "AAxxxx56"},
+		{Phone, "081-234-5678", "xxx-xxx-5678"},
+		{Phone, "+66812345678", "+xxxxxxx5678"},
+		{Email, "somchai@example.com", "s***@example.com"},
+		{Email, "not-an-email", "xxxxxxxxxxxx"},
+	}
+	for _, tc := range cases {
+		if got := tc.fn(tc.in); got != tc.want {
+			t.Fatalf("mask(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestValidateFields(t *testing.T) {
+	if err := ValidateFields(DefaultFields); err != nil {
+		t.Fatalf("default fields rejected: %v", err)
+	}
+	if err := ValidateFields([]string{FieldEmail, "nationalid"}); err == nil {
+		t.Fatal("expected an unknown field to be rejected")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policies := Policies{
+		Default:   DefaultPolicy(),
+		Hospitals: map[string]Policy{"B": {Fields: []string{FieldNationalID}}},
+	}
+	if policies.For("A").Masks(rbac.RoleRegistrar) || !policies.For("A").Masks(rbac.RoleNurse) {
+		t.Fatal("expected the default policy to mask for nurses only")
+	}
+
+	id, phone := "1234567890121", "0812345678"
+	p := model.Patient{NationalID: &id, PhoneNumber: &phone}
+	masked := policies.For("B").Apply(p)
+	if *masked.NationalID != "1-2345-xxxxx-12-1" || *masked.PhoneNumber != phone {
+		t.Fatalf("unexpected masking %+v", masked)
+	}
+	if *p.NationalID != id {
+		t.Fatal("Apply modified its input")
+	}
+}
@@ -3,15 +3,43 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
+
+	"agnos/internal/model"
+	"agnos/internal/rbac"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-const contextHospitalKey = "hospital"
+const contextClaimsKey = "claims"
+
+type Claims struct {
+	TokenID   string
+	SessionID string
+	StaffID   int64
+	Hospital  string
+	Role      string
+	MFA       bool
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type KeyResolver interface {
+	Keyfunc(token *jwt.Token) (interface{}, error)
+	Methods() []string
+}
+
+type MFAPolicy interface {
+	MFARequired(hospital string) bool
+}
+
+type RevocationChecker interface {
+	IsRevoked(tokenID, sessionID string, staffID int64, issuedAt time.Time) (bool, error)
+}
 
-func JWTAuth(secret string) gin.HandlerFunc {
-	key := []byte(secret)
+func JWTAuth(keys KeyResolver, revocations RevocationChecker) gin.HandlerFunc {
+	parser := jwt.NewParser(jwt.WithValidMethods(keys.Methods()), jwt.WithExpirationRequired())
 	return func(c *gin.Context) {
 		authz := c.GetHeader("Authorization")
 		if !strings.HasPrefix(authz, "Bearer ") {
@@ -24,23 +52,18 @@ func JWTAuth(secret string) gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrTokenInvalidClaims
-			}
-			return key, nil
-		})
+		token, err := parser.Parse(tokenStr, keys.Keyfunc)
 		if err != nil || !token.Valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
+		mapClaims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || mapClaims["typ"] != "access" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
 			return
 		}
-		hospitalAny, ok := claims["hospital"]
+		hospitalAny, ok := mapClaims["hospital"]
 		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "hospital not found in token"})
 			return
@@ -50,17 +73,122 @@ func JWTAuth(secret string) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "hospital not found in token"})
 			return
 		}
+		role, ok := mapClaims["role"].(string)
+		if !ok || !rbac.ValidRole(role) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "role not found in token"})
+			return
+		}
+		jti, ok := mapClaims["jti"].(string)
+		if !ok || jti == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token id not found in token"})
+			return
+		}
 
-		c.Set(contextHospitalKey, hospital)
+		staffID, ok := mapClaims["staff_id"].(float64)
+		if !ok || staffID <= 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "staff not found in token"})
+			return
+		}
+		sid, ok := mapClaims["sid"].(string)
+		if !ok || sid == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session not found in token"})
+			return
+		}
+
+		claims := Claims{TokenID: jti, SessionID: sid, StaffID: int64(staffID), Hospital: hospital, Role: role}
+		if amr, ok := mapClaims["amr"].([]interface{}); ok {
+			for _, m := range amr {
+				if m == "otp" {
+					claims.MFA = true
+				}
+			}
+		}
+		if iat, err := mapClaims.GetIssuedAt(); err == nil && iat != nil {
+			claims.IssuedAt = iat.Time
+		}
+		if exp, err := mapClaims.GetExpirationTime(); err == nil && exp != nil {
+			claims.ExpiresAt = exp.Time
+		}
+
+		revoked, err := revocations.IsRevoked(claims.TokenID, claims.SessionID, claims.StaffID, claims.IssuedAt)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "token revocation check failed"})
+			return
+		}
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
+			return
+		}
+
+		c.Set(contextClaimsKey, claims)
 		c.Next()
 	}
 }
 
-func HospitalFromContext(c *gin.Context) string {
-	v, ok := c.Get(contextHospitalKey)
+// RequireMFA blocks tokens that were issued without a second factor when the
+// staff member's hospital mandates MFA. Routes needed to enroll must not use it.
+func RequireMFA(policy MFAPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsFromContext(c)
+		if !claims.MFA && policy.MFARequired(claims.Hospital) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "mfa enrollment required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := RoleFromContext(c)
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+func RequirePermission(perm rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rbac.HasPermission(RoleFromContext(c), perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func ClaimsFromContext(c *gin.Context) Claims {
+	v, ok := c.Get(contextClaimsKey)
 	if !ok {
-		return ""
+		return Claims{}
+	}
+	claims, _ := v.(Claims)
+	return claims
+}
+
+func HospitalFromContext(c *gin.Context) string {
+	return ClaimsFromContext(c).Hospital
+}
+
+func RoleFromContext(c *gin.Context) string {
+	return ClaimsFromContext(c).Role
+}
+
+func StaffIDFromContext(c *gin.Context) int64 {
+	return ClaimsFromContext(c).StaffID
+}
+
+func ActorFromContext(c *gin.Context) model.Actor {
+	claims := ClaimsFromContext(c)
+	return model.Actor{
+		StaffID:   claims.StaffID,
+		Hospital:  claims.Hospital,
+		Role:      claims.Role,
+		ClientIP:  c.ClientIP(),
+		RequestID: RequestIDFromContext(c),
 	}
-	s, _ := v.(string)
-	return s
 }
@@ -1,12 +1,160 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Staff struct {
 	ID           int64  `json:"id"`
 	Username     string `json:"username"`
 	PasswordHash string `json:"-"`
 	Hospital     string `json:"hospital"`
+	Role         string `json:"role"`
+	MFAEnabled   bool   `json:"mfa_enabled"`
+	MFASecret    string `json:"-"`
+	Active       bool   `json:"active"`
+}
+
+type Session struct {
+	ID          string    `json:"id"`
+	StaffID     int64     `json:"staff_id"`
+	MFAVerified bool      `json:"mfa_verified"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// LoginResult is either a token pair or, for staff with MFA enabled, a
+// short-lived challenge token to exchange at /staff/mfa/verify.
+type LoginResult struct {
+	*TokenPair
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"otpauth_uri"`
+}
+
+type LoginThrottle struct {
+	Scope        string
+	Key          string
+	Failures     int
+	BlockedUntil *time.Time
+	Locked       bool
+}
+
+const (
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventIPLocked        = "ip_locked"
+	SecurityEventMFAReset        = "mfa_reset"
+	SecurityEventDeactivated     = "staff_deactivated"
+	SecurityEventReactivated     = "staff_reactivated"
+	SecurityEventPasswordChanged = "password_changed"
+	SecurityEventPasswordReset   = "password_reset"
+)
+
+type SecurityEvent struct {
+	ID           int64     `json:"id"`
+	Hospital     string    `json:"hospital"`
+	Type         string    `json:"event_type"`
+	StaffID      *int64    `json:"staff_id,omitempty"`
+	Username     string    `json:"username,omitempty"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	ActorStaffID *int64    `json:"actor_staff_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Actor is the staff member behind a request, as recorded in the audit log.
+type Actor struct {
+	StaffID   int64
+	Hospital  string
+	Role      string
+	ClientIP  string
+	RequestID string
+}
+
+const (
+	AuditActionPatientSearch  = "patient_search"
+	AuditActionPatientView    = "patient_view"
+	AuditActionPatientCreate  = "patient_create"
+	AuditActionPatientUpdate  = "patient_update"
+	AuditActionPatientDelete  = "patient_delete"
+	AuditActionPatientReveal  = "patient_reveal"
+	AuditActionPatientMerge   = "patient_merge"
+	AuditActionPatientUnmerge = "patient_unmerge"
+	AuditActionMergeList      = "patient_merge_list"
+	AuditActionDuplicateList  = "patient_duplicate_list"
+	AuditActionDuplicateSkip  = "patient_duplicate_dismiss"
+	AuditActionPatientHistory = "patient_history"
+	AuditActionPatientRestore = "patient_restore"
+	AuditActionPatientExport  = "patient_export"
+	AuditActionPatientErase   = "patient_erase"
+	AuditActionHoldPlace      = "patient_hold_place"
+	AuditActionHoldRelease    = "patient_hold_release"
+	AuditActionLoginSucceeded = "staff_login_succeeded"
+	AuditActionLoginFailed    = "staff_login_failed"
+)
+
+// AuditEntry is one link of a hospital's audit chain. StaffID is 0 for failed
+// logins with an unknown username.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Seq        int64           `json:"seq"`
+	StaffID    int64           `json:"staff_id,omitempty"`
+	Hospital   string          `json:"hospital"`
+	Action     string          `json:"action"`
+	Criteria   json.RawMessage `json:"criteria,omitempty"`
+	PatientIDs []int64         `json:"patient_ids"`
+	ClientIP   string          `json:"client_ip,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+type AuditFilter struct {
+	Actions   []string
+	StaffID   *int64
+	PatientID *int64
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+}
+
+// AuditCheckpoint is a signed statement of a chain's head, so a rewrite of
+// the whole chain by someone without the signing key is detected.
+type AuditCheckpoint struct {
+	ID        int64     `json:"id"`
+	Hospital  string    `json:"hospital"`
+	Seq       int64     `json:"seq"`
+	Hash      string    `json:"hash"`
+	Signature string    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditVerification struct {
+	Hospital    string `json:"hospital"`
+	Entries     int64  `json:"entries"`
+	Checkpoints int    `json:"checkpoints"`
+	// Unverifiable counts checkpoints whose signing key is not available.
+	Unverifiable int         `json:"unverifiable_checkpoints"`
+	Valid        bool        `json:"valid"`
+	BrokenAt     *AuditBreak `json:"broken_at,omitempty"`
+}
+
+type AuditBreak struct {
+	Seq     int64  `json:"seq"`
+	EntryID int64  `json:"entry_id,omitempty"`
+	Reason  string `json:"reason"`
 }
 
 type Patient struct {
@@ -23,8 +171,17 @@ type Patient struct {
 	NationalID   *string    `json:"national_id,omitempty"`
 	PassportID   *string    `json:"passport_id,omitempty"`
 	PhoneNumber  *string    `json:"phone_number,omitempty"`
+	PhoneE164    *string    `json:"phone_e164,omitempty"`
 	Email        *string    `json:"email,omitempty"`
 	Gender       *string    `json:"gender,omitempty"`
+	MergedInto   *int64     `json:"merged_into,omitempty"`
+	ErasedAt     *time.Time `json:"erased_at,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
+
+	// Source is set on search results only: PatientSourceHIS if the search
+	// fetched the patient from the HIS, PatientSourceLocal otherwise.
+	Source string `json:"source,omitempty"`
 }
 
 type PatientSearchCriteria struct {
@@ -36,4 +193,188 @@ type PatientSearchCriteria struct {
 	DateOfBirth *string `json:"date_of_birth"`
 	PhoneNumber *string `json:"phone_number"`
 	Email       *string `json:"email"`
+
+	Sort   string `json:"sort,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+	Total  string `json:"total,omitempty"`
+
+	// HISStrict fails the search when the HIS lookup fails instead of
+	// returning local results only.
+	HISStrict bool `json:"his_strict,omitempty"`
+}
+
+const (
+	PatientSortID        = "id"
+	PatientSortUpdatedAt = "updated_at"
+	PatientSortName      = "name"
+	PatientSortRelevance = "relevance"
+
+	TotalExact    = "exact"
+	TotalEstimate = "estimate"
+)
+
+// Outcomes of the HIS lookup of a search. The HIS is skipped when the search
+// has no national ID or passport, the patient is already stored, or the
+// hospital has no HIS.
+const (
+	HISStatusHit     = "hit"
+	HISStatusMiss    = "miss"
+	HISStatusError   = "error"
+	HISStatusSkipped = "skipped"
+)
+
+// HISSyncRun is what one pass of the sync worker did for a hospital:
+// patients checked against the HIS, stored again, no longer in the HIS, and
+// failed. Stopped is set if the HIS became unavailable during the pass.
+type HISSyncRun struct {
+	Hospital  string
+	Checked   int
+	Refreshed int
+	Missing   int
+	Failed    int
+	Stopped   bool
+}
+
+// PatientPage is one page of search results. NextCursor is empty on the last
+// page; Total is only set when the search asked for it. HISError says why the
+// HIS lookup failed.
+type PatientPage struct {
+	Patients       []Patient `json:"patients"`
+	NextCursor     string    `json:"next_cursor,omitempty"`
+	Total          *int64    `json:"total,omitempty"`
+	TotalEstimated bool      `json:"total_estimated,omitempty"`
+	HISStatus      string    `json:"his_status"`
+	HISError       string    `json:"his_error,omitempty"`
+}
+
+const (
+	DuplicatePending   = "pending"
+	DuplicateMerged    = "merged"
+	DuplicateDismissed = "dismissed"
+)
+
+// DuplicateCandidate is a pair of patients the matching engine believes to be
+// the same person, waiting for staff review. PatientID is the lower ID.
+type DuplicateCandidate struct {
+	ID          int64      `json:"id"`
+	Hospital    string     `json:"hospital"`
+	PatientID   int64      `json:"patient_id"`
+	DuplicateID int64      `json:"duplicate_id"`
+	Score       float64    `json:"score"`
+	Reasons     []string   `json:"reasons"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReviewedBy  *int64     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	Patients    []Patient  `json:"patients,omitempty"`
+}
+
+// PatientMerge records that MergedID was folded into SurvivorID. The merged
+// patient is kept as a tombstone redirecting to the survivor; FilledFields
+// are the survivor fields that were empty and taken from the merged patient.
+// MergedBefore is the merged patient as it was, so the merge can be reverted.
+type PatientMerge struct {
+	ID           int64      `json:"id"`
+	Hospital     string     `json:"hospital"`
+	SurvivorID   int64      `json:"survivor_id"`
+	MergedID     int64      `json:"merged_id"`
+	StaffID      int64      `json:"staff_id"`
+	Reason       string     `json:"reason,omitempty"`
+	FilledFields []string   `json:"filled_fields"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevertedBy   *int64     `json:"reverted_by,omitempty"`
+	RevertedAt   *time.Time `json:"reverted_at,omitempty"`
+	MergedBefore Patient    `json:"-"`
+}
+
+const (
+	PatientVersionImport  = "import"
+	PatientVersionCreate  = "create"
+	PatientVersionUpdate  = "update"
+	PatientVersionHISSync = "his_sync"
+	PatientVersionMerge   = "merge"
+	PatientVersionUnmerge = "unmerge"
+	PatientVersionRestore = "restore"
+	PatientVersionErase   = "erase"
+	PatientVersionDelete  = "delete"
+
+	PatientSourceStaff  = "staff"
+	PatientSourceHIS    = "his"
+	PatientSourceSystem = "system"
+	PatientSourceLocal  = "local"
+)
+
+// PatientVersion is one change to a patient. Record is the patient as it was
+// after the change and Fields the JSON names of the fields it changed;
+// Changes holds their old and new values and is filled for display only.
+type PatientVersion struct {
+	PatientID    int64                  `json:"patient_id"`
+	Version      int                    `json:"version"`
+	Action       string                 `json:"action"`
+	Source       string                 `json:"source"`
+	StaffID      *int64                 `json:"staff_id,omitempty"`
+	RestoredFrom *int                   `json:"restored_from,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	Changes      map[string]FieldChange `json:"changes"`
+	Fields       []string               `json:"-"`
+	Record       Patient                `json:"-"`
+}
+
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+const (
+	PrivacyRequestExport  = "export"
+	PrivacyRequestErasure = "erasure"
+
+	PrivacyRequestPending   = "pending"
+	PrivacyRequestCompleted = "completed"
+	PrivacyRequestRejected  = "rejected"
+)
+
+// PrivacyRequest is a PDPA data subject request for the export or erasure of
+// a patient's data. It is closed by completing or rejecting it; Resolution
+// holds the reason for a rejection.
+type PrivacyRequest struct {
+	ID          int64      `json:"id"`
+	Hospital    string     `json:"hospital"`
+	PatientID   int64      `json:"patient_id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	Note        string     `json:"note,omitempty"`
+	RequestedBy int64      `json:"requested_by"`
+	DueAt       time.Time  `json:"due_at"`
+	Overdue     bool       `json:"overdue"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ClosedBy    *int64     `json:"closed_by,omitempty"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+	Resolution  string     `json:"resolution,omitempty"`
+}
+
+// LegalHold keeps a patient from being erased until it is released.
+type LegalHold struct {
+	ID         int64      `json:"id"`
+	Hospital   string     `json:"hospital"`
+	PatientID  int64      `json:"patient_id"`
+	Reason     string     `json:"reason"`
+	PlacedBy   int64      `json:"placed_by"`
+	PlacedAt   time.Time  `json:"placed_at"`
+	ReleasedBy *int64     `json:"released_by,omitempty"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+}
+
+// PatientExport is everything stored about a patient: the record, the records
+// merged into it, its versions and merges, and the audit entries that refer
+// to it.
+type PatientExport struct {
+	GeneratedAt   time.Time        `json:"generated_at"`
+	RequestID     int64            `json:"request_id"`
+	Patient       Patient          `json:"patient"`
+	MergedRecords []Patient        `json:"merged_records"`
+	Versions      []PatientVersion `json:"versions"`
+	Merges        []PatientMerge   `json:"merges"`
+	AuditEntries  []AuditEntry     `json:"audit_entries"`
 }
@@ -0,0 +1,139 @@
+// Package mpi is the master patient index: it scores how likely two patient
+// records describe the same person and folds one record into another.
+package mpi
+
+import (
+	"strings"
+
+	"agnos/internal/model"
+)
+
+// CandidateThreshold is the score from which a pair is queued for review.
+const CandidateThreshold = 0.5
+
+const (
+	ReasonNationalID     = "national_id"
+	ReasonPassportID     = "passport_id"
+	ReasonDateOfBirth    = "date_of_birth"
+	ReasonName           = "name"
+	ReasonSimilarName    = "similar_name"
+	ReasonPhone          = "phone"
+	ReasonEmail          = "email"
+	ReasonConflictingID  = "conflicting_national_id"
+	ReasonConflictingDOB = "conflicting_date_of_birth"
+)
+
+const (
+	similarNameThreshold  = 0.7
+	conflictingDOBPenalty = 0.3
+)
+
+var weights = map[string]float64{
+	ReasonNationalID:  0.6,
+	ReasonPassportID:  0.5,
+	ReasonDateOfBirth: 0.2,
+	ReasonName:        0.3,
+	ReasonSimilarName: 0.15,
+	ReasonPhone:       0.2,
+	ReasonEmail:       0.1,
+}
+
+type Match struct {
+	Score   float64
+	Reasons []string
+}
+
+// Score compares a and b. Two different national IDs mean two different
+// people whatever else agrees; a different date of birth lowers the score.
+func Score(a, b model.Patient) Match {
+	if present(a.NationalID) && present(b.NationalID) && *a.NationalID != *b.NationalID {
+		return Match{Reasons: []string{ReasonConflictingID}}
+	}
+	var m Match
+	add := func(reason string) {
+		m.Score += weights[reason]
+		m.Reasons = append(m.Reasons, reason)
+	}
+	if equal(a.NationalID, b.NationalID) {
+		add(ReasonNationalID)
+	}
+	if equal(a.PassportID, b.PassportID) {
+		add(ReasonPassportID)
+	}
+	if a.DateOfBirth != nil && b.DateOfBirth != nil {
+		if a.DateOfBirth.Equal(*b.DateOfBirth) {
+			add(ReasonDateOfBirth)
+		} else {
+			m.Score -= conflictingDOBPenalty
+			m.Reasons = append(m.Reasons, ReasonConflictingDOB)
+		}
+	}
+	switch nameScore := max(
+		similarity(fullName(a.FirstNameEN, a.LastNameEN), fullName(b.FirstNameEN, b.LastNameEN)),
+		similarity(fullName(a.FirstNameTH, a.LastNameTH), fullName(b.FirstNameTH, b.LastNameTH)),
+	); {
+	case nameScore == 1:
+		add(ReasonName)
+	case nameScore >= similarNameThreshold:
+		add(ReasonSimilarName)
+	}
+	if equal(a.PhoneE164, b.PhoneE164) {
+		add(ReasonPhone)
+	}
+	if present(a.Email) && present(b.Email) && strings.EqualFold(*a.Email, *b.Email) {
+		add(ReasonEmail)
+	}
+	m.Score = min(max(m.Score, 0), 1)
+	return m
+}
+
+func present(v *string) bool {
+	return v != nil && strings.TrimSpace(*v) != ""
+}
+
+func equal(a, b *string) bool {
+	return present(a) && present(b) && *a == *b
+}
+
+func fullName(first, last *string) string {
+	if !present(first) || !present(last) {
+		return ""
+	}
+	return strings.Join(strings.Fields(strings.ToLower(*first+" "+*last)), " ")
+}
+
+// similarity is the Dice coefficient of the character bigrams of a and b,
+// 1 for equal names and 0 when either is empty.
+func similarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	ga, gb := bigrams(a), bigrams(b)
+	if len(ga) == 0 || len(gb) == 0 {
+		return 0
+	}
+	shared := 0
+	for g, n := range ga {
+		shared += min(n, gb[g])
+	}
+	total := 0
+	for _, n := range ga {
+		total += n
+	}
+	for _, n := range gb {
+		total += n
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+func bigrams(s string) map[string]int {
+	r := []rune(s)
+	out := make(map[string]int, len(r))
+	for i := 0; i+1 < len(r); i++ {
+		out[string(r[i:i+2])]++
+	}
+	return out
+}
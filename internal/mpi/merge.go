@@ -0,0 +1,73 @@
+package mpi
+
+import (
+	"sort"
+	"time"
+
+	"agnos/internal/model"
+)
+
+// stringFields lists the fields a merge may fill, by their JSON names.
+func stringFields(p *model.Patient) map[string]**string {
+	return map[string]**string{
+		"first_name_th":  &p.FirstNameTH,
+		"middle_name_th": &p.MiddleNameTH,
+		"last_name_th":   &p.LastNameTH,
+		"first_name_en":  &p.FirstNameEN,
+		"middle_name_en": &p.MiddleNameEN,
+		"last_name_en":   &p.LastNameEN,
+		"patient_hn":     &p.PatientHN,
+		"national_id":    &p.NationalID,
+		"passport_id":    &p.PassportID,
+		"phone_number":   &p.PhoneNumber,
+		"phone_e164":     &p.PhoneE164,
+		"email":          &p.Email,
+		"gender":         &p.Gender,
+	}
+}
+
+// Fill returns survivor with every empty field taken from merged, and the
+// names of the fields it filled. Values the survivor already has always win.
+func Fill(survivor, merged model.Patient) (model.Patient, []string) {
+	out := survivor
+	filled := []string{}
+	from := stringFields(&merged)
+	for name, field := range stringFields(&out) {
+		if *field == nil && *from[name] != nil {
+			v := **from[name]
+			*field = &v
+			filled = append(filled, name)
+		}
+	}
+	if out.DateOfBirth == nil && merged.DateOfBirth != nil {
+		t := *merged.DateOfBirth
+		out.DateOfBirth = &t
+		filled = append(filled, "date_of_birth")
+	}
+	sort.Strings(filled)
+	return out, filled
+}
+
+// Unfill clears the fields a merge filled, unless they were changed since:
+// a field is only cleared while it still holds the merged patient's value.
+func Unfill(survivor, mergedBefore model.Patient, filled []string) model.Patient {
+	out := survivor
+	fields, before := stringFields(&out), stringFields(&mergedBefore)
+	for _, name := range filled {
+		if name == "date_of_birth" {
+			if sameTime(out.DateOfBirth, mergedBefore.DateOfBirth) {
+				out.DateOfBirth = nil
+			}
+			continue
+		}
+		field, ok := fields[name]
+		if ok && *field != nil && *before[name] != nil && **field == **before[name] {
+			*field = nil
+		}
+	}
+	return out
+}
+
+func sameTime(a, b *time.Time) bool {
+	return a != nil && b != nil && a.Equal(*b)
+}
@@ -0,0 +1,76 @@
+package mpi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"agnos/internal/model"
+)
+
+func str(s string) *string { return &s }
+
+func date(s string) *time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return &t
+}
+
+func TestScoreSamePersonUnderTwoIdentifiers(t *testing.T) {
+	a := model.Patient{ID: 1, FirstNameEN: str("Somchai"), LastNameEN: str("Jaidee"), DateOfBirth: date("1990-01-01"),
+		NationalID: str("1234567890121"), PhoneE164: str("+66812345678")}
+	b := model.Patient{ID: 2, FirstNameEN: str("somchai"), LastNameEN: str("Jaidee "), DateOfBirth: date("1990-01-01"),
+		PassportID: str("AA123456"), PhoneE164: str("+66812345678")}
+
+	m := Score(a, b)
+	if m.Score < CandidateThreshold {
+		t.Fatalf("expected a candidate, got %+v", m)
+	}
+	want := []string{ReasonDateOfBirth, ReasonName, ReasonPhone}
+	if !reflect.DeepEqual(m.Reasons, want) {
+		t.Fatalf("reasons = %v, want %v", m.Reasons, want)
+	}
+}
+
+func TestScoreTypoAndConflicts(t *testing.T) {
+	a := model.Patient{FirstNameEN: str("Somchai"), LastNameEN: str("Jaidee"), DateOfBirth: date("1990-01-01")}
+	b := model.Patient{FirstNameEN: str("Somchia"), LastNameEN: str("Jaidee"), DateOfBirth: date("1990-01-01")}
+	if m := Score(a, b); len(m.Reasons) != 2 || m.Reasons[1] != ReasonSimilarName {
+		t.Fatalf("expected similar name, got %+v", m)
+	}
+
+	b.DateOfBirth = date("1991-01-01")
+	if m := Score(a, b); m.Score != 0 {
+		t.Fatalf("expected a conflicting birth date to rule the pair out, got %+v", m)
+	}
+
+	a.NationalID, b.NationalID = str("1234567890121"), str("1111111111119")
+	b.FirstNameEN, b.DateOfBirth = str("Somchai"), a.DateOfBirth
+	if m := Score(a, b); m.Score != 0 || m.Reasons[0] != ReasonConflictingID {
+		t.Fatalf("expected different national IDs to never match, got %+v", m)
+	}
+}
+
+func TestFillAndUnfill(t *testing.T) {
+	survivor := model.Patient{ID: 1, FirstNameEN: str("Somchai"), NationalID: str("1234567890121")}
+	merged := model.Patient{ID: 2, FirstNameEN: str("Somchay"), PassportID: str("AA123456"), DateOfBirth: date("1990-01-01")}
+
+	out, filled := Fill(survivor, merged)
+	if *out.FirstNameEN != "Somchai" || *out.PassportID != "AA123456" || out.DateOfBirth == nil {
+		t.Fatalf("unexpected fill result %+v", out)
+	}
+	if !reflect.DeepEqual(filled, []string{"date_of_birth", "passport_id"}) {
+		t.Fatalf("filled = %v", filled)
+	}
+	if survivor.PassportID != nil {
+		t.Fatal("Fill modified its input")
+	}
+
+	out.DateOfBirth = date("1990-02-02")
+	back := Unfill(out, merged, filled)
+	if back.PassportID != nil {
+		t.Fatal("expected filled passport to be cleared")
+	}
+	if back.DateOfBirth == nil {
+		t.Fatal("expected a birth date edited after the merge to be kept")
+	}
+}
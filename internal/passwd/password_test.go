@@ -0,0 +1,91 @@
+package passwd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := Hash("correct horse", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+
+	ok, rehash, err := Verify(encoded, "correct horse", testParams)
+	if err != nil || !ok || rehash {
+		t.Fatalf("expected match without rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+	if ok, _, _ := Verify(encoded, "wrong horse", testParams); ok {
+		t.Fatal("wrong password matched")
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+	if ok, rehash, _ := Verify(encoded, "correct horse", stronger); !ok || !rehash {
+		t.Fatalf("expected rehash after parameter change, got ok=%v rehash=%v", ok, rehash)
+	}
+}
+
+func TestVerifyBcryptNeedsRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, rehash, err := Verify(string(legacy), "secret", testParams)
+	if err != nil || !ok || !rehash {
+		t.Fatalf("expected bcrypt match with rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+	if ok, _, _ := Verify(string(legacy), "other", testParams); ok {
+		t.Fatal("wrong password matched bcrypt hash")
+	}
+	if _, _, err := Verify("plain", "plain", testParams); !errors.Is(err, ErrUnknownHash) {
+		t.Fatalf("expected ErrUnknownHash, got %v", err)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	p := Policy{MinLength: 10, MinClasses: 3, Banned: map[string]bool{"password123!": true}}
+	cases := []struct {
+		password string
+		ok       bool
+	}{
+		{"Tr0ub4dor&3x", true},
+		{"short1A!", false},
+		{"alllowercaseletters", false},
+		{"Password123!", false},
+		{"xAliceSmith9!", false},
+	}
+	for _, c := range cases {
+		err := p.Validate("alicesmith", c.password)
+		if c.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", c.password, err)
+		}
+		if !c.ok && !errors.Is(err, ErrPolicy) {
+			t.Errorf("%q: expected policy error, got %v", c.password, err)
+		}
+	}
+}
+
+func TestLoadBanned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	if err := os.WriteFile(path, []byte("# common\nQwerty123\n\nletmein\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	banned, err := LoadBanned(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(banned) != 2 || !banned["qwerty123"] || !banned["letmein"] {
+		t.Fatalf("unexpected banned list %v", banned)
+	}
+}
@@ -0,0 +1,78 @@
+package passwd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var ErrPolicy = errors.New("password does not meet policy")
+
+// Policy is applied whenever a password is set. A zero Policy only rejects
+// blank passwords.
+type Policy struct {
+	MinLength  int
+	MinClasses int // of lower case, upper case, digits and symbols
+	Banned     map[string]bool
+}
+
+func (p Policy) Validate(username, password string) error {
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("%w: password is required", ErrPolicy)
+	}
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrPolicy, p.MinLength)
+	}
+	if classes(password) < p.MinClasses {
+		return fmt.Errorf("%w: must mix at least %d of lower case, upper case, digits and symbols", ErrPolicy, p.MinClasses)
+	}
+	lower := strings.ToLower(password)
+	if u := strings.ToLower(strings.TrimSpace(username)); len(u) >= 3 && strings.Contains(lower, u) {
+		return fmt.Errorf("%w: must not contain the username", ErrPolicy)
+	}
+	if p.Banned[lower] {
+		return fmt.Errorf("%w: password is too common", ErrPolicy)
+	}
+	return nil
+}
+
+func classes(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// LoadBanned reads a banned password list, one per line. Blank lines and lines
+// starting with # are skipped; matching is case-insensitive.
+func LoadBanned(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	banned := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[strings.ToLower(line)] = true
+	}
+	return banned, scanner.Err()
+}
@@ -0,0 +1,102 @@
+// Package phone normalizes phone numbers to E.164 so that the same number
+// written as "081-234-5678", "0812345678" or "+66812345678" compares equal.
+package phone
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalid       = errors.New("phone number is invalid")
+	ErrUnknownRegion = errors.New("unknown phone region")
+)
+
+// DefaultRegion is assumed for numbers written without a country code.
+const DefaultRegion = "TH"
+
+type region struct {
+	countryCode string
+	trunkPrefix string
+	// minNational and maxNational bound the length of the national
+	// significant number, i.e. without trunk prefix or country code.
+	minNational, maxNational int
+}
+
+var regions = map[string]region{
+	// Thai landlines have 8 digits after the trunk 0 (02-123-4567), mobiles 9
+	// (081-234-5678).
+	"TH": {countryCode: "66", trunkPrefix: "0", minNational: 8, maxNational: 9},
+}
+
+var (
+	extension = regexp.MustCompile(`(?i)\s*(ext\.?|x|#|ต่อ).*$`)
+	separator = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "")
+)
+
+// Normalize returns raw in E.164 form ("+66812345678"). Numbers without a
+// country code are read as national numbers of regionCode; a leading "00" is
+// taken as the international call prefix. Extensions ("ext 12", "ต่อ 12")
+// are dropped.
+func Normalize(raw, regionCode string) (string, error) {
+	reg, ok := regions[strings.ToUpper(regionCode)]
+	if !ok {
+		return "", ErrUnknownRegion
+	}
+	s := separator.Replace(extension.ReplaceAllString(strings.TrimSpace(raw), ""))
+
+	var e164 string
+	switch {
+	case strings.HasPrefix(s, "+"):
+		e164 = s
+	case strings.HasPrefix(s, "00"):
+		e164 = "+" + s[2:]
+	case reg.trunkPrefix != "" && strings.HasPrefix(s, reg.trunkPrefix):
+		national := s[len(reg.trunkPrefix):]
+		if len(national) < reg.minNational || len(national) > reg.maxNational {
+			return "", ErrInvalid
+		}
+		e164 = "+" + reg.countryCode + national
+	case strings.HasPrefix(s, reg.countryCode) &&
+		len(s)-len(reg.countryCode) >= reg.minNational && len(s)-len(reg.countryCode) <= reg.maxNational:
+		e164 = "+" + s
+	default:
+		return "", ErrInvalid
+	}
+	if !valid(e164) {
+		return "", ErrInvalid
+	}
+	return e164, nil
+}
+
+// Digits returns the digits of a partial number for substring matching
+// against E.164 values, dropping a leading trunk prefix of regionCode, which
+// E.164 numbers do not contain.
+func Digits(raw, regionCode string) string {
+	var b strings.Builder
+	for _, r := range extension.ReplaceAllString(raw, "") {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	d := b.String()
+	trimmed := strings.TrimLeft(raw, " ")
+	if reg, ok := regions[strings.ToUpper(regionCode)]; ok && reg.trunkPrefix != "" && !strings.HasPrefix(trimmed, "+") {
+		d = strings.TrimPrefix(d, reg.trunkPrefix)
+	}
+	return d
+}
+
+// valid reports whether s is "+" followed by 8 to 15 digits, the first not 0.
+func valid(s string) bool {
+	if len(s) < 9 || len(s) > 16 || s[0] != '+' || s[1] == '0' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"081-234-5678":         "+66812345678",
+		"0812345678":           "+66812345678",
+		"+66812345678":         "+66812345678",
+		"+66 81 234 5678":      "+66812345678",
+		"66812345678":          "+66812345678",
+		"0066812345678":        "+66812345678",
+		"02-123-4567":          "+6621234567",
+		"(02) 123 4567 ต่อ 12": "+6621234567",
+		"02 123 4567 ext. 9":   "+6621234567",
+		"+1 (415) 555-0100":    "+14155550100",
+	}
+	for in, want := range cases {
+		got, err := Normalize(in, DefaultRegion)
+		if err != nil || got != want {
+			t.Fatalf("Normalize(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "12345", "081234", "08123456789012", "+0812345678", "call me"} {
+		if _, err := Normalize(in, DefaultRegion); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("Normalize(%q) accepted, err=%v", in, err)
+		}
+	}
+	if _, err := Normalize("0812345678", "ZZ"); !errors.Is(err, ErrUnknownRegion) {
+		t.Fatalf("expected unknown region, got %v", err)
+	}
+}
+
+func TestDigits(t *testing.T) {
+	cases := map[string]string{
+		"081-234":  "81234",
+		"5678":     "5678",
+		"+66 812":  "66812",
+		" 0-2123 ": "2123",
+	}
+	for in, want := range cases {
+		if got := Digits(in, DefaultRegion); got != want {
+			t.Fatalf("Digits(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package rbac
+
+const (
+	RoleAdmin     = "admin"
+	RoleDoctor    = "doctor"
+	RoleNurse     = "nurse"
+	RoleRegistrar = "registrar"
+	RoleAuditor   = "auditor"
+
+	DefaultRole = RoleNurse
+)
+
+type Permission string
+
+const (
+	PermPatientRead   Permission = "patient:read"
+	PermPatientWrite  Permission = "patient:write"
+	PermPatientMerge  Permission = "patient:merge"
+	PermPatientUnmask Permission = "patient:unmask"
+	PermStaffManage   Permission = "staff:manage"
+	PermAuditRead     Permission = "audit:read"
+	PermPrivacyManage Permission = "privacy:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:     {PermPatientRead, PermPatientWrite, PermPatientMerge, PermPatientUnmask, PermStaffManage, PermAuditRead, PermPrivacyManage},
+	RoleDoctor:    {PermPatientRead, PermPatientWrite},
+	RoleNurse:     {PermPatientRead},
+	RoleRegistrar: {PermPatientRead, PermPatientWrite, PermPatientMerge, PermPatientUnmask},
+	RoleAuditor:   {PermAuditRead},
+}
+
+func Roles() []string {
+	return []string{RoleAdmin, RoleDoctor, RoleNurse, RoleRegistrar, RoleAuditor}
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RolesWith returns the roles granted perm, in Roles order.
+func RolesWith(perm Permission) []string {
+	out := make([]string, 0)
+	for _, role := range Roles() {
+		if HasPermission(role, perm) {
+			out = append(out, role)
+		}
+	}
+	return out
+}
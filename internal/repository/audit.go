@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"agnos/internal/auditchain"
+	"agnos/internal/model"
+)
+
+type postgresAuditRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresAuditRepository(db *sql.DB) AuditRepository {
+	return &postgresAuditRepository{db: db}
+}
+
+const auditColumns = `id, seq, staff_id, hospital, action, criteria, patient_ids, client_ip, request_id, created_at, prev_hash, entry_hash`
+
+// Record appends e to its hospital's chain. Appends to one hospital are
+// serialised with an advisory lock so two entries never share a predecessor.
+func (r *postgresAuditRepository) Record(e model.AuditEntry) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('audit_log:' || $1))`, e.Hospital); err != nil {
+		return err
+	}
+	e.Seq, e.PrevHash = 1, auditchain.GenesisHash
+	var lastSeq int64
+	var lastHash string
+	err = tx.QueryRow(
+		`SELECT seq, entry_hash FROM audit_log WHERE hospital = $1 AND seq IS NOT NULL ORDER BY seq DESC LIMIT 1`,
+		e.Hospital,
+	).Scan(&lastSeq, &lastHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil {
+		e.Seq, e.PrevHash = lastSeq+1, lastHash
+	}
+	if e.PatientIDs == nil {
+		e.PatientIDs = []int64{}
+	}
+	e.CreatedAt = auditchain.Timestamp(time.Now())
+	if e.Hash, err = auditchain.Hash(e.PrevHash, e); err != nil {
+		return err
+	}
+
+	ids, err := json.Marshal(e.PatientIDs)
+	if err != nil {
+		return err
+	}
+	var criteria any
+	if len(e.Criteria) > 0 {
+		criteria = string(e.Criteria)
+	}
+	var staffID any
+	if e.StaffID != 0 {
+		staffID = e.StaffID
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO audit_log (seq, staff_id, hospital, action, criteria, patient_ids, client_ip, request_id, created_at, prev_hash, entry_hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		e.Seq, staffID, e.Hospital, e.Action, criteria, string(ids), e.ClientIP, e.RequestID, e.CreatedAt, e.PrevHash, e.Hash,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *postgresAuditRepository) List(hospital string, f model.AuditFilter) ([]model.AuditEntry, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE hospital = $1`
+	args := []any{hospital}
+
+	if len(f.Actions) > 0 {
+		args = append(args, f.Actions)
+		query += fmt.Sprintf(" AND action = ANY($%d)", len(args))
+	}
+	if f.StaffID != nil {
+		args = append(args, *f.StaffID)
+		query += fmt.Sprintf(" AND staff_id = $%d", len(args))
+	}
+	if f.PatientID != nil {
+		args = append(args, *f.PatientID)
+		query += fmt.Sprintf(" AND patient_ids @> jsonb_build_array($%d::bigint)", len(args))
+	}
+	if f.From != nil {
+		args = append(args, *f.From)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if f.To != nil {
+		args = append(args, *f.To)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	return r.queryEntries(query, args...)
+}
+
+// Chain returns up to limit chained entries of a hospital after afterSeq, in
+// chain order.
+func (r *postgresAuditRepository) Chain(hospital string, afterSeq int64, limit int) ([]model.AuditEntry, error) {
+	return r.queryEntries(
+		`SELECT `+auditColumns+` FROM audit_log
+		 WHERE hospital = $1 AND seq > $2 ORDER BY seq LIMIT $3`,
+		hospital, afterSeq, limit,
+	)
+}
+
+func (r *postgresAuditRepository) queryEntries(query string, args ...any) ([]model.AuditEntry, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]model.AuditEntry, 0)
+	for rows.Next() {
+		var e model.AuditEntry
+		var seq, staffID sql.NullInt64
+		var criteria, clientIP, requestID, prevHash, hash sql.NullString
+		var ids []byte
+		if err := rows.Scan(
+			&e.ID, &seq, &staffID, &e.Hospital, &e.Action, &criteria, &ids,
+			&clientIP, &requestID, &e.CreatedAt, &prevHash, &hash,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(ids, &e.PatientIDs); err != nil {
+			return nil, err
+		}
+		if criteria.Valid {
+			e.Criteria = json.RawMessage(criteria.String)
+		}
+		e.Seq = seq.Int64
+		e.StaffID = staffID.Int64
+		e.ClientIP = clientIP.String
+		e.RequestID = requestID.String
+		e.PrevHash = prevHash.String
+		e.Hash = hash.String
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// Heads returns the latest entry of every hospital's chain as an unsigned
+// checkpoint.
+func (r *postgresAuditRepository) Heads() ([]model.AuditCheckpoint, error) {
+	rows, err := r.db.Query(
+		`SELECT DISTINCT ON (hospital) hospital, seq, entry_hash FROM audit_log
+		 WHERE seq IS NOT NULL ORDER BY hospital, seq DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]model.AuditCheckpoint, 0)
+	for rows.Next() {
+		var cp model.AuditCheckpoint
+		if err := rows.Scan(&cp.Hospital, &cp.Seq, &cp.Hash); err != nil {
+			return nil, err
+		}
+		result = append(result, cp)
+	}
+	return result, rows.Err()
+}
+
+// SaveCheckpoint stores cp unless that chain position is already
+// checkpointed; it reports whether a row was written.
+func (r *postgresAuditRepository) SaveCheckpoint(cp model.AuditCheckpoint) (bool, error) {
+	res, err := r.db.Exec(
+		`INSERT INTO audit_checkpoints (hospital, seq, entry_hash, signature) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (hospital, seq) DO NOTHING`,
+		cp.Hospital, cp.Seq, cp.Hash, cp.Signature,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *postgresAuditRepository) Checkpoints(hospital string) ([]model.AuditCheckpoint, error) {
+	rows, err := r.db.Query(
+		`SELECT id, hospital, seq, entry_hash, signature, created_at FROM audit_checkpoints
+		 WHERE hospital = $1 ORDER BY seq`,
+		hospital,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]model.AuditCheckpoint, 0)
+	for rows.Next() {
+		var cp model.AuditCheckpoint
+		if err := rows.Scan(&cp.ID, &cp.Hospital, &cp.Seq, &cp.Hash, &cp.Signature, &cp.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, cp)
+	}
+	return result, rows.Err()
+}
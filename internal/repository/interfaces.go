@@ -1,14 +1,98 @@
 package repository
 
-import "agnos/internal/model"
+import (
+	"time"
+
+	"agnos/internal/model"
+)
 
 type StaffRepository interface {
-	Create(username, passwordHash, hospital string) (model.Staff, error)
+	Create(username, passwordHash, hospital, role string) (model.Staff, error)
+	CreateIfNoAdmin(username, passwordHash, hospital string) (model.Staff, bool, error)
 	FindByUsernameAndHospital(username, hospital string) (model.Staff, error)
+	FindByID(id int64) (model.Staff, error)
+	ListByHospital(hospital string) ([]model.Staff, error)
+	SetActive(staffID int64, active bool) error
+	UpdatePassword(staffID int64, passwordHash string) error
+	UpgradePasswordHash(staffID int64, oldHash, newHash string) error
+	SetMFASecret(staffID int64, encryptedSecret string) error
+	EnableMFA(staffID int64, recoveryCodeHashes []string) error
+	ResetMFA(staffID int64) error
+	ClaimMFAStep(staffID, step int64) (bool, error)
+	UseRecoveryCode(staffID int64, codeHash string) (bool, error)
+}
+
+type SessionRepository interface {
+	Create(staffID int64, mfaVerified bool, refreshHash string, expiresAt time.Time) (model.Session, error)
+	Rotate(oldHash, newHash string, expiresAt time.Time) (model.Session, error)
+	Revoke(staffID int64, sessionID string) error
+	RevokeAllForStaff(staffID int64) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti, sessionID string, staffID int64, issuedAt time.Time) (bool, error)
+}
+
+type LoginThrottleRepository interface {
+	Get(scope, key string) (model.LoginThrottle, error)
+	RecordFailure(scope, key string, window time.Duration) (model.LoginThrottle, error)
+	Block(scope, key string, until time.Time, locked bool) error
+	Reset(scope, key string) error
+}
+
+type SecurityEventRepository interface {
+	Record(e model.SecurityEvent) error
+	ListByHospital(hospital string, limit int) ([]model.SecurityEvent, error)
 }
 
+// AuditRepository is append-only; entries are never updated or deleted.
+type AuditRepository interface {
+	Record(e model.AuditEntry) error
+	List(hospital string, f model.AuditFilter) ([]model.AuditEntry, error)
+	Chain(hospital string, afterSeq int64, limit int) ([]model.AuditEntry, error)
+	Heads() ([]model.AuditCheckpoint, error)
+	SaveCheckpoint(cp model.AuditCheckpoint) (bool, error)
+	Checkpoints(hospital string) ([]model.AuditCheckpoint, error)
+}
+
+// PatientRepository keeps every change to a patient as a version, written in
+// the same transaction as the change.
 type PatientRepository interface {
-	SearchByHospital(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error)
+	SearchByHospital(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error)
 	FindByIdentifier(hospital string, nationalID, passportID *string) (model.Patient, bool, error)
 	UpsertByNationalOrPassport(hospital string, p model.Patient) (model.Patient, error)
+	StaleSynced(hospital string, before time.Time, limit int) ([]model.Patient, error)
+	MarkSynced(hospital string, id int64) error
+	FindByID(hospital string, id int64) (model.Patient, error)
+	Create(hospital string, p model.Patient, staffID int64) (model.Patient, error)
+	Update(hospital string, p model.Patient, staffID int64) (model.Patient, error)
+	Delete(hospital string, id int64, staffID int64) (bool, error)
+	Versions(hospital string, id int64) ([]model.PatientVersion, error)
+	Restore(hospital string, id int64, version int, staffID int64) (model.Patient, error)
+}
+
+// MPIRepository holds the duplicate review queue and performs merges. Merges
+// are never deleted; a reverted merge keeps its row with reverted_at set.
+type MPIRepository interface {
+	Candidates(hospital string, p model.Patient, limit int) ([]model.Patient, error)
+	QueueDuplicate(d model.DuplicateCandidate) error
+	ListDuplicates(hospital string, limit int) ([]model.DuplicateCandidate, error)
+	DismissDuplicate(hospital string, id, staffID int64) (bool, error)
+	Merge(hospital string, survivorID, mergedID, staffID int64, reason string) (model.PatientMerge, error)
+	Unmerge(hospital string, mergeID, staffID int64) (model.PatientMerge, error)
+	Merges(hospital string, patientID int64) ([]model.PatientMerge, error)
+}
+
+// PrivacyRepository tracks PDPA data subject requests and legal holds.
+// Completing an erasure anonymizes the patient in the same transaction.
+type PrivacyRepository interface {
+	CreateRequest(req model.PrivacyRequest) (model.PrivacyRequest, error)
+	ListRequests(hospital, status string) ([]model.PrivacyRequest, error)
+	FindRequest(hospital string, id int64) (model.PrivacyRequest, error)
+	RejectRequest(hospital string, id, staffID int64, reason string) (model.PrivacyRequest, error)
+	CompleteExport(hospital string, id, staffID int64) (model.PrivacyRequest, error)
+	CompleteErasure(hospital string, id, staffID int64) (model.PrivacyRequest, []int64, error)
+	MergedRecords(hospital string, id int64) ([]model.Patient, error)
+	PlaceHold(h model.LegalHold) (model.LegalHold, error)
+	FindHold(hospital string, id int64) (model.LegalHold, error)
+	ReleaseHold(hospital string, id, staffID int64) (model.LegalHold, error)
+	Holds(hospital string, patientID int64) ([]model.LegalHold, error)
 }
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"agnos/internal/model"
+)
+
+type postgresLoginThrottleRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresLoginThrottleRepository(db *sql.DB) LoginThrottleRepository {
+	return &postgresLoginThrottleRepository{db: db}
+}
+
+func (r *postgresLoginThrottleRepository) Get(scope, key string) (model.LoginThrottle, error) {
+	t := model.LoginThrottle{Scope: scope, Key: key}
+	var blockedUntil sql.NullTime
+	err := r.db.QueryRow(
+		`SELECT failures, blocked_until, locked FROM login_throttles WHERE scope = $1 AND key = $2`,
+		scope, key,
+	).Scan(&t.Failures, &blockedUntil, &t.Locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, nil
+	}
+	if err != nil {
+		return model.LoginThrottle{}, err
+	}
+	if blockedUntil.Valid {
+		t.BlockedUntil = &blockedUntil.Time
+	}
+	return t, nil
+}
+
+// RecordFailure counts a failed attempt. Failures older than window no longer
+// count, so the counter restarts at one after a quiet period.
+func (r *postgresLoginThrottleRepository) RecordFailure(scope, key string, window time.Duration) (model.LoginThrottle, error) {
+	t := model.LoginThrottle{Scope: scope, Key: key}
+	err := r.db.QueryRow(
+		`INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+		 VALUES ($1, $2, 1, now())
+		 ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < now() - make_interval(secs => $3) THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = now(),
+			locked = login_throttles.locked AND login_throttles.blocked_until > now()
+		 RETURNING failures`,
+		scope, key, window.Seconds(),
+	).Scan(&t.Failures)
+	return t, err
+}
+
+func (r *postgresLoginThrottleRepository) Block(scope, key string, until time.Time, locked bool) error {
+	_, err := r.db.Exec(
+		`UPDATE login_throttles SET blocked_until = $3, locked = $4 WHERE scope = $1 AND key = $2`,
+		scope, key, until, locked,
+	)
+	return err
+}
+
+func (r *postgresLoginThrottleRepository) Reset(scope, key string) error {
+	_, err := r.db.Exec(`DELETE FROM login_throttles WHERE scope = $1 AND key = $2`, scope, key)
+	return err
+}
+
+type postgresSecurityEventRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresSecurityEventRepository(db *sql.DB) SecurityEventRepository {
+	return &postgresSecurityEventRepository{db: db}
+}
+
+func (r *postgresSecurityEventRepository) Record(e model.SecurityEvent) error {
+	_, err := r.db.Exec(
+		`INSERT INTO security_events (hospital, event_type, staff_id, username, client_ip, actor_staff_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		e.Hospital, e.Type, e.StaffID, e.Username, e.ClientIP, e.ActorStaffID,
+	)
+	return err
+}
+
+func (r *postgresSecurityEventRepository) ListByHospital(hospital string, limit int) ([]model.SecurityEvent, error) {
+	rows, err := r.db.Query(
+		`SELECT id, hospital, event_type, staff_id, username, client_ip, actor_staff_id, created_at
+		 FROM security_events WHERE hospital = $1
+		 ORDER BY created_at DESC, id DESC LIMIT $2`,
+		hospital, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]model.SecurityEvent, 0)
+	for rows.Next() {
+		var e model.SecurityEvent
+		var staffID, actorID sql.NullInt64
+		var username, clientIP sql.NullString
+		if err := rows.Scan(&e.ID, &e.Hospital, &e.Type, &staffID, &username, &clientIP, &actorID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if staffID.Valid {
+			e.StaffID = &staffID.Int64
+		}
+		if actorID.Valid {
+			e.ActorStaffID = &actorID.Int64
+		}
+		e.Username = username.String
+		e.ClientIP = clientIP.String
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,319 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"agnos/internal/model"
+	"agnos/internal/mpi"
+)
+
+var ErrMergeConflict = errors.New("patients are not in a state that allows this merge")
+
+type postgresMPIRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresMPIRepository(db *sql.DB) MPIRepository {
+	return &postgresMPIRepository{db: db}
+}
+
+// Candidates returns live patients sharing an identifier, phone or email with
+// p, or born the same day with the same last name. They are only worth
+// scoring; most will not be duplicates.
+func (r *postgresMPIRepository) Candidates(hospital string, p model.Patient, limit int) ([]model.Patient, error) {
+	rows, err := r.db.Query(`
+		SELECT `+patientColumns+` FROM patients
+		WHERE hospital = $1 AND id <> $2 AND merged_into IS NULL AND erased_at IS NULL AND deleted_at IS NULL AND (
+			national_id = $3 OR passport_id = $4 OR phone_e164 = $5 OR lower(email) = lower($6)
+			OR (date_of_birth = $7 AND (lower(last_name_en) = lower($8) OR last_name_th = $9))
+		)
+		ORDER BY id
+		LIMIT $10`,
+		hospital, p.ID, p.NationalID, p.PassportID, p.PhoneE164, p.Email,
+		dateArg(p.DateOfBirth), p.LastNameEN, p.LastNameTH, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.Patient, 0)
+	for rows.Next() {
+		c, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// QueueDuplicate adds the pair to the review queue, or refreshes its score
+// while it is still pending. Reviewed pairs are not reopened.
+func (r *postgresMPIRepository) QueueDuplicate(d model.DuplicateCandidate) error {
+	if d.PatientID > d.DuplicateID {
+		d.PatientID, d.DuplicateID = d.DuplicateID, d.PatientID
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO patient_duplicates (hospital, patient_id, duplicate_id, score, reasons)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (hospital, patient_id, duplicate_id) DO UPDATE
+		SET score = EXCLUDED.score, reasons = EXCLUDED.reasons
+		WHERE patient_duplicates.status = 'pending'`,
+		d.Hospital, d.PatientID, d.DuplicateID, d.Score, d.Reasons,
+	)
+	return err
+}
+
+// ListDuplicates returns pending pairs whose patients are both still live,
+// highest score first.
+func (r *postgresMPIRepository) ListDuplicates(hospital string, limit int) ([]model.DuplicateCandidate, error) {
+	rows, err := r.db.Query(`
+		SELECT d.id, d.hospital, d.patient_id, d.duplicate_id, d.score, array_to_json(d.reasons), d.status,
+			d.created_at, d.reviewed_by, d.reviewed_at
+		FROM patient_duplicates d
+		JOIN patients a ON a.id = d.patient_id AND a.merged_into IS NULL AND a.deleted_at IS NULL
+		JOIN patients b ON b.id = d.duplicate_id AND b.merged_into IS NULL AND b.deleted_at IS NULL
+		WHERE d.hospital = $1 AND d.status = 'pending'
+		ORDER BY d.score DESC, d.id
+		LIMIT $2`,
+		hospital, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.DuplicateCandidate, 0)
+	for rows.Next() {
+		var d model.DuplicateCandidate
+		var reasons []byte
+		if err := rows.Scan(&d.ID, &d.Hospital, &d.PatientID, &d.DuplicateID, &d.Score, &reasons, &d.Status,
+			&d.CreatedAt, &d.ReviewedBy, &d.ReviewedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(reasons, &d.Reasons); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresMPIRepository) DismissDuplicate(hospital string, id, staffID int64) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE patient_duplicates SET status = 'dismissed', reviewed_by = $3, reviewed_at = now()
+		WHERE hospital = $1 AND id = $2 AND status = 'pending'`,
+		hospital, id, staffID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Merge folds mergedID into survivorID in one transaction: empty survivor
+// fields are filled from the merged patient, whose identifiers move to the
+// survivor, and the merged patient becomes a tombstone redirecting to the
+// survivor. It returns sql.ErrNoRows if either patient does not exist and
+// ErrMergeConflict if either has already been merged or was erased.
+func (r *postgresMPIRepository) Merge(hospital string, survivorID, mergedID, staffID int64, reason string) (model.PatientMerge, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	defer tx.Rollback()
+
+	locked, err := lockPatients(tx, hospital, survivorID, mergedID)
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	survivor, merged := locked[survivorID], locked[mergedID]
+	if survivor.MergedInto != nil || merged.MergedInto != nil || survivor.ErasedAt != nil || merged.ErasedAt != nil ||
+		survivor.DeletedAt != nil || merged.DeletedAt != nil {
+		return model.PatientMerge{}, ErrMergeConflict
+	}
+
+	filled, fields := mpi.Fill(survivor, merged)
+	tombstone, err := scanPatient(tx.QueryRow(`
+		UPDATE patients SET national_id = NULL, passport_id = NULL, merged_into = $3, merged_at = now(), updated_at = now()
+		WHERE hospital = $1 AND id = $2
+		RETURNING `+patientColumns,
+		hospital, mergedID, survivorID,
+	))
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := recordVersion(tx, hospital, &merged, tombstone, model.PatientVersionMerge, &staffID, nil); err != nil {
+		return model.PatientMerge{}, err
+	}
+	stored, err := updatePatient(tx, hospital, filled)
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := recordVersion(tx, hospital, &survivor, stored, model.PatientVersionMerge, &staffID, nil); err != nil {
+		return model.PatientMerge{}, err
+	}
+
+	before, err := json.Marshal(merged)
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	m := model.PatientMerge{
+		Hospital: hospital, SurvivorID: survivorID, MergedID: mergedID, StaffID: staffID,
+		Reason: reason, FilledFields: fields, MergedBefore: merged,
+	}
+	if err := tx.QueryRow(`
+		INSERT INTO patient_merges (hospital, survivor_id, merged_id, staff_id, reason, filled_fields, merged_before)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+		RETURNING id, created_at`,
+		hospital, survivorID, mergedID, staffID, reason, fields, string(before),
+	).Scan(&m.ID, &m.CreatedAt); err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := reviewPair(tx, hospital, survivorID, mergedID, staffID, model.DuplicateMerged); err != nil {
+		return model.PatientMerge{}, err
+	}
+	return m, tx.Commit()
+}
+
+// Unmerge reverts merge mergeID: the tombstone gets its stored record back
+// and the survivor loses the fields the merge filled, unless they were
+// edited since. The pair is then marked as reviewed and not a duplicate.
+func (r *postgresMPIRepository) Unmerge(hospital string, mergeID, staffID int64) (model.PatientMerge, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanMerge(tx.QueryRow(`SELECT `+mergeColumns+` FROM patient_merges WHERE hospital = $1 AND id = $2 FOR UPDATE`, hospital, mergeID))
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	if m.RevertedAt != nil {
+		return model.PatientMerge{}, ErrMergeConflict
+	}
+	locked, err := lockPatients(tx, hospital, m.SurvivorID, m.MergedID)
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	survivor, tombstone := locked[m.SurvivorID], locked[m.MergedID]
+	// A survivor that was merged again must be unmerged first.
+	if survivor.MergedInto != nil || tombstone.MergedInto == nil || *tombstone.MergedInto != m.SurvivorID {
+		return model.PatientMerge{}, ErrMergeConflict
+	}
+
+	unfilled, err := updatePatient(tx, hospital, mpi.Unfill(survivor, m.MergedBefore, m.FilledFields))
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := recordVersion(tx, hospital, &survivor, unfilled, model.PatientVersionUnmerge, &staffID, nil); err != nil {
+		return model.PatientMerge{}, err
+	}
+	if _, err := tx.Exec(`UPDATE patients SET merged_into = NULL, merged_at = NULL WHERE hospital = $1 AND id = $2`, hospital, m.MergedID); err != nil {
+		return model.PatientMerge{}, err
+	}
+	restored := m.MergedBefore
+	restored.ID = m.MergedID
+	if restored, err = updatePatient(tx, hospital, restored); err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := recordVersion(tx, hospital, &tombstone, restored, model.PatientVersionUnmerge, &staffID, nil); err != nil {
+		return model.PatientMerge{}, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`UPDATE patient_merges SET reverted_by = $3, reverted_at = $4 WHERE hospital = $1 AND id = $2`,
+		hospital, mergeID, staffID, now); err != nil {
+		return model.PatientMerge{}, err
+	}
+	m.RevertedBy, m.RevertedAt = &staffID, &now
+	if err := reviewPair(tx, hospital, m.SurvivorID, m.MergedID, staffID, model.DuplicateDismissed); err != nil {
+		return model.PatientMerge{}, err
+	}
+	return m, tx.Commit()
+}
+
+// Merges lists the merges in which patientID was the survivor or was merged,
+// newest first.
+func (r *postgresMPIRepository) Merges(hospital string, patientID int64) ([]model.PatientMerge, error) {
+	rows, err := r.db.Query(`SELECT `+mergeColumns+` FROM patient_merges
+		WHERE hospital = $1 AND (survivor_id = $2 OR merged_id = $2)
+		ORDER BY id DESC`, hospital, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.PatientMerge, 0)
+	for rows.Next() {
+		m, err := scanMerge(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// lockPatients locks both patients in ID order, so concurrent merges of the
+// same pair cannot deadlock.
+func lockPatients(tx *sql.Tx, hospital string, a, b int64) (map[int64]model.Patient, error) {
+	if a == b {
+		return nil, ErrMergeConflict
+	}
+	rows, err := tx.Query(`SELECT `+patientColumns+` FROM patients WHERE hospital = $1 AND id IN ($2, $3) ORDER BY id FOR UPDATE`, hospital, a, b)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]model.Patient, 2)
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) != 2 {
+		return nil, sql.ErrNoRows
+	}
+	return out, nil
+}
+
+func reviewPair(tx *sql.Tx, hospital string, a, b, staffID int64, status string) error {
+	_, err := tx.Exec(`
+		UPDATE patient_duplicates SET status = $4, reviewed_by = $5, reviewed_at = now()
+		WHERE hospital = $1 AND patient_id = LEAST($2::bigint, $3::bigint) AND duplicate_id = GREATEST($2::bigint, $3::bigint)`,
+		hospital, a, b, status, staffID,
+	)
+	return err
+}
+
+const mergeColumns = `id, hospital, survivor_id, merged_id, staff_id, COALESCE(reason, ''), array_to_json(filled_fields), merged_before,
+	created_at, reverted_by, reverted_at`
+
+func scanMerge(s rowScanner) (model.PatientMerge, error) {
+	var m model.PatientMerge
+	var fields, before []byte
+	if err := s.Scan(&m.ID, &m.Hospital, &m.SurvivorID, &m.MergedID, &m.StaffID, &m.Reason, &fields, &before,
+		&m.CreatedAt, &m.RevertedBy, &m.RevertedAt); err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := json.Unmarshal(fields, &m.FilledFields); err != nil {
+		return model.PatientMerge{}, err
+	}
+	if err := json.Unmarshal(before, &m.MergedBefore); err != nil {
+		return model.PatientMerge{}, err
+	}
+	return m, nil
+}
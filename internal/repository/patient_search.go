@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"agnos/internal/model"
+	"agnos/internal/phone"
+)
+
+var ErrInvalidPage = errors.New("invalid sort, limit or cursor")
+
+const (
+	defaultPatientPageSize = 100
+	maxPatientPageSize     = 500
+)
+
+// patientSort is a keyset ordering: rows are ordered by (expr, id) in one
+// direction, so a page can resume strictly after the last row of the previous
+// one. Rows inserted meanwhile never shift a page that was already served.
+type patientSort struct {
+	expr string
+	cast string
+	desc bool
+}
+
+var patientSorts = map[string]patientSort{
+	model.PatientSortID:        {expr: "id", cast: "bigint", desc: true},
+	model.PatientSortUpdatedAt: {expr: "updated_at", cast: "timestamptz", desc: true},
+	model.PatientSortName:      {expr: "lower(COALESCE(last_name_en, last_name_th, ''))", cast: "text"},
+}
+
+// patientFilter is the WHERE clause of a search. score is the relevance of a
+// row to the name criteria, empty when none were given.
+type patientFilter struct {
+	where string
+	args  []any
+	score string
+}
+
+type patientCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+}
+
+func (r *postgresPatientRepository) SearchByHospital(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+	f := newPatientFilter(hospital, c)
+	sortName := c.Sort
+	if sortName == "" {
+		sortName = model.PatientSortID
+		if f.score != "" {
+			sortName = model.PatientSortRelevance
+		}
+	}
+	sort, ok := patientSorts[sortName]
+	if sortName == model.PatientSortRelevance {
+		sort, ok = patientSorts[model.PatientSortID], true
+		if f.score != "" {
+			sort = patientSort{expr: "(" + f.score + ")", cast: "real", desc: true}
+		}
+	}
+	if !ok || c.Limit < 0 {
+		return model.PatientPage{}, ErrInvalidPage
+	}
+	limit := c.Limit
+	if limit == 0 {
+		limit = defaultPatientPageSize
+	}
+	if limit > maxPatientPageSize {
+		limit = maxPatientPageSize
+	}
+
+	where, filterArgs := f.where, f.args
+	args := append([]any{}, filterArgs...)
+	query := `SELECT ` + patientColumns + `, ` + sort.expr + `::text FROM patients WHERE ` + where
+
+	dir, op := "ASC", ">"
+	if sort.desc {
+		dir, op = "DESC", "<"
+	}
+	if c.Cursor != "" {
+		cur, err := decodePatientCursor(c.Cursor)
+		if err != nil || cur.Sort != sortName {
+			return model.PatientPage{}, ErrInvalidPage
+		}
+		args = append(args, cur.Key, cur.ID)
+		query += fmt.Sprintf(" AND (%s, id) %s ($%d::%s, $%d)", sort.expr, op, len(args)-1, sort.cast, len(args))
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", sort.expr, dir, dir, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return model.PatientPage{}, err
+	}
+	defer rows.Close()
+
+	page := model.PatientPage{Patients: make([]model.Patient, 0)}
+	var lastKey string
+	for rows.Next() {
+		var key string
+		p, err := scanPatient(rows, &key)
+		if err != nil {
+			return model.PatientPage{}, err
+		}
+		if len(page.Patients) == limit {
+			last := page.Patients[limit-1]
+			page.NextCursor = encodePatientCursor(patientCursor{Sort: sortName, Key: lastKey, ID: last.ID})
+			break
+		}
+		page.Patients = append(page.Patients, p)
+		lastKey = key
+	}
+	if err := rows.Err(); err != nil {
+		return model.PatientPage{}, err
+	}
+	rows.Close()
+
+	switch c.Total {
+	case "":
+	case model.TotalExact:
+		var total int64
+		if err := r.db.QueryRow(`SELECT count(*) FROM patients WHERE `+where, filterArgs...).Scan(&total); err != nil {
+			return model.PatientPage{}, err
+		}
+		page.Total = &total
+	case model.TotalEstimate:
+		total, err := r.estimateRows(`SELECT 1 FROM patients WHERE `+where, filterArgs...)
+		if err != nil {
+			return model.PatientPage{}, err
+		}
+		page.Total, page.TotalEstimated = &total, true
+	default:
+		return model.PatientPage{}, ErrInvalidPage
+	}
+	return page, nil
+}
+
+// estimateRows returns the planner's row estimate for query, which is cheap
+// even when an exact count would scan most of the table.
+func (r *postgresPatientRepository) estimateRows(query string, args ...any) (int64, error) {
+	var raw []byte
+	if err := r.db.QueryRow(`EXPLAIN (FORMAT JSON) `+query, args...).Scan(&raw); err != nil {
+		return 0, err
+	}
+	var plan []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plan); err != nil || len(plan) == 0 {
+		return 0, fmt.Errorf("parse query plan: %w", err)
+	}
+	return int64(plan[0].Plan.Rows), nil
+}
+
+// newPatientFilter builds the search predicate. Name criteria match a
+// substring or, to tolerate typos, any word similar to the term (pg_trgm
+// "<%"); phone numbers match on their E.164 form and email on substrings.
+// These are served by the trigram indexes of 009_patient_trgm.sql and
+// 011_patient_phone_e164.sql.
+func newPatientFilter(hospital string, c model.PatientSearchCriteria) patientFilter {
+	f := patientFilter{where: `hospital = $1 AND merged_into IS NULL AND erased_at IS NULL AND deleted_at IS NULL`, args: []any{hospital}}
+	var scores []string
+
+	appendName := func(en, th string, value *string) {
+		if value == nil || strings.TrimSpace(*value) == "" {
+			return
+		}
+		term := strings.TrimSpace(*value)
+		f.args = append(f.args, "%"+term+"%", term)
+		like, word := len(f.args)-1, len(f.args)
+		f.where += fmt.Sprintf(" AND (%[3]s ILIKE $%[1]d OR %[4]s ILIKE $%[1]d OR $%[2]d <%% %[3]s OR $%[2]d <%% %[4]s)", like, word, en, th)
+		scores = append(scores, fmt.Sprintf("COALESCE(GREATEST(word_similarity($%[1]d, %[2]s), word_similarity($%[1]d, %[3]s)), 0)", word, en, th))
+	}
+	appendLike := func(field string, value *string) {
+		if value == nil || strings.TrimSpace(*value) == "" {
+			return
+		}
+		f.args = append(f.args, "%"+strings.TrimSpace(*value)+"%")
+		f.where += fmt.Sprintf(" AND %s ILIKE $%d", field, len(f.args))
+	}
+	appendEq := func(field string, value *string) {
+		if value == nil || strings.TrimSpace(*value) == "" {
+			return
+		}
+		f.args = append(f.args, strings.TrimSpace(*value))
+		f.where += fmt.Sprintf(" AND %s = $%d", field, len(f.args))
+	}
+
+	appendEq("national_id", c.NationalID)
+	appendEq("passport_id", c.PassportID)
+	appendName("first_name_en", "first_name_th", c.FirstName)
+	appendName("middle_name_en", "middle_name_th", c.MiddleName)
+	appendName("last_name_en", "last_name_th", c.LastName)
+	if c.PhoneNumber != nil && strings.TrimSpace(*c.PhoneNumber) != "" {
+		// A complete number matches its E.164 form exactly; a fragment is
+		// matched as digits anywhere in the E.164 form.
+		if e164, err := phone.Normalize(*c.PhoneNumber, phone.DefaultRegion); err == nil {
+			appendEq("phone_e164", &e164)
+		} else if digits := phone.Digits(*c.PhoneNumber, phone.DefaultRegion); digits != "" {
+			appendLike("phone_e164", &digits)
+		} else {
+			appendLike("phone_number", c.PhoneNumber)
+		}
+	}
+	appendLike("email", c.Email)
+	appendEq("date_of_birth", c.DateOfBirth)
+
+	f.score = strings.Join(scores, " + ")
+	return f
+}
+
+func encodePatientCursor(c patientCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodePatientCursor(s string) (patientCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return patientCursor{}, err
+	}
+	var c patientCursor
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
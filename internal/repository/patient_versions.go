@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"agnos/internal/model"
+)
+
+// recordVersion stores after as the next version of the patient if it differs
+// from before; before is nil for a new patient. A nil staffID means the change
+// came from the HIS.
+func recordVersion(tx *sql.Tx, hospital string, before *model.Patient, after model.Patient, action string, staffID *int64, restoredFrom *int) error {
+	fields, err := changedFields(before, after)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	record, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	source := model.PatientSourceStaff
+	if staffID == nil {
+		source = model.PatientSourceHIS
+	}
+	_, err = tx.Exec(`
+		INSERT INTO patient_versions (hospital, patient_id, version, action, source, staff_id, restored_from, fields, record)
+		SELECT $1::varchar, $2::bigint, COALESCE(MAX(version), 0) + 1, $3::varchar, $4::varchar, $5::bigint, $6::int, $7::text[], $8::jsonb
+		FROM patient_versions WHERE hospital = $1 AND patient_id = $2`,
+		hospital, after.ID, action, source, staffID, restoredFrom, fields, string(record),
+	)
+	return err
+}
+
+// changedFields returns the JSON names of the fields that differ between
+// before and after, sorted.
+func changedFields(before *model.Patient, after model.Patient) ([]string, error) {
+	old := map[string]any{}
+	if before != nil {
+		var err error
+		if old, err = patientFields(*before); err != nil {
+			return nil, err
+		}
+	}
+	cur, err := patientFields(after)
+	if err != nil {
+		return nil, err
+	}
+	fields := []string{}
+	for name, v := range cur {
+		if !reflect.DeepEqual(old[name], v) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// patientFields returns the set fields of p by JSON name, without id and
+// hospital, which never change, and the sync metadata, which is not part of
+// the record.
+func patientFields(p model.Patient) (map[string]any, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	delete(out, "id")
+	delete(out, "hospital")
+	delete(out, "last_synced_at")
+	delete(out, "source")
+	return out, nil
+}
+
+// Versions lists the versions of a patient, oldest first.
+func (r *postgresPatientRepository) Versions(hospital string, id int64) ([]model.PatientVersion, error) {
+	rows, err := r.db.Query(`SELECT `+versionColumns+` FROM patient_versions
+		WHERE hospital = $1 AND patient_id = $2
+		ORDER BY version`, hospital, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.PatientVersion, 0)
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// Restore writes the record of an earlier version back to the patient and
+// stores the result as a new version. The patient's ID, hospital and merge
+// state are kept, and a deleted patient is undeleted. It returns
+// sql.ErrNoRows if the patient or the version does not exist, and
+// ErrPatientConflict if another live patient holds its identifiers.
+func (r *postgresPatientRepository) Restore(hospital string, id int64, version int, staffID int64) (model.Patient, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Patient{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := lockPatient(tx, hospital, id)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	v, err := scanVersion(tx.QueryRow(`SELECT `+versionColumns+` FROM patient_versions
+		WHERE hospital = $1 AND patient_id = $2 AND version = $3`, hospital, id, version))
+	if err != nil {
+		return model.Patient{}, err
+	}
+	restored := v.Record
+	restored.ID, restored.Hospital, restored.MergedInto = current.ID, current.Hospital, current.MergedInto
+	if current.DeletedAt != nil {
+		// Fails if the identifiers were taken by a new patient meanwhile.
+		if _, err := tx.Exec(`UPDATE patients SET deleted_at = NULL WHERE hospital = $1 AND id = $2`, hospital, id); err != nil {
+			return model.Patient{}, patientWriteError(err)
+		}
+	}
+	stored, err := updatePatient(tx, hospital, restored)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	if err := recordVersion(tx, hospital, &current, stored, model.PatientVersionRestore, &staffID, &version); err != nil {
+		return model.Patient{}, err
+	}
+	return stored, tx.Commit()
+}
+
+func lockPatient(tx *sql.Tx, hospital string, id int64) (model.Patient, error) {
+	return scanPatient(tx.QueryRow(`SELECT `+patientColumns+` FROM patients WHERE hospital = $1 AND id = $2 FOR UPDATE`, hospital, id))
+}
+
+const versionColumns = `patient_id, version, action, source, staff_id, restored_from, created_at, array_to_json(fields), record`
+
+func scanVersion(s rowScanner) (model.PatientVersion, error) {
+	var v model.PatientVersion
+	var fields, record []byte
+	if err := s.Scan(&v.PatientID, &v.Version, &v.Action, &v.Source, &v.StaffID, &v.RestoredFrom, &v.CreatedAt, &fields, &record); err != nil {
+		return model.PatientVersion{}, err
+	}
+	if err := json.Unmarshal(fields, &v.Fields); err != nil {
+		return model.PatientVersion{}, err
+	}
+	if err := json.Unmarshal(record, &v.Record); err != nil {
+		return model.PatientVersion{}, err
+	}
+	return v, nil
+}
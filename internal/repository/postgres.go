@@ -8,6 +8,13 @@ import (
 	"time"
 
 	"agnos/internal/model"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrPatientConflict = errors.New("patient identifier already exists")
+	ErrStaffConflict   = errors.New("staff username already exists")
 )
 
 type postgresStaffRepository struct {
@@ -26,97 +33,191 @@ func NewPostgresPatientRepository(db *sql.DB) PatientRepository {
 	return &postgresPatientRepository{db: db}
 }
 
-func (r *postgresStaffRepository) Create(username, passwordHash, hospital string) (model.Staff, error) {
-	var s model.Staff
-	err := r.db.QueryRow(
-		`INSERT INTO staffs (username, password_hash, hospital) VALUES ($1, $2, $3)
-		 RETURNING id, username, password_hash, hospital`,
+const staffColumns = `id, username, password_hash, hospital, role, mfa_enabled, mfa_secret, active`
+
+func (r *postgresStaffRepository) Create(username, passwordHash, hospital, role string) (model.Staff, error) {
+	row := r.db.QueryRow(
+		`INSERT INTO staffs (username, password_hash, hospital, role) VALUES ($1, $2, $3, $4)
+		 RETURNING `+staffColumns,
+		username, passwordHash, hospital, role,
+	)
+	s, err := scanStaff(row)
+	if err != nil {
+		return model.Staff{}, staffWriteError(err)
+	}
+	return s, nil
+}
+
+// CreateIfNoAdmin creates an admin unless the hospital already has an active
+// one. Concurrent calls for the same hospital are serialised with an advisory
+// lock, so at most one of them creates an admin.
+func (r *postgresStaffRepository) CreateIfNoAdmin(username, passwordHash, hospital string) (model.Staff, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Staff{}, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('staff_bootstrap:' || $1))`, hospital); err != nil {
+		return model.Staff{}, false, err
+	}
+	row := tx.QueryRow(
+		`INSERT INTO staffs (username, password_hash, hospital, role)
+		 SELECT $1, $2, $3, 'admin'
+		 WHERE NOT EXISTS (SELECT 1 FROM staffs WHERE hospital = $3 AND role = 'admin' AND active)
+		 RETURNING `+staffColumns,
 		username, passwordHash, hospital,
-	).Scan(&s.ID, &s.Username, &s.PasswordHash, &s.Hospital)
-	return s, err
+	)
+	s, err := scanStaff(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Staff{}, false, nil
+	}
+	if err != nil {
+		return model.Staff{}, false, staffWriteError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Staff{}, false, err
+	}
+	return s, true, nil
 }
 
 func (r *postgresStaffRepository) FindByUsernameAndHospital(username, hospital string) (model.Staff, error) {
-	var s model.Staff
-	err := r.db.QueryRow(
-		`SELECT id, username, password_hash, hospital FROM staffs WHERE username = $1 AND hospital = $2`,
+	return scanStaff(r.db.QueryRow(
+		`SELECT `+staffColumns+` FROM staffs WHERE username = $1 AND hospital = $2`,
 		username, hospital,
-	).Scan(&s.ID, &s.Username, &s.PasswordHash, &s.Hospital)
-	return s, err
+	))
 }
 
-func (r *postgresPatientRepository) SearchByHospital(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-	base := `SELECT id, hospital, first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en,
-		last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender
-		FROM patients WHERE hospital = $1`
-	args := []any{hospital}
-	idx := 2
+func (r *postgresStaffRepository) FindByID(id int64) (model.Staff, error) {
+	return scanStaff(r.db.QueryRow(`SELECT `+staffColumns+` FROM staffs WHERE id = $1`, id))
+}
 
-	appendLike := func(cond string, value *string, placeholderCount int) {
-		if value == nil || strings.TrimSpace(*value) == "" {
-			return
-		}
-		placeholders := make([]any, placeholderCount)
-		for i := range placeholders {
-			placeholders[i] = idx + i
-		}
-		base += fmt.Sprintf(" AND %s", fmt.Sprintf(cond, placeholders...))
-		for i := 0; i < placeholderCount; i++ {
-			args = append(args, "%"+strings.TrimSpace(*value)+"%")
-		}
-		idx += placeholderCount
+func (r *postgresStaffRepository) ListByHospital(hospital string) ([]model.Staff, error) {
+	rows, err := r.db.Query(`SELECT `+staffColumns+` FROM staffs WHERE hospital = $1 ORDER BY username`, hospital)
+	if err != nil {
+		return nil, err
 	}
-	appendEq := func(field string, value *string) {
-		if value == nil || strings.TrimSpace(*value) == "" {
-			return
+	defer rows.Close()
+
+	result := make([]model.Staff, 0)
+	for rows.Next() {
+		s, err := scanStaff(rows)
+		if err != nil {
+			return nil, err
 		}
-		base += fmt.Sprintf(" AND %s = $%d", field, idx)
-		args = append(args, strings.TrimSpace(*value))
-		idx++
+		result = append(result, s)
 	}
+	return result, rows.Err()
+}
+
+func (r *postgresStaffRepository) SetActive(staffID int64, active bool) error {
+	_, err := r.db.Exec(
+		`UPDATE staffs SET active = $2, deactivated_at = CASE WHEN $2 THEN NULL ELSE now() END WHERE id = $1`,
+		staffID, active,
+	)
+	return err
+}
 
-	appendEq("national_id", c.NationalID)
-	appendEq("passport_id", c.PassportID)
-	appendLike("(first_name_en ILIKE $%d OR first_name_th ILIKE $%d)", c.FirstName, 2)
-	appendLike("(middle_name_en ILIKE $%d OR middle_name_th ILIKE $%d)", c.MiddleName, 2)
-	appendLike("(last_name_en ILIKE $%d OR last_name_th ILIKE $%d)", c.LastName, 2)
-	appendLike("phone_number ILIKE $%d", c.PhoneNumber, 1)
-	appendLike("email ILIKE $%d", c.Email, 1)
+func (r *postgresStaffRepository) UpdatePassword(staffID int64, passwordHash string) error {
+	_, err := r.db.Exec(
+		`UPDATE staffs SET password_hash = $2, password_changed_at = now() WHERE id = $1`,
+		staffID, passwordHash,
+	)
+	return err
+}
 
-	if c.DateOfBirth != nil && strings.TrimSpace(*c.DateOfBirth) != "" {
-		base += fmt.Sprintf(" AND date_of_birth = $%d", idx)
-		args = append(args, strings.TrimSpace(*c.DateOfBirth))
-	}
+// UpgradePasswordHash swaps in a rehash of the same password. It only applies
+// if the stored hash is unchanged, so it never undoes a concurrent password
+// change.
+func (r *postgresStaffRepository) UpgradePasswordHash(staffID int64, oldHash, newHash string) error {
+	_, err := r.db.Exec(
+		`UPDATE staffs SET password_hash = $3 WHERE id = $1 AND password_hash = $2`,
+		staffID, oldHash, newHash,
+	)
+	return err
+}
 
-	base += ` ORDER BY id DESC LIMIT 100`
+func (r *postgresStaffRepository) SetMFASecret(staffID int64, encryptedSecret string) error {
+	_, err := r.db.Exec(
+		`UPDATE staffs SET mfa_secret = $2, mfa_last_step = NULL WHERE id = $1 AND NOT mfa_enabled`,
+		staffID, encryptedSecret,
+	)
+	return err
+}
 
-	rows, err := r.db.Query(base, args...)
+func (r *postgresStaffRepository) EnableMFA(staffID int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	result := make([]model.Patient, 0)
-	for rows.Next() {
-		p, err := scanPatient(rows)
-		if err != nil {
-			return nil, err
+	if _, err := tx.Exec(`UPDATE staffs SET mfa_enabled = true WHERE id = $1`, staffID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM staff_mfa_recovery_codes WHERE staff_id = $1`, staffID); err != nil {
+		return err
+	}
+	for _, h := range recoveryCodeHashes {
+		if _, err := tx.Exec(`INSERT INTO staff_mfa_recovery_codes (staff_id, code_hash) VALUES ($1, $2)`, staffID, h); err != nil {
+			return err
 		}
-		result = append(result, p)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	return tx.Commit()
+}
+
+func (r *postgresStaffRepository) ResetMFA(staffID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE staffs SET mfa_enabled = false, mfa_secret = NULL, mfa_last_step = NULL WHERE id = $1`,
+		staffID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM staff_mfa_recovery_codes WHERE staff_id = $1`, staffID); err != nil {
+		return err
 	}
-	return result, nil
+	return tx.Commit()
+}
+
+// ClaimMFAStep records the TOTP step that was just accepted. It reports false
+// if that step (or a later one) was already used, which stops code replay.
+func (r *postgresStaffRepository) ClaimMFAStep(staffID, step int64) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE staffs SET mfa_last_step = $2 WHERE id = $1 AND (mfa_last_step IS NULL OR mfa_last_step < $2)`,
+		staffID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *postgresStaffRepository) UseRecoveryCode(staffID int64, codeHash string) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE staff_mfa_recovery_codes SET used_at = now()
+		 WHERE staff_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		staffID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
 }
 
 func (r *postgresPatientRepository) FindByIdentifier(hospital string, nationalID, passportID *string) (model.Patient, bool, error) {
 	if (nationalID == nil || strings.TrimSpace(*nationalID) == "") && (passportID == nil || strings.TrimSpace(*passportID) == "") {
 		return model.Patient{}, false, nil
 	}
-	query := `SELECT id, hospital, first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en,
-		last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender
-		FROM patients WHERE hospital = $1 AND (`
+	query := `SELECT ` + patientColumns + ` FROM patients
+		WHERE hospital = $1 AND deleted_at IS NULL AND merged_into IS NULL AND erased_at IS NULL AND (`
 	args := []any{hospital}
 	idx := 2
 	conds := make([]string, 0, 2)
@@ -142,85 +243,263 @@ func (r *postgresPatientRepository) FindByIdentifier(hospital string, nationalID
 	return p, true, nil
 }
 
+// UpsertByNationalOrPassport stores a patient fetched from the HIS and marks
+// it as synced now. It overwrites the live patient with the same national ID
+// or, failing that, the same passport number, and inserts a new one otherwise;
+// a deleted patient is not brought back. A change is kept as a version, so
+// values the HIS overwrote can be restored.
 func (r *postgresPatientRepository) UpsertByNationalOrPassport(hospital string, p model.Patient) (model.Patient, error) {
-	var dob any
-	if p.DateOfBirth != nil {
-		dob = p.DateOfBirth.Format("2006-01-02")
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Patient{}, err
 	}
+	defer tx.Rollback()
 
-	row := r.db.QueryRow(`
-		INSERT INTO patients (
-			hospital, first_name_th, middle_name_th, last_name_th,
-			first_name_en, middle_name_en, last_name_en, date_of_birth,
-			patient_hn, national_id, passport_id, phone_number, email, gender
-		)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
-		ON CONFLICT (hospital, national_id) WHERE national_id IS NOT NULL
-		DO UPDATE SET
-			first_name_th = EXCLUDED.first_name_th,
-			middle_name_th = EXCLUDED.middle_name_th,
-			last_name_th = EXCLUDED.last_name_th,
-			first_name_en = EXCLUDED.first_name_en,
-			middle_name_en = EXCLUDED.middle_name_en,
-			last_name_en = EXCLUDED.last_name_en,
-			date_of_birth = EXCLUDED.date_of_birth,
-			patient_hn = EXCLUDED.patient_hn,
-			passport_id = EXCLUDED.passport_id,
-			phone_number = EXCLUDED.phone_number,
-			email = EXCLUDED.email,
-			gender = EXCLUDED.gender,
-			updated_at = now()
-		RETURNING id, hospital, first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en,
-			last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender
-	`, hospital, p.FirstNameTH, p.MiddleNameTH, p.LastNameTH, p.FirstNameEN, p.MiddleNameEN, p.LastNameEN,
-		dob, p.PatientHN, p.NationalID, p.PassportID, p.PhoneNumber, p.Email, p.Gender)
-
-	stored, err := scanPatient(row)
-	if err == nil {
-		return stored, nil
-	}
-
-	row = r.db.QueryRow(`
+	before, err := scanPatient(tx.QueryRow(`
+		SELECT `+patientColumns+` FROM patients
+		WHERE hospital = $1 AND (national_id = $2 OR passport_id = $3)
+		  AND deleted_at IS NULL AND merged_into IS NULL AND erased_at IS NULL
+		ORDER BY (national_id = $2) IS TRUE DESC
+		LIMIT 1
+		FOR UPDATE`,
+		hospital, p.NationalID, p.PassportID,
+	))
+	var stored model.Patient
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if stored, err = insertPatient(tx, hospital, p); err != nil {
+			return model.Patient{}, err
+		}
+		err = recordVersion(tx, hospital, nil, stored, model.PatientVersionHISSync, nil, nil)
+	case err != nil:
+		return model.Patient{}, err
+	default:
+		p.ID = before.ID
+		if stored, err = updatePatient(tx, hospital, p); err != nil {
+			return model.Patient{}, err
+		}
+		err = recordVersion(tx, hospital, &before, stored, model.PatientVersionHISSync, nil, nil)
+	}
+	if err != nil {
+		return model.Patient{}, err
+	}
+	if err := tx.QueryRow(`UPDATE patients SET last_synced_at = now() WHERE hospital = $1 AND id = $2 RETURNING last_synced_at`,
+		hospital, stored.ID).Scan(&stored.LastSyncedAt); err != nil {
+		return model.Patient{}, err
+	}
+	return stored, tx.Commit()
+}
+
+// StaleSynced returns up to limit live patients last synced with the HIS
+// before the given time, least recently synced first.
+func (r *postgresPatientRepository) StaleSynced(hospital string, before time.Time, limit int) ([]model.Patient, error) {
+	rows, err := r.db.Query(`SELECT `+patientColumns+` FROM patients
+		WHERE hospital = $1 AND last_synced_at < $2 AND merged_into IS NULL AND erased_at IS NULL AND deleted_at IS NULL
+		ORDER BY last_synced_at, id
+		LIMIT $3`, hospital, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.Patient, 0)
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// MarkSynced records that the patient was checked against the HIS now without
+// changing it, as when the HIS no longer has it.
+func (r *postgresPatientRepository) MarkSynced(hospital string, id int64) error {
+	_, err := r.db.Exec(`UPDATE patients SET last_synced_at = now() WHERE hospital = $1 AND id = $2`, hospital, id)
+	return err
+}
+
+const patientColumns = `id, hospital, first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en,
+	last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender, phone_e164, merged_into, erased_at, deleted_at, last_synced_at`
+
+func (r *postgresPatientRepository) FindByID(hospital string, id int64) (model.Patient, error) {
+	return scanPatient(r.db.QueryRow(`SELECT `+patientColumns+` FROM patients WHERE hospital = $1 AND id = $2`, hospital, id))
+}
+
+func (r *postgresPatientRepository) Create(hospital string, p model.Patient, staffID int64) (model.Patient, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Patient{}, err
+	}
+	defer tx.Rollback()
+
+	stored, err := insertPatient(tx, hospital, p)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	if err := recordVersion(tx, hospital, nil, stored, model.PatientVersionCreate, &staffID, nil); err != nil {
+		return model.Patient{}, err
+	}
+	return stored, tx.Commit()
+}
+
+func insertPatient(q queryRower, hospital string, p model.Patient) (model.Patient, error) {
+	stored, err := scanPatient(q.QueryRow(`
 		INSERT INTO patients (
 			hospital, first_name_th, middle_name_th, last_name_th,
 			first_name_en, middle_name_en, last_name_en, date_of_birth,
-			patient_hn, national_id, passport_id, phone_number, email, gender
+			patient_hn, national_id, passport_id, phone_number, email, gender, phone_e164
 		)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
-		ON CONFLICT (hospital, passport_id) WHERE passport_id IS NOT NULL
-		DO UPDATE SET
-			first_name_th = EXCLUDED.first_name_th,
-			middle_name_th = EXCLUDED.middle_name_th,
-			last_name_th = EXCLUDED.last_name_th,
-			first_name_en = EXCLUDED.first_name_en,
-			middle_name_en = EXCLUDED.middle_name_en,
-			last_name_en = EXCLUDED.last_name_en,
-			date_of_birth = EXCLUDED.date_of_birth,
-			patient_hn = EXCLUDED.patient_hn,
-			national_id = EXCLUDED.national_id,
-			phone_number = EXCLUDED.phone_number,
-			email = EXCLUDED.email,
-			gender = EXCLUDED.gender,
-			updated_at = now()
-		RETURNING id, hospital, first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en,
-			last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender
-	`, hospital, p.FirstNameTH, p.MiddleNameTH, p.LastNameTH, p.FirstNameEN, p.MiddleNameEN, p.LastNameEN,
-		dob, p.PatientHN, p.NationalID, p.PassportID, p.PhoneNumber, p.Email, p.Gender)
-
-	return scanPatient(row)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+		RETURNING `+patientColumns,
+		hospital, p.FirstNameTH, p.MiddleNameTH, p.LastNameTH, p.FirstNameEN, p.MiddleNameEN, p.LastNameEN,
+		dateArg(p.DateOfBirth), p.PatientHN, p.NationalID, p.PassportID, p.PhoneNumber, p.Email, p.Gender, p.PhoneE164,
+	))
+	return stored, patientWriteError(err)
+}
+
+func (r *postgresPatientRepository) Update(hospital string, p model.Patient, staffID int64) (model.Patient, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Patient{}, err
+	}
+	defer tx.Rollback()
+
+	before, err := lockPatient(tx, hospital, p.ID)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	stored, err := updatePatient(tx, hospital, p)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	if err := recordVersion(tx, hospital, &before, stored, model.PatientVersionUpdate, &staffID, nil); err != nil {
+		return model.Patient{}, err
+	}
+	return stored, tx.Commit()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func updatePatient(q queryRower, hospital string, p model.Patient) (model.Patient, error) {
+	stored, err := scanPatient(q.QueryRow(`
+		UPDATE patients SET
+			first_name_th = $3, middle_name_th = $4, last_name_th = $5,
+			first_name_en = $6, middle_name_en = $7, last_name_en = $8,
+			date_of_birth = $9, patient_hn = $10, national_id = $11, passport_id = $12,
+			phone_number = $13, email = $14, gender = $15, phone_e164 = $16, updated_at = now()
+		WHERE hospital = $1 AND id = $2
+		RETURNING `+patientColumns,
+		hospital, p.ID, p.FirstNameTH, p.MiddleNameTH, p.LastNameTH, p.FirstNameEN, p.MiddleNameEN, p.LastNameEN,
+		dateArg(p.DateOfBirth), p.PatientHN, p.NationalID, p.PassportID, p.PhoneNumber, p.Email, p.Gender, p.PhoneE164,
+	))
+	return stored, patientWriteError(err)
+}
+
+// Delete marks a patient as deleted and keeps the delete as a version, so a
+// restore brings the patient back. It reports false if there is no such live
+// patient, fails with ErrLegalHold while the patient is under an active legal
+// hold and with ErrMergeConflict while merged patients point to it.
+func (r *postgresPatientRepository) Delete(hospital string, id int64, staffID int64) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	before, err := lockPatient(tx, hospital, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if before.DeletedAt != nil || before.ErasedAt != nil {
+		return false, nil
+	}
+	var held, survivor bool
+	if err := tx.QueryRow(`SELECT
+			EXISTS (SELECT 1 FROM patient_legal_holds WHERE hospital = $1 AND patient_id = $2 AND released_at IS NULL),
+			EXISTS (SELECT 1 FROM patients WHERE hospital = $1 AND merged_into = $2)`,
+		hospital, id).Scan(&held, &survivor); err != nil {
+		return false, err
+	}
+	switch {
+	case held:
+		return false, ErrLegalHold
+	case survivor:
+		return false, ErrMergeConflict
+	}
+	deleted, err := scanPatient(tx.QueryRow(`
+		UPDATE patients SET deleted_at = now(), updated_at = now()
+		WHERE hospital = $1 AND id = $2
+		RETURNING `+patientColumns,
+		hospital, id,
+	))
+	if err != nil {
+		return false, err
+	}
+	if err := recordVersion(tx, hospital, &before, deleted, model.PatientVersionDelete, &staffID, nil); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func dateArg(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format("2006-01-02")
+}
+
+// patientWriteError maps a violation of the (hospital, national_id) or
+// (hospital, passport_id) unique index to ErrPatientConflict.
+func patientWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrPatientConflict
+	}
+	return err
+}
+
+// staffWriteError maps a violation of the (username, hospital) unique
+// constraint to ErrStaffConflict.
+func staffWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrStaffConflict
+	}
+	return err
 }
 
 type rowScanner interface {
 	Scan(dest ...any) error
 }
 
-func scanPatient(s rowScanner) (model.Patient, error) {
+func scanStaff(s rowScanner) (model.Staff, error) {
+	var st model.Staff
+	var mfaSecret sql.NullString
+	err := s.Scan(&st.ID, &st.Username, &st.PasswordHash, &st.Hospital, &st.Role, &st.MFAEnabled, &mfaSecret, &st.Active)
+	if err != nil {
+		return model.Staff{}, err
+	}
+	st.MFASecret = mfaSecret.String
+	return st, nil
+}
+
+// scanPatient scans the patientColumns, followed by any extra columns of the
+// query into extra.
+func scanPatient(s rowScanner, extra ...any) (model.Patient, error) {
 	var p model.Patient
 	var dob sql.NullTime
 	var firstTH, middleTH, lastTH, firstEN, middleEN, lastEN sql.NullString
-	var hn, nationalID, passportID, phone, email, gender sql.NullString
+	var hn, nationalID, passportID, phone, email, gender, phoneE164 sql.NullString
 
-	err := s.Scan(
+	dest := []any{
 		&p.ID,
 		&p.Hospital,
 		&firstTH,
@@ -236,7 +515,13 @@ func scanPatient(s rowScanner) (model.Patient, error) {
 		&phone,
 		&email,
 		&gender,
-	)
+		&phoneE164,
+		&p.MergedInto,
+		&p.ErasedAt,
+		&p.DeletedAt,
+		&p.LastSyncedAt,
+	}
+	err := s.Scan(append(dest, extra...)...)
 	if err != nil {
 		return model.Patient{}, err
 	}
@@ -281,5 +566,8 @@ func scanPatient(s rowScanner) (model.Patient, error) {
 	if gender.Valid {
 		p.Gender = &gender.String
 	}
+	if phoneE164.Valid {
+		p.PhoneE164 = &phoneE164.String
+	}
 	return p, nil
 }
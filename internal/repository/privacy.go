@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"agnos/internal/model"
+)
+
+var (
+	ErrPrivacyRequestClosed = errors.New("privacy request is already closed")
+	ErrLegalHold            = errors.New("patient is under legal hold")
+)
+
+type postgresPrivacyRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresPrivacyRepository(db *sql.DB) PrivacyRepository {
+	return &postgresPrivacyRepository{db: db}
+}
+
+const privacyRequestColumns = `id, hospital, patient_id, kind, status, COALESCE(note, ''), requested_by, due_at, created_at,
+	closed_by, closed_at, COALESCE(resolution, '')`
+
+func (r *postgresPrivacyRepository) CreateRequest(req model.PrivacyRequest) (model.PrivacyRequest, error) {
+	return scanPrivacyRequest(r.db.QueryRow(`
+		INSERT INTO privacy_requests (hospital, patient_id, kind, note, requested_by, due_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		RETURNING `+privacyRequestColumns,
+		req.Hospital, req.PatientID, req.Kind, req.Note, req.RequestedBy, req.DueAt,
+	))
+}
+
+// ListRequests lists the requests of a hospital with the given status, or all
+// of them if status is empty, by due date.
+func (r *postgresPrivacyRepository) ListRequests(hospital, status string) ([]model.PrivacyRequest, error) {
+	rows, err := r.db.Query(`SELECT `+privacyRequestColumns+` FROM privacy_requests
+		WHERE hospital = $1 AND ($2 = '' OR status = $2)
+		ORDER BY due_at, id`, hospital, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.PrivacyRequest, 0)
+	for rows.Next() {
+		req, err := scanPrivacyRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, req)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresPrivacyRepository) FindRequest(hospital string, id int64) (model.PrivacyRequest, error) {
+	return scanPrivacyRequest(r.db.QueryRow(`SELECT `+privacyRequestColumns+` FROM privacy_requests WHERE hospital = $1 AND id = $2`, hospital, id))
+}
+
+// RejectRequest closes a pending request without acting on it.
+func (r *postgresPrivacyRepository) RejectRequest(hospital string, id, staffID int64, reason string) (model.PrivacyRequest, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	defer tx.Rollback()
+
+	req, err := closeRequest(tx, hospital, id, staffID, model.PrivacyRequestRejected, reason)
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	return req, tx.Commit()
+}
+
+// CompleteExport closes a pending export request.
+func (r *postgresPrivacyRepository) CompleteExport(hospital string, id, staffID int64) (model.PrivacyRequest, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	defer tx.Rollback()
+
+	req, err := closeRequest(tx, hospital, id, staffID, model.PrivacyRequestCompleted, "")
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	return req, tx.Commit()
+}
+
+// CompleteErasure anonymizes the patient of a pending erasure request and the
+// patients merged into it, and closes the request. The rows are kept with
+// every personal field cleared, so references to them stay valid; the
+// personal data in their versions and merges is removed as well. It returns
+// ErrLegalHold if any of them is under an active legal hold.
+func (r *postgresPrivacyRepository) CompleteErasure(hospital string, id, staffID int64) (model.PrivacyRequest, []int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	defer tx.Rollback()
+
+	req, err := closeRequest(tx, hospital, id, staffID, model.PrivacyRequestCompleted, "")
+	if err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	rows, err := tx.Query(`SELECT `+patientColumns+` FROM patients
+		WHERE hospital = $1 AND (id = $2 OR merged_into = $2)
+		ORDER BY id FOR UPDATE`, hospital, req.PatientID)
+	if err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	var patients []model.Patient
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			rows.Close()
+			return model.PrivacyRequest{}, nil, err
+		}
+		patients = append(patients, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	if len(patients) == 0 {
+		return model.PrivacyRequest{}, nil, sql.ErrNoRows
+	}
+	ids := make([]int64, len(patients))
+	for i, p := range patients {
+		ids[i] = p.ID
+	}
+
+	var held bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM patient_legal_holds
+		WHERE hospital = $1 AND patient_id = ANY($2) AND released_at IS NULL)`, hospital, ids).Scan(&held); err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	if held {
+		return model.PrivacyRequest{}, nil, ErrLegalHold
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE patient_versions SET record = jsonb_build_object('id', patient_id, 'hospital', hospital)
+		WHERE hospital = $1 AND patient_id = ANY($2)`, hospital, ids); err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE patient_merges SET merged_before = jsonb_build_object('id', merged_id, 'hospital', hospital)
+		WHERE hospital = $1 AND merged_id = ANY($2)`, hospital, ids); err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM patient_duplicates
+		WHERE hospital = $1 AND status = 'pending' AND (patient_id = ANY($2) OR duplicate_id = ANY($2))`, hospital, ids); err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	for _, p := range patients {
+		erased, err := scanPatient(tx.QueryRow(`
+			UPDATE patients SET
+				first_name_th = NULL, middle_name_th = NULL, last_name_th = NULL,
+				first_name_en = NULL, middle_name_en = NULL, last_name_en = NULL,
+				date_of_birth = NULL, patient_hn = NULL, national_id = NULL, passport_id = NULL,
+				phone_number = NULL, phone_e164 = NULL, email = NULL, gender = NULL,
+				erased_at = now(), updated_at = now()
+			WHERE hospital = $1 AND id = $2
+			RETURNING `+patientColumns, hospital, p.ID))
+		if err != nil {
+			return model.PrivacyRequest{}, nil, err
+		}
+		if err := recordVersion(tx, hospital, &p, erased, model.PatientVersionErase, &staffID, nil); err != nil {
+			return model.PrivacyRequest{}, nil, err
+		}
+	}
+	return req, ids, tx.Commit()
+}
+
+// closeRequest moves a pending request to status. It returns sql.ErrNoRows
+// for an unknown request and ErrPrivacyRequestClosed if it is not pending.
+func closeRequest(tx *sql.Tx, hospital string, id, staffID int64, status, resolution string) (model.PrivacyRequest, error) {
+	req, err := scanPrivacyRequest(tx.QueryRow(`SELECT `+privacyRequestColumns+` FROM privacy_requests
+		WHERE hospital = $1 AND id = $2 FOR UPDATE`, hospital, id))
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	if req.Status != model.PrivacyRequestPending {
+		return model.PrivacyRequest{}, ErrPrivacyRequestClosed
+	}
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`
+		UPDATE privacy_requests SET status = $3, closed_by = $4, closed_at = $5, resolution = NULLIF($6, '')
+		WHERE hospital = $1 AND id = $2`,
+		hospital, id, status, staffID, now, resolution,
+	); err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	req.Status, req.ClosedBy, req.ClosedAt, req.Resolution = status, &staffID, &now, resolution
+	return req, nil
+}
+
+// MergedRecords returns the patients merged into id.
+func (r *postgresPrivacyRepository) MergedRecords(hospital string, id int64) ([]model.Patient, error) {
+	rows, err := r.db.Query(`SELECT `+patientColumns+` FROM patients WHERE hospital = $1 AND merged_into = $2 ORDER BY id`, hospital, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.Patient, 0)
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresPrivacyRepository) PlaceHold(h model.LegalHold) (model.LegalHold, error) {
+	return scanLegalHold(r.db.QueryRow(`
+		INSERT INTO patient_legal_holds (hospital, patient_id, reason, placed_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+legalHoldColumns,
+		h.Hospital, h.PatientID, h.Reason, h.PlacedBy,
+	))
+}
+
+func (r *postgresPrivacyRepository) FindHold(hospital string, id int64) (model.LegalHold, error) {
+	return scanLegalHold(r.db.QueryRow(`SELECT `+legalHoldColumns+` FROM patient_legal_holds WHERE hospital = $1 AND id = $2`, hospital, id))
+}
+
+// ReleaseHold releases an active hold. It returns sql.ErrNoRows if there is
+// no such active hold.
+func (r *postgresPrivacyRepository) ReleaseHold(hospital string, id, staffID int64) (model.LegalHold, error) {
+	return scanLegalHold(r.db.QueryRow(`
+		UPDATE patient_legal_holds SET released_by = $3, released_at = now()
+		WHERE hospital = $1 AND id = $2 AND released_at IS NULL
+		RETURNING `+legalHoldColumns,
+		hospital, id, staffID,
+	))
+}
+
+// Holds lists the holds of a patient, newest first.
+func (r *postgresPrivacyRepository) Holds(hospital string, patientID int64) ([]model.LegalHold, error) {
+	rows, err := r.db.Query(`SELECT `+legalHoldColumns+` FROM patient_legal_holds
+		WHERE hospital = $1 AND patient_id = $2
+		ORDER BY id DESC`, hospital, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.LegalHold, 0)
+	for rows.Next() {
+		h, err := scanLegalHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+func scanPrivacyRequest(s rowScanner) (model.PrivacyRequest, error) {
+	var req model.PrivacyRequest
+	err := s.Scan(&req.ID, &req.Hospital, &req.PatientID, &req.Kind, &req.Status, &req.Note, &req.RequestedBy, &req.DueAt,
+		&req.CreatedAt, &req.ClosedBy, &req.ClosedAt, &req.Resolution)
+	return req, err
+}
+
+const legalHoldColumns = `id, hospital, patient_id, reason, placed_by, placed_at, released_by, released_at`
+
+func scanLegalHold(s rowScanner) (model.LegalHold, error) {
+	var h model.LegalHold
+	err := s.Scan(&h.ID, &h.Hospital, &h.PatientID, &h.Reason, &h.PlacedBy, &h.PlacedAt, &h.ReleasedBy, &h.ReleasedAt)
+	return h, err
+}
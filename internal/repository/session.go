@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"agnos/internal/model"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type postgresSessionRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresSessionRepository(db *sql.DB) SessionRepository {
+	return &postgresSessionRepository{db: db}
+}
+
+func (r *postgresSessionRepository) Create(staffID int64, mfaVerified bool, refreshHash string, expiresAt time.Time) (model.Session, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Session{}, err
+	}
+	defer tx.Rollback()
+
+	s := model.Session{StaffID: staffID, MFAVerified: mfaVerified}
+	if err := tx.QueryRow(
+		`INSERT INTO staff_sessions (staff_id, mfa_verified) VALUES ($1, $2) RETURNING id, created_at`,
+		staffID, mfaVerified,
+	).Scan(&s.ID, &s.CreatedAt); err != nil {
+		return model.Session{}, err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)`,
+		refreshHash, s.ID, expiresAt,
+	); err != nil {
+		return model.Session{}, err
+	}
+	return s, tx.Commit()
+}
+
+// Rotate exchanges a refresh token for a new one in the same session. A token
+// that was already used means it leaked, so the whole session is revoked.
+func (r *postgresSessionRepository) Rotate(oldHash, newHash string, expiresAt time.Time) (model.Session, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.Session{}, err
+	}
+	defer tx.Rollback()
+
+	var s model.Session
+	var tokenExpiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(
+		`SELECT s.id, s.staff_id, s.mfa_verified, s.created_at, s.revoked_at, t.expires_at, t.used_at
+		 FROM refresh_tokens t JOIN staff_sessions s ON s.id = t.session_id
+		 WHERE t.token_hash = $1
+		 FOR UPDATE`,
+		oldHash,
+	).Scan(&s.ID, &s.StaffID, &s.MFAVerified, &s.CreatedAt, &revokedAt, &tokenExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Session{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	if usedAt.Valid {
+		if _, err := tx.Exec(`UPDATE staff_sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, s.ID); err != nil {
+			return model.Session{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return model.Session{}, err
+		}
+		return model.Session{}, ErrRefreshTokenReused
+	}
+	if revokedAt.Valid || time.Now().After(tokenExpiresAt) {
+		return model.Session{}, ErrRefreshTokenInvalid
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1`, oldHash); err != nil {
+		return model.Session{}, err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)`,
+		newHash, s.ID, expiresAt,
+	); err != nil {
+		return model.Session{}, err
+	}
+	return s, tx.Commit()
+}
+
+func (r *postgresSessionRepository) Revoke(staffID int64, sessionID string) error {
+	_, err := r.db.Exec(
+		`UPDATE staff_sessions SET revoked_at = now() WHERE id = $1 AND staff_id = $2 AND revoked_at IS NULL`,
+		sessionID, staffID,
+	)
+	return err
+}
+
+func (r *postgresSessionRepository) RevokeAllForStaff(staffID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE staff_sessions SET revoked_at = now() WHERE staff_id = $1 AND revoked_at IS NULL`, staffID); err != nil {
+		return err
+	}
+	// A JWT iat has whole seconds, so the cutoff is truncated to match: a
+	// token issued in the same second, e.g. by a login right after a password
+	// change, stays valid. Older tokens of that second are still rejected
+	// because their session is revoked above.
+	if _, err := tx.Exec(`UPDATE staffs SET tokens_revoked_before = date_trunc('second', now()) WHERE id = $1`, staffID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *postgresSessionRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	if _, err := r.db.Exec(`DELETE FROM revoked_access_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(
+		`INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	return err
+}
+
+func (r *postgresSessionRepository) IsAccessTokenRevoked(jti, sessionID string, staffID int64, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(
+		`SELECT
+			EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)
+			OR EXISTS (SELECT 1 FROM staff_sessions WHERE $2 <> '' AND id::text = $2 AND revoked_at IS NOT NULL)
+			OR EXISTS (SELECT 1 FROM staffs WHERE id = $3 AND (NOT active OR tokens_revoked_before > $4))`,
+		jti, sessionID, staffID, issuedAt,
+	).Scan(&revoked)
+	return revoked, err
+}
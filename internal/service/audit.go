@@ -0,0 +1,171 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"agnos/internal/auditchain"
+	"agnos/internal/jwtkeys"
+	"agnos/internal/model"
+	"agnos/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	tokenTypeAuditCheckpoint = "audit_checkpoint"
+	auditVerifyBatch         = 1000
+)
+
+var ErrCheckpointSigningDisabled = errors.New("audit checkpoint signing requires AUDIT_KEYS_DIR")
+
+type AuditService interface {
+	List(hospital string, f model.AuditFilter) ([]model.AuditEntry, error)
+	Verify(hospital string) (model.AuditVerification, error)
+	Checkpoint() ([]model.AuditCheckpoint, error)
+}
+
+type auditService struct {
+	repo repository.AuditRepository
+	keys TokenKeys
+}
+
+// NewAuditService returns the audit service. keys is the dedicated audit key
+// set that signs and verifies chain checkpoints; it must keep the public half
+// of every key it has signed with. With nil keys no checkpoints are signed and
+// existing ones are reported as unverifiable.
+func NewAuditService(repo repository.AuditRepository, keys TokenKeys) AuditService {
+	return &auditService{repo: repo, keys: keys}
+}
+
+func (s *auditService) List(hospital string, f model.AuditFilter) ([]model.AuditEntry, error) {
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+	return s.repo.List(hospital, f)
+}
+
+// Checkpoint signs the current head of every hospital's chain that has moved
+// since its last checkpoint and returns the new checkpoints.
+func (s *auditService) Checkpoint() ([]model.AuditCheckpoint, error) {
+	if s.keys == nil {
+		return nil, ErrCheckpointSigningDisabled
+	}
+	heads, err := s.repo.Heads()
+	if err != nil {
+		return nil, err
+	}
+	created := make([]model.AuditCheckpoint, 0, len(heads))
+	for _, cp := range heads {
+		cp.Signature, err = s.keys.Sign(jwt.MapClaims{
+			"typ":      tokenTypeAuditCheckpoint,
+			"hospital": cp.Hospital,
+			"seq":      cp.Seq,
+			"hash":     cp.Hash,
+			"iat":      time.Now().Unix(),
+		})
+		if err != nil {
+			return created, err
+		}
+		ok, err := s.repo.SaveCheckpoint(cp)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created = append(created, cp)
+		}
+	}
+	return created, nil
+}
+
+// Verify walks a hospital's chain from the start and reports the first entry
+// whose link, content hash or checkpoint does not match.
+func (s *auditService) Verify(hospital string) (model.AuditVerification, error) {
+	result := model.AuditVerification{Hospital: hospital, Valid: true}
+	checkpoints, err := s.repo.Checkpoints(hospital)
+	if err != nil {
+		return result, err
+	}
+	result.Checkpoints = len(checkpoints)
+	bySeq := make(map[int64]model.AuditCheckpoint, len(checkpoints))
+	for _, cp := range checkpoints {
+		bySeq[cp.Seq] = cp
+	}
+
+	fail := func(seq, entryID int64, reason string) (model.AuditVerification, error) {
+		result.Valid = false
+		result.BrokenAt = &model.AuditBreak{Seq: seq, EntryID: entryID, Reason: reason}
+		return result, nil
+	}
+
+	prevSeq, prevHash := int64(0), auditchain.GenesisHash
+	for {
+		batch, err := s.repo.Chain(hospital, prevSeq, auditVerifyBatch)
+		if err != nil {
+			return result, err
+		}
+		for _, e := range batch {
+			if e.Seq != prevSeq+1 {
+				return fail(prevSeq+1, 0, "entry missing")
+			}
+			if e.PrevHash != prevHash {
+				return fail(e.Seq, e.ID, "previous hash does not match")
+			}
+			hash, err := auditchain.Hash(prevHash, e)
+			if err != nil {
+				return result, err
+			}
+			if hash != e.Hash {
+				return fail(e.Seq, e.ID, "entry content does not match its hash")
+			}
+			if cp, ok := bySeq[e.Seq]; ok {
+				if cp.Hash != e.Hash {
+					return fail(e.Seq, e.ID, "entry does not match signed checkpoint")
+				}
+				reason, verified := s.checkSignature(cp)
+				if reason != "" {
+					return fail(e.Seq, e.ID, reason)
+				}
+				if !verified {
+					result.Unverifiable++
+				}
+			}
+			prevSeq, prevHash = e.Seq, e.Hash
+			result.Entries++
+		}
+		if len(batch) < auditVerifyBatch {
+			break
+		}
+	}
+
+	for _, cp := range checkpoints {
+		if cp.Seq > prevSeq {
+			return fail(prevSeq+1, 0, fmt.Sprintf("entries up to checkpoint %d missing", cp.Seq))
+		}
+	}
+	return result, nil
+}
+
+// checkSignature returns why cp's signature is wrong, or verified=false when
+// it cannot be checked because the signing key is not in the key set. A
+// missing key is not evidence of tampering, so it does not break the chain.
+func (s *auditService) checkSignature(cp model.AuditCheckpoint) (reason string, verified bool) {
+	if s.keys == nil {
+		return "", false
+	}
+	token, err := jwt.Parse(cp.Signature, s.keys.Keyfunc, jwt.WithValidMethods(s.keys.Methods()))
+	if errors.Is(err, jwtkeys.ErrUnknownKey) {
+		return "", false
+	}
+	if err != nil || !token.Valid {
+		return "checkpoint signature invalid", false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	seq, _ := claims["seq"].(float64)
+	if !ok || claims["typ"] != tokenTypeAuditCheckpoint || claims["hospital"] != cp.Hospital ||
+		int64(seq) != cp.Seq || claims["hash"] != cp.Hash {
+		return "checkpoint signature does not match checkpoint", false
+	}
+	return "", true
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"agnos/internal/auditchain"
+	"agnos/internal/jwtkeys"
+	"agnos/internal/model"
+)
+
+// memAudit is an in-memory audit log that chains entries like the Postgres
+// repository does.
+type memAudit struct {
+	entries     []model.AuditEntry
+	checkpoints []model.AuditCheckpoint
+	err         error
+}
+
+func (m *memAudit) Record(e model.AuditEntry) error {
+	if m.err != nil {
+		return m.err
+	}
+	prevHash := auditchain.GenesisHash
+	for _, prev := range m.entries {
+		if prev.Hospital == e.Hospital {
+			e.Seq, prevHash = prev.Seq, prev.Hash
+		}
+	}
+	e.ID = int64(len(m.entries) + 1)
+	e.Seq++
+	e.CreatedAt = time.Now().UTC()
+	e.PrevHash = prevHash
+	hash, err := auditchain.Hash(prevHash, e)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func (m *memAudit) List(hospital string, f model.AuditFilter) ([]model.AuditEntry, error) {
+	var out []model.AuditEntry
+	for _, e := range m.entries {
+		if e.Hospital == hospital {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (m *memAudit) Chain(hospital string, afterSeq int64, limit int) ([]model.AuditEntry, error) {
+	var out []model.AuditEntry
+	for _, e := range m.entries {
+		if e.Hospital == hospital && e.Seq > afterSeq && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (m *memAudit) Heads() ([]model.AuditCheckpoint, error) {
+	heads := map[string]model.AuditCheckpoint{}
+	var order []string
+	for _, e := range m.entries {
+		if _, ok := heads[e.Hospital]; !ok {
+			order = append(order, e.Hospital)
+		}
+		heads[e.Hospital] = model.AuditCheckpoint{Hospital: e.Hospital, Seq: e.Seq, Hash: e.Hash}
+	}
+	out := make([]model.AuditCheckpoint, 0, len(order))
+	for _, h := range order {
+		out = append(out, heads[h])
+	}
+	return out, nil
+}
+
+func (m *memAudit) SaveCheckpoint(cp model.AuditCheckpoint) (bool, error) {
+	for _, existing := range m.checkpoints {
+		if existing.Hospital == cp.Hospital && existing.Seq == cp.Seq {
+			return false, nil
+		}
+	}
+	m.checkpoints = append(m.checkpoints, cp)
+	return true, nil
+}
+
+func (m *memAudit) Checkpoints(hospital string) ([]model.AuditCheckpoint, error) {
+	var out []model.AuditCheckpoint
+	for _, cp := range m.checkpoints {
+		if cp.Hospital == hospital {
+			out = append(out, cp)
+		}
+	}
+	return out, nil
+}
+
+func TestAuditCheckpointRequiresKeys(t *testing.T) {
+	audit := NewAuditService(&memAudit{}, nil)
+	if _, err := audit.Checkpoint(); !errors.Is(err, ErrCheckpointSigningDisabled) {
+		t.Fatalf("expected checkpoint signing to be disabled, got %v", err)
+	}
+}
+
+func TestAuditVerifyReportsUnknownKeyAsUnverifiable(t *testing.T) {
+	repo := &memAudit{}
+	for i := 0; i < 3; i++ {
+		if err := repo.Record(model.AuditEntry{Hospital: "A", StaffID: 1, Action: model.AuditActionPatientSearch}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	signer, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("generate keys: %v", err)
+	}
+	if created, err := NewAuditService(repo, signer).Checkpoint(); err != nil || len(created) != 1 {
+		t.Fatalf("expected one checkpoint, got %d (%v)", len(created), err)
+	}
+
+	result, err := NewAuditService(repo, signer).Verify("A")
+	if err != nil || !result.Valid || result.Unverifiable != 0 {
+		t.Fatalf("expected a verified chain, got %+v (%v)", result, err)
+	}
+
+	other, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("generate keys: %v", err)
+	}
+	for _, keys := range []TokenKeys{other, nil} {
+		result, err = NewAuditService(repo, keys).Verify("A")
+		if err != nil || !result.Valid || result.Unverifiable != 1 {
+			t.Fatalf("expected a valid chain with one unverifiable checkpoint, got %+v (%v)", result, err)
+		}
+	}
+
+	repo.checkpoints[0].Signature += "x"
+	result, err = NewAuditService(repo, signer).Verify("A")
+	if err != nil || result.Valid || result.BrokenAt.Reason != "checkpoint signature invalid" {
+		t.Fatalf("expected a broken signature, got %+v (%v)", result, err)
+	}
+}
+
+func (m *memAudit) actions() []string {
+	out := make([]string, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, e.Action)
+	}
+	return out
+}
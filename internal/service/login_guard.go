@@ -0,0 +1,148 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"agnos/internal/model"
+	"agnos/internal/repository"
+)
+
+const (
+	throttleScopeAccount = "account"
+	throttleScopeIP      = "ip"
+)
+
+type LockoutPolicy struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	LockoutDuration    time.Duration
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+}
+
+type LoginBlockedError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("login locked, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.RetryAfter)
+}
+
+// loginGuard throttles logins per account and per client IP. Every failure
+// after the first delays the next attempt exponentially (BaseDelay, 2x, 4x ...
+// up to MaxDelay), and reaching the failure limit locks the key for
+// LockoutDuration. Attempts inside a delay or lock are refused before the
+// password is checked.
+type loginGuard struct {
+	throttles repository.LoginThrottleRepository
+	events    repository.SecurityEventRepository
+	policy    LockoutPolicy
+}
+
+func accountThrottleKey(username, hospital string) string {
+	return hospital + "/" + strings.ToLower(username)
+}
+
+func (g *loginGuard) check(username, hospital, clientIP string) error {
+	keys := [][2]string{{throttleScopeAccount, accountThrottleKey(username, hospital)}}
+	if clientIP != "" {
+		keys = append(keys, [2]string{throttleScopeIP, clientIP})
+	}
+
+	var blocked *LoginBlockedError
+	now := time.Now()
+	for _, k := range keys {
+		t, err := g.throttles.Get(k[0], k[1])
+		if err != nil {
+			return err
+		}
+		if t.BlockedUntil == nil || !t.BlockedUntil.After(now) {
+			continue
+		}
+		wait := t.BlockedUntil.Sub(now)
+		if blocked == nil || wait > blocked.RetryAfter {
+			blocked = &LoginBlockedError{RetryAfter: wait, Locked: t.Locked}
+		}
+	}
+	if blocked != nil {
+		return blocked
+	}
+	return nil
+}
+
+func (g *loginGuard) fail(username, hospital, clientIP string, staffID *int64) error {
+	locked, err := g.record(throttleScopeAccount, accountThrottleKey(username, hospital), g.policy.MaxAccountFailures)
+	if err != nil {
+		return err
+	}
+	if locked {
+		if err := g.events.Record(model.SecurityEvent{
+			Hospital: hospital,
+			Type:     model.SecurityEventAccountLocked,
+			StaffID:  staffID,
+			Username: username,
+			ClientIP: clientIP,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if clientIP == "" {
+		return nil
+	}
+	locked, err = g.record(throttleScopeIP, clientIP, g.policy.MaxIPFailures)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return g.events.Record(model.SecurityEvent{
+			Hospital: hospital,
+			Type:     model.SecurityEventIPLocked,
+			Username: username,
+			ClientIP: clientIP,
+		})
+	}
+	return nil
+}
+
+func (g *loginGuard) record(scope, key string, maxFailures int) (bool, error) {
+	t, err := g.throttles.RecordFailure(scope, key, g.policy.LockoutDuration)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if maxFailures > 0 && t.Failures >= maxFailures {
+		return true, g.throttles.Block(scope, key, now.Add(g.policy.LockoutDuration), true)
+	}
+	if t.Failures < 2 || g.policy.BaseDelay <= 0 {
+		return false, nil
+	}
+	delay := g.policy.BaseDelay << (t.Failures - 2)
+	if delay > g.policy.MaxDelay || delay <= 0 {
+		delay = g.policy.MaxDelay
+	}
+	return false, g.throttles.Block(scope, key, now.Add(delay), false)
+}
+
+func (g *loginGuard) succeed(username, hospital string) error {
+	return g.throttles.Reset(throttleScopeAccount, accountThrottleKey(username, hospital))
+}
+
+func (g *loginGuard) unlock(staff model.Staff, actorID int64) error {
+	if err := g.throttles.Reset(throttleScopeAccount, accountThrottleKey(staff.Username, staff.Hospital)); err != nil {
+		return err
+	}
+	return g.events.Record(model.SecurityEvent{
+		Hospital:     staff.Hospital,
+		Type:         model.SecurityEventAccountUnlocked,
+		StaffID:      &staff.ID,
+		Username:     staff.Username,
+		ActorStaffID: &actorID,
+	})
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"agnos/internal/model"
+)
+
+type memThrottles struct {
+	rows map[[2]string]model.LoginThrottle
+}
+
+func newMemThrottles() *memThrottles {
+	return &memThrottles{rows: map[[2]string]model.LoginThrottle{}}
+}
+
+func (m *memThrottles) Get(scope, key string) (model.LoginThrottle, error) {
+	t, ok := m.rows[[2]string{scope, key}]
+	if !ok {
+		return model.LoginThrottle{Scope: scope, Key: key}, nil
+	}
+	return t, nil
+}
+
+func (m *memThrottles) RecordFailure(scope, key string, window time.Duration) (model.LoginThrottle, error) {
+	t, _ := m.Get(scope, key)
+	t.Failures++
+	m.rows[[2]string{scope, key}] = t
+	return t, nil
+}
+
+func (m *memThrottles) Block(scope, key string, until time.Time, locked bool) error {
+	t, _ := m.Get(scope, key)
+	t.BlockedUntil = &until
+	t.Locked = locked
+	m.rows[[2]string{scope, key}] = t
+	return nil
+}
+
+func (m *memThrottles) Reset(scope, key string) error {
+	delete(m.rows, [2]string{scope, key})
+	return nil
+}
+
+type memSecurityEvents struct {
+	events []model.SecurityEvent
+}
+
+func (m *memSecurityEvents) Record(e model.SecurityEvent) error {
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memSecurityEvents) ListByHospital(hospital string, limit int) ([]model.SecurityEvent, error) {
+	return m.events, nil
+}
+
+func newTestGuard(policy LockoutPolicy) (*loginGuard, *memThrottles, *memSecurityEvents) {
+	throttles := newMemThrottles()
+	events := &memSecurityEvents{}
+	return &loginGuard{throttles: throttles, events: events, policy: policy}, throttles, events
+}
+
+func TestLoginGuardProgressiveDelay(t *testing.T) {
+	guard, throttles, _ := newTestGuard(LockoutPolicy{
+		MaxAccountFailures: 10,
+		LockoutDuration:    time.Hour,
+		BaseDelay:          time.Second,
+		MaxDelay:           4 * time.Second,
+	})
+	key := [2]string{throttleScopeAccount, accountThrottleKey("John", "A")}
+
+	if err := guard.fail("John", "A", "", nil); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+	if throttles.rows[key].BlockedUntil != nil {
+		t.Fatalf("expected no delay after the first failure")
+	}
+	if err := guard.check("john", "A", ""); err != nil {
+		t.Fatalf("expected login allowed after one failure, got %v", err)
+	}
+
+	for failures, want := range map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 4 * time.Second, 5: 4 * time.Second} {
+		throttles.rows[key] = model.LoginThrottle{Failures: failures - 1}
+		start := time.Now()
+		if err := guard.fail("John", "A", "", nil); err != nil {
+			t.Fatalf("fail: %v", err)
+		}
+		got := throttles.rows[key]
+		if got.Locked {
+			t.Fatalf("failure %d: expected a delay, not a lock", failures)
+		}
+		if delay := got.BlockedUntil.Sub(start); delay < want || delay > want+time.Second {
+			t.Fatalf("failure %d: expected a delay of %s, got %s", failures, want, delay)
+		}
+	}
+
+	var blocked *LoginBlockedError
+	if err := guard.check("john", "A", ""); !errors.As(err, &blocked) || blocked.Locked {
+		t.Fatalf("expected a delay error, got %v", err)
+	}
+}
+
+func TestLoginGuardLocksAccountAndIP(t *testing.T) {
+	guard, throttles, events := newTestGuard(LockoutPolicy{
+		MaxAccountFailures: 3,
+		MaxIPFailures:      5,
+		LockoutDuration:    15 * time.Minute,
+	})
+	staffID := int64(7)
+
+	for i := 0; i < 3; i++ {
+		if err := guard.fail("john", "A", "10.0.0.5", &staffID); err != nil {
+			t.Fatalf("fail: %v", err)
+		}
+	}
+	var blocked *LoginBlockedError
+	if err := guard.check("john", "A", "10.0.0.6"); !errors.As(err, &blocked) || !blocked.Locked {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+	if blocked.RetryAfter <= 14*time.Minute {
+		t.Fatalf("expected the lock to last the lockout duration, got %s", blocked.RetryAfter)
+	}
+	if len(events.events) != 1 || events.events[0].Type != model.SecurityEventAccountLocked || *events.events[0].StaffID != staffID {
+		t.Fatalf("expected one account_locked event, got %+v", events.events)
+	}
+	if err := guard.check("jane", "A", "10.0.0.6"); err != nil {
+		t.Fatalf("expected other accounts to stay open, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := guard.fail("jane", "A", "10.0.0.5", nil); err != nil {
+			t.Fatalf("fail: %v", err)
+		}
+	}
+	if err := guard.check("bob", "A", "10.0.0.5"); !errors.As(err, &blocked) || !blocked.Locked {
+		t.Fatalf("expected the client IP to be locked, got %v", err)
+	}
+	if last := events.events[len(events.events)-1]; last.Type != model.SecurityEventIPLocked || last.ClientIP != "10.0.0.5" {
+		t.Fatalf("expected an ip_locked event, got %+v", last)
+	}
+
+	if err := guard.unlock(model.Staff{ID: staffID, Username: "John", Hospital: "A"}, 1); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if _, ok := throttles.rows[[2]string{throttleScopeAccount, accountThrottleKey("john", "A")}]; ok {
+		t.Fatalf("expected unlock to reset the account throttle")
+	}
+	if err := guard.check("john", "A", "10.0.0.6"); err != nil {
+		t.Fatalf("expected the account to be unlocked, got %v", err)
+	}
+}
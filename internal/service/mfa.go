@@ -0,0 +1,238 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"agnos/internal/model"
+	"agnos/internal/secretbox"
+	"agnos/internal/totp"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	tokenTypeAccess      = "access"
+	tokenTypeMFA         = "mfa"
+	recoveryCodeCount    = 10
+	totpAllowedSkewSteps = 1
+)
+
+var (
+	ErrMFANotConfigured  = errors.New("mfa is not configured")
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+	ErrMFANotEnrolled    = errors.New("mfa enrollment not started")
+	ErrInvalidMFAToken   = errors.New("invalid mfa token")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+)
+
+type MFAConfig struct {
+	Issuer            string
+	Secrets           *secretbox.Box
+	RequiredHospitals map[string]bool
+	ChallengeTTL      time.Duration
+}
+
+func (s *staffService) MFARequired(hospital string) bool {
+	return s.mfa.RequiredHospitals[hospital]
+}
+
+func (s *staffService) mfaChallenge(user model.Staff) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return s.keys.Sign(jwt.MapClaims{
+		"typ":      tokenTypeMFA,
+		"jti":      jti,
+		"staff_id": user.ID,
+		"iat":      now.Unix(),
+		"exp":      now.Add(s.mfa.ChallengeTTL).Unix(),
+	})
+}
+
+func (s *staffService) VerifyMFA(mfaToken, code, recoveryCode, clientIP string) (model.TokenPair, error) {
+	staffID, err := s.parseMFAChallenge(mfaToken)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	user, err := s.repo.FindByID(staffID)
+	if err != nil || !user.MFAEnabled || !user.Active {
+		return model.TokenPair{}, ErrInvalidMFAToken
+	}
+	if err := s.guard.check(user.Username, user.Hospital, clientIP); err != nil {
+		return model.TokenPair{}, s.loginFailed(err, user.ID, user.Username, user.Hospital, clientIP, "throttled")
+	}
+
+	ok, err := s.checkSecondFactor(user, code, recoveryCode)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	if !ok {
+		if err := s.guard.fail(user.Username, user.Hospital, clientIP, &user.ID); err != nil {
+			return model.TokenPair{}, s.loginFailed(err, user.ID, user.Username, user.Hospital, clientIP, "invalid_mfa_code")
+		}
+		return model.TokenPair{}, s.loginFailed(ErrInvalidMFACode, user.ID, user.Username, user.Hospital, clientIP, "invalid_mfa_code")
+	}
+	if err := s.guard.succeed(user.Username, user.Hospital); err != nil {
+		return model.TokenPair{}, err
+	}
+	if err := s.auditLogin(model.AuditActionLoginSucceeded, user.ID, user.Username, user.Hospital, clientIP, ""); err != nil {
+		return model.TokenPair{}, err
+	}
+	return s.startSession(user, true)
+}
+
+func (s *staffService) parseMFAChallenge(mfaToken string) (int64, error) {
+	token, err := jwt.Parse(mfaToken, s.keys.Keyfunc, jwt.WithValidMethods(s.keys.Methods()), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, ErrInvalidMFAToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != tokenTypeMFA {
+		return 0, ErrInvalidMFAToken
+	}
+	staffID, ok := claims["staff_id"].(float64)
+	if !ok {
+		return 0, ErrInvalidMFAToken
+	}
+	return int64(staffID), nil
+}
+
+func (s *staffService) checkSecondFactor(user model.Staff, code, recoveryCode string) (bool, error) {
+	if recoveryCode = normalizeRecoveryCode(recoveryCode); recoveryCode != "" {
+		return s.repo.UseRecoveryCode(user.ID, hashToken(recoveryCode))
+	}
+	secret, err := s.openMFASecret(user)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), totpAllowedSkewSteps)
+	if !ok {
+		return false, nil
+	}
+	return s.repo.ClaimMFAStep(user.ID, step)
+}
+
+func (s *staffService) EnrollMFA(staffID int64) (model.MFAEnrollment, error) {
+	if s.mfa.Secrets == nil {
+		return model.MFAEnrollment{}, ErrMFANotConfigured
+	}
+	user, err := s.repo.FindByID(staffID)
+	if err != nil {
+		return model.MFAEnrollment{}, ErrStaffNotFound
+	}
+	if user.MFAEnabled {
+		return model.MFAEnrollment{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return model.MFAEnrollment{}, err
+	}
+	sealed, err := s.mfa.Secrets.Seal(secret)
+	if err != nil {
+		return model.MFAEnrollment{}, err
+	}
+	if err := s.repo.SetMFASecret(user.ID, sealed); err != nil {
+		return model.MFAEnrollment{}, err
+	}
+	return model.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.mfa.Issuer, user.Username+"@"+user.Hospital, secret),
+	}, nil
+}
+
+// ActivateMFA confirms enrollment with a first code from the authenticator
+// and returns the one-time recovery codes. They are only stored hashed, so
+// this is the only time they can be shown.
+func (s *staffService) ActivateMFA(staffID int64, code string) ([]string, error) {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil {
+		return nil, ErrStaffNotFound
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, err := s.openMFASecret(user)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), totpAllowedSkewSteps)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	if claimed, err := s.repo.ClaimMFAStep(user.ID, step); err != nil {
+		return nil, err
+	} else if !claimed {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		c, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = c
+		hashes[i] = hashToken(normalizeRecoveryCode(c))
+	}
+	if err := s.repo.EnableMFA(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *staffService) ResetMFA(hospital string, actorID, staffID int64) error {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil || user.Hospital != hospital {
+		return ErrStaffNotFound
+	}
+	if err := s.repo.ResetMFA(user.ID); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeAllForStaff(user.ID); err != nil {
+		return err
+	}
+	return s.events.Record(model.SecurityEvent{
+		Hospital:     user.Hospital,
+		Type:         model.SecurityEventMFAReset,
+		StaffID:      &user.ID,
+		Username:     user.Username,
+		ActorStaffID: &actorID,
+	})
+}
+
+func (s *staffService) openMFASecret(user model.Staff) (string, error) {
+	if s.mfa.Secrets == nil {
+		return "", ErrMFANotConfigured
+	}
+	if user.MFASecret == "" {
+		return "", ErrMFANotEnrolled
+	}
+	return s.mfa.Secrets.Open(user.MFASecret)
+}
+
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	out := make([]byte, len(b))
+	for i, v := range b {
+		out[i] = recoveryAlphabet[int(v)%len(recoveryAlphabet)]
+	}
+	return fmt.Sprintf("%s-%s", out[:5], out[5:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"agnos/internal/masking"
+	"agnos/internal/model"
+	"agnos/internal/mpi"
+	"agnos/internal/repository"
+)
+
+const (
+	duplicateCandidateLimit = 50
+	duplicateListLimit      = 100
+)
+
+var (
+	ErrPatientMerged     = errors.New("patient was merged into another record")
+	ErrMergeNotFound     = errors.New("merge not found")
+	ErrMergeConflict     = errors.New("patients cannot be merged or unmerged in their current state")
+	ErrDuplicateNotFound = errors.New("duplicate candidate not found")
+	ErrHasMergedPatients = errors.New("other patients were merged into this patient; unmerge them first")
+)
+
+// MPIService is the staff side of the master patient index: reviewing likely
+// duplicates and merging or unmerging patients. Every call is audited.
+type MPIService interface {
+	Duplicates(actor model.Actor, limit int) ([]model.DuplicateCandidate, error)
+	DismissDuplicate(actor model.Actor, id int64) error
+	Merge(actor model.Actor, survivorID, mergedID int64, reason string) (model.PatientMerge, error)
+	Unmerge(actor model.Actor, mergeID int64) (model.PatientMerge, error)
+	Merges(actor model.Actor, patientID int64) ([]model.PatientMerge, error)
+}
+
+type mpiService struct {
+	repo     repository.MPIRepository
+	patients repository.PatientRepository
+	audit    repository.AuditRepository
+	masks    masking.Policies
+}
+
+func NewMPIService(repo repository.MPIRepository, patients repository.PatientRepository, audit repository.AuditRepository, masks masking.Policies) MPIService {
+	return &mpiService{repo: repo, patients: patients, audit: audit, masks: masks}
+}
+
+func (s *mpiService) Duplicates(actor model.Actor, limit int) ([]model.DuplicateCandidate, error) {
+	if limit <= 0 || limit > duplicateListLimit {
+		limit = duplicateListLimit
+	}
+	candidates, err := s.repo.ListDuplicates(actor.Hospital, limit)
+	if err != nil {
+		return nil, err
+	}
+	var shown []model.Patient
+	for i := range candidates {
+		for _, id := range []int64{candidates[i].PatientID, candidates[i].DuplicateID} {
+			p, err := s.patients.FindByID(actor.Hospital, id)
+			if err != nil {
+				return nil, err
+			}
+			candidates[i].Patients = append(candidates[i].Patients, maskPatient(s.masks, actor, p))
+			shown = append(shown, p)
+		}
+	}
+	if err := recordPatientAccess(s.audit, actor, model.AuditActionDuplicateList, nil, shown); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+func (s *mpiService) DismissDuplicate(actor model.Actor, id int64) error {
+	dismissed, err := s.repo.DismissDuplicate(actor.Hospital, id, actor.StaffID)
+	if err != nil {
+		return err
+	}
+	if !dismissed {
+		return ErrDuplicateNotFound
+	}
+	return recordPatientAccess(s.audit, actor, model.AuditActionDuplicateSkip, map[string]int64{"duplicate_id": id}, nil)
+}
+
+// Merge folds mergedID into survivorID. The merged patient is kept as a
+// tombstone so the merge can be reverted with Unmerge.
+func (s *mpiService) Merge(actor model.Actor, survivorID, mergedID int64, reason string) (model.PatientMerge, error) {
+	if survivorID == mergedID {
+		return model.PatientMerge{}, ErrMergeConflict
+	}
+	m, err := s.repo.Merge(actor.Hospital, survivorID, mergedID, actor.StaffID, strings.TrimSpace(reason))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return model.PatientMerge{}, ErrPatientNotFound
+	case errors.Is(err, repository.ErrMergeConflict):
+		return model.PatientMerge{}, ErrMergeConflict
+	case errors.Is(err, repository.ErrPatientConflict):
+		return model.PatientMerge{}, ErrPatientConflict
+	case err != nil:
+		return model.PatientMerge{}, err
+	}
+	s.recordMerge(actor, model.AuditActionPatientMerge, m)
+	return m, nil
+}
+
+func (s *mpiService) Unmerge(actor model.Actor, mergeID int64) (model.PatientMerge, error) {
+	m, err := s.repo.Unmerge(actor.Hospital, mergeID, actor.StaffID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return model.PatientMerge{}, ErrMergeNotFound
+	case errors.Is(err, repository.ErrMergeConflict):
+		return model.PatientMerge{}, ErrMergeConflict
+	case errors.Is(err, repository.ErrPatientConflict):
+		return model.PatientMerge{}, ErrPatientConflict
+	case err != nil:
+		return model.PatientMerge{}, err
+	}
+	s.recordMerge(actor, model.AuditActionPatientUnmerge, m)
+	return m, nil
+}
+
+func (s *mpiService) Merges(actor model.Actor, patientID int64) ([]model.PatientMerge, error) {
+	merges, err := s.repo.Merges(actor.Hospital, patientID)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int64]bool{patientID: true}
+	shown := []model.Patient{{ID: patientID}}
+	for _, m := range merges {
+		for _, id := range []int64{m.SurvivorID, m.MergedID} {
+			if !seen[id] {
+				seen[id] = true
+				shown = append(shown, model.Patient{ID: id})
+			}
+		}
+	}
+	if err := recordPatientAccess(s.audit, actor, model.AuditActionMergeList, map[string]int64{"patient_id": patientID}, shown); err != nil {
+		return nil, err
+	}
+	return merges, nil
+}
+
+func (s *mpiService) recordMerge(actor model.Actor, action string, m model.PatientMerge) {
+	criteria := map[string]any{
+		"merge_id":      m.ID,
+		"survivor_id":   m.SurvivorID,
+		"merged_id":     m.MergedID,
+		"reason":        m.Reason,
+		"filled_fields": m.FilledFields,
+	}
+	recordChange(s.audit, actor, action, criteria, []model.Patient{{ID: m.SurvivorID}, {ID: m.MergedID}})
+}
+
+// detectDuplicates scores p against the patients that share an identifier,
+// phone, email or birth date and last name with it, and queues likely
+// duplicates for review.
+func detectDuplicates(repo repository.MPIRepository, hospital string, p model.Patient) error {
+	candidates, err := repo.Candidates(hospital, p, duplicateCandidateLimit)
+	if err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		m := mpi.Score(p, c)
+		if m.Score < mpi.CandidateThreshold {
+			continue
+		}
+		if err := repo.QueueDuplicate(model.DuplicateCandidate{
+			Hospital:    hospital,
+			PatientID:   p.ID,
+			DuplicateID: c.ID,
+			Score:       m.Score,
+			Reasons:     m.Reasons,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
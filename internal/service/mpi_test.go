@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"agnos/internal/masking"
+	"agnos/internal/model"
+)
+
+func TestMergeUnmergeRoundTrip(t *testing.T) {
+	repo, audit := newMemPatients(), &memAudit{}
+	patients := newTestPatientService(repo, audit, nil)
+	merges := NewMPIService(&memMPI{patients: repo}, repo, audit, masking.Policies{})
+
+	survivor, err := patients.Create(testActor, newTestPatient("1234567890121"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	dup := model.Patient{FirstNameEN: strPtr("Johnny"), LastNameEN: strPtr("Doe"), PassportID: strPtr("AA123456"), PhoneNumber: strPtr("081-234-5678")}
+	merged, err := patients.Create(testActor, dup)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if _, err := merges.Merge(testActor, survivor.ID, survivor.ID, ""); !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected a patient not to merge into itself, got %v", err)
+	}
+	if _, err := merges.Merge(testActor, survivor.ID, 999, ""); !errors.Is(err, ErrPatientNotFound) {
+		t.Fatalf("expected an unknown patient to be not found, got %v", err)
+	}
+
+	m, err := merges.Merge(testActor, survivor.ID, merged.ID, "same person")
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if want := []string{"passport_id", "phone_e164", "phone_number"}; !reflect.DeepEqual(m.FilledFields, want) {
+		t.Fatalf("expected filled fields %v, got %v", want, m.FilledFields)
+	}
+	got, err := patients.Get(testActor, survivor.ID)
+	if err != nil || got.PassportID == nil || *got.PassportID != "AA123456" || *got.FirstNameEN != "John" {
+		t.Fatalf("expected the survivor to gain the passport and keep its name, got %+v (%v)", got, err)
+	}
+	if _, err := patients.Get(testActor, merged.ID); !errors.Is(err, ErrPatientMerged) {
+		t.Fatalf("expected the merged patient to be a tombstone, got %v", err)
+	}
+	if _, err := merges.Merge(testActor, merged.ID, survivor.ID, ""); !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected a tombstone not to merge again, got %v", err)
+	}
+
+	// A field edited after the merge is kept by the unmerge.
+	if _, err := patients.Update(testActor, survivor.ID, []byte(`{"phone_number":"0899999999"}`)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	if _, err := merges.Unmerge(testActor, m.ID); err != nil {
+		t.Fatalf("unmerge: %v", err)
+	}
+	got, err = patients.Get(testActor, survivor.ID)
+	if err != nil || got.PassportID != nil || got.PhoneNumber == nil || *got.PhoneNumber != "0899999999" {
+		t.Fatalf("expected the survivor to lose the passport and keep the edited phone, got %+v (%v)", got, err)
+	}
+	restored, err := patients.Get(testActor, merged.ID)
+	if err != nil || restored.PassportID == nil || *restored.PassportID != "AA123456" || *restored.FirstNameEN != "Johnny" {
+		t.Fatalf("expected the merged patient back as it was, got %+v (%v)", restored, err)
+	}
+	if _, err := merges.Unmerge(testActor, m.ID); !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected a second unmerge to conflict, got %v", err)
+	}
+	if _, err := merges.Unmerge(testActor, 999); !errors.Is(err, ErrMergeNotFound) {
+		t.Fatalf("expected an unknown merge to be not found, got %v", err)
+	}
+
+	history, err := merges.Merges(testActor, survivor.ID)
+	if err != nil || len(history) != 1 || history[0].RevertedAt == nil {
+		t.Fatalf("expected one reverted merge, got %+v (%v)", history, err)
+	}
+	want := []string{
+		model.AuditActionPatientCreate, model.AuditActionPatientCreate,
+		model.AuditActionPatientMerge, model.AuditActionPatientView, model.AuditActionPatientUpdate,
+		model.AuditActionPatientUnmerge, model.AuditActionPatientView, model.AuditActionPatientView,
+		model.AuditActionMergeList,
+	}
+	if got := audit.actions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected audit actions %v, got %v", want, got)
+	}
+}
@@ -1,52 +1,418 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"agnos/internal/his"
+	"agnos/internal/masking"
 	"agnos/internal/model"
 	"agnos/internal/repository"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrAuditFailed     = errors.New("audit log unavailable")
+	ErrPatientNotFound = errors.New("patient not found")
+	ErrPatientConflict = errors.New("a patient with this national_id or passport_id already exists")
+	ErrInvalidSearch   = errors.New("invalid search")
+	ErrReasonRequired  = errors.New("reason is required")
+	ErrVersionNotFound = errors.New("patient version not found")
+	ErrHISUnavailable  = errors.New("HIS lookup failed")
+	ErrHISTimeout      = errors.New("HIS lookup timed out")
 )
 
 type PatientService interface {
-	Search(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error)
+	Search(ctx context.Context, actor model.Actor, c model.PatientSearchCriteria) (model.PatientPage, error)
+	Get(actor model.Actor, id int64) (model.Patient, error)
+	Create(actor model.Actor, p model.Patient) (model.Patient, error)
+	Update(actor model.Actor, id int64, patch json.RawMessage) (model.Patient, error)
+	Delete(actor model.Actor, id int64) error
+	Reveal(actor model.Actor, id int64, reason string) (model.Patient, error)
+	History(actor model.Actor, id int64) ([]model.PatientVersion, error)
+	Restore(actor model.Actor, id int64, version int) (model.Patient, error)
+	RefreshStale(ctx context.Context, batch int, pause time.Duration) ([]model.HISSyncRun, error)
 }
 
 type patientService struct {
-	repo      repository.PatientRepository
-	hisClient his.HospitalAClient
+	repo       repository.PatientRepository
+	audit      repository.AuditRepository
+	his        his.Registry
+	duplicates repository.MPIRepository
+	masks      masking.Policies
+	lookups    singleflight.Group
+	refreshing chan struct{}
 }
 
-func NewPatientService(repo repository.PatientRepository, hisClient his.HospitalAClient) PatientService {
-	return &patientService{repo: repo, hisClient: hisClient}
+// NewPatientService returns the patient service. Created, updated and
+// HIS-fetched patients are checked for duplicates against duplicates.
+// Patients are returned masked according to masks and the caller's role.
+func NewPatientService(repo repository.PatientRepository, audit repository.AuditRepository, hisRegistry his.Registry, duplicates repository.MPIRepository, masks masking.Policies) PatientService {
+	return &patientService{repo: repo, audit: audit, his: hisRegistry, duplicates: duplicates, masks: masks, refreshing: make(chan struct{}, maxBackgroundRefreshes)}
 }
 
-func (s *patientService) Search(hospital string, c model.PatientSearchCriteria) ([]model.Patient, error) {
-	hospital = strings.TrimSpace(hospital)
+// Search returns patients of the actor's hospital. Every search is written to
+// the audit log; if that fails no results are returned. A patient not stored
+// yet is looked up in the HIS; the search stops waiting for it when ctx is
+// done. A failed lookup is reported in the page, or as ErrHISUnavailable or
+// ErrHISTimeout in a strict search. Stale patients are returned as they are
+// and refreshed in the background.
+func (s *patientService) Search(ctx context.Context, actor model.Actor, c model.PatientSearchCriteria) (model.PatientPage, error) {
+	hospital := strings.TrimSpace(actor.Hospital)
 	if hospital == "" {
-		return nil, nil
+		return model.PatientPage{}, nil
+	}
+	if err := normalizeSearchIDs(&c); err != nil {
+		return model.PatientPage{}, err
+	}
+	page, err := s.search(ctx, hospital, c)
+	if errors.Is(err, repository.ErrInvalidPage) {
+		return model.PatientPage{}, fmt.Errorf("%w: unknown sort or total, negative limit, or malformed cursor", ErrInvalidSearch)
+	}
+	if err != nil {
+		return model.PatientPage{}, err
+	}
+	if err := s.recordAccess(actor, model.AuditActionPatientSearch, c, page.Patients); err != nil {
+		return model.PatientPage{}, err
+	}
+	s.revalidate(hospital, page.Patients)
+	page.Patients = maskPatients(s.masks, actor, page.Patients)
+	return page, nil
+}
+
+// Get returns a patient. For a patient merged into another one it returns
+// only the ID and MergedInto, with ErrPatientMerged.
+func (s *patientService) Get(actor model.Actor, id int64) (model.Patient, error) {
+	p, err := s.findLive(actor.Hospital, id)
+	if err != nil {
+		return p, err
+	}
+	if err := s.recordAccess(actor, model.AuditActionPatientView, nil, []model.Patient{p}); err != nil {
+		return model.Patient{}, err
+	}
+	s.revalidate(actor.Hospital, []model.Patient{p})
+	return maskPatient(s.masks, actor, p), nil
+}
+
+// Reveal returns a patient without masking. The reason is required and is
+// written to the audit log with the fields that were revealed.
+func (s *patientService) Reveal(actor model.Actor, id int64, reason string) (model.Patient, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return model.Patient{}, ErrReasonRequired
+	}
+	p, err := s.findLive(actor.Hospital, id)
+	if err != nil {
+		return p, err
+	}
+	criteria := map[string]any{"reason": reason, "fields": s.masks.For(actor.Hospital).Fields}
+	if err := s.recordAccess(actor, model.AuditActionPatientReveal, criteria, []model.Patient{p}); err != nil {
+		return model.Patient{}, err
+	}
+	return p, nil
+}
+
+func (s *patientService) Create(actor model.Actor, p model.Patient) (model.Patient, error) {
+	p.ID, p.Hospital = 0, actor.Hospital
+	if err := normalizePatient(&p); err != nil {
+		return model.Patient{}, err
+	}
+	created, err := s.repo.Create(actor.Hospital, p, actor.StaffID)
+	if errors.Is(err, repository.ErrPatientConflict) {
+		return model.Patient{}, ErrPatientConflict
+	}
+	if err != nil {
+		return model.Patient{}, err
 	}
+	_ = detectDuplicates(s.duplicates, actor.Hospital, created)
+	recordChange(s.audit, actor, model.AuditActionPatientCreate, nil, []model.Patient{created})
+	return maskPatient(s.masks, actor, created), nil
+}
 
+// Update applies patch as a JSON merge patch: fields that are present replace
+// the stored value, null clears it and absent fields are left unchanged.
+func (s *patientService) Update(actor model.Actor, id int64, patch json.RawMessage) (model.Patient, error) {
+	current, err := s.findLive(actor.Hospital, id)
+	if err != nil {
+		return current, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return model.Patient{}, invalidPatient("body must be a JSON object")
+	}
+	updated, err := applyPatch(current, patch)
+	if err != nil {
+		return model.Patient{}, invalidPatient(err.Error())
+	}
+	updated.ID, updated.Hospital = current.ID, current.Hospital
+	if err := normalizePatient(&updated); err != nil {
+		return model.Patient{}, err
+	}
+
+	stored, err := s.repo.Update(actor.Hospital, updated, actor.StaffID)
+	switch {
+	case errors.Is(err, repository.ErrPatientConflict):
+		return model.Patient{}, ErrPatientConflict
+	case errors.Is(err, sql.ErrNoRows):
+		return model.Patient{}, ErrPatientNotFound
+	case err != nil:
+		return model.Patient{}, err
+	}
+	changed := make([]string, 0, len(fields))
+	for name := range fields {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	_ = detectDuplicates(s.duplicates, actor.Hospital, stored)
+	recordChange(s.audit, actor, model.AuditActionPatientUpdate, map[string][]string{"fields": changed}, []model.Patient{stored})
+	return maskPatient(s.masks, actor, stored), nil
+}
+
+func (s *patientService) Delete(actor model.Actor, id int64) error {
+	if _, err := s.findLive(actor.Hospital, id); err != nil {
+		return err
+	}
+	deleted, err := s.repo.Delete(actor.Hospital, id, actor.StaffID)
+	switch {
+	case errors.Is(err, repository.ErrLegalHold):
+		return ErrLegalHold
+	case errors.Is(err, repository.ErrMergeConflict):
+		return ErrHasMergedPatients
+	case err != nil:
+		return err
+	}
+	if !deleted {
+		return ErrPatientNotFound
+	}
+	recordChange(s.audit, actor, model.AuditActionPatientDelete, nil, []model.Patient{{ID: id}})
+	return nil
+}
+
+func (s *patientService) find(hospital string, id int64) (model.Patient, error) {
+	p, err := s.repo.FindByID(hospital, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Patient{}, ErrPatientNotFound
+	}
+	return p, err
+}
+
+func maskPatient(masks masking.Policies, actor model.Actor, p model.Patient) model.Patient {
+	if policy := masks.For(actor.Hospital); policy.Masks(actor.Role) {
+		return policy.Apply(p)
+	}
+	return p
+}
+
+func maskPatients(masks masking.Policies, actor model.Actor, patients []model.Patient) []model.Patient {
+	out := make([]model.Patient, len(patients))
+	for i, p := range patients {
+		out[i] = maskPatient(masks, actor, p)
+	}
+	return out
+}
+
+// findLive is find for patients that have not been merged into another,
+// erased or deleted.
+func (s *patientService) findLive(hospital string, id int64) (model.Patient, error) {
+	p, err := s.find(hospital, id)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	if p.ErasedAt != nil || p.DeletedAt != nil {
+		return model.Patient{}, ErrPatientNotFound
+	}
+	if p.MergedInto != nil {
+		return model.Patient{ID: p.ID, Hospital: p.Hospital, MergedInto: p.MergedInto}, ErrPatientMerged
+	}
+	return p, nil
+}
+
+// applyPatch merges patch into a deep copy of p, so p itself is not modified
+// through its pointer fields.
+func applyPatch(p model.Patient, patch json.RawMessage) (model.Patient, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return model.Patient{}, err
+	}
+	var out model.Patient
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return model.Patient{}, err
+	}
+	if err := json.Unmarshal(patch, &out); err != nil {
+		return model.Patient{}, err
+	}
+	return out, nil
+}
+
+func (s *patientService) recordAccess(actor model.Actor, action string, criteria any, patients []model.Patient) error {
+	return recordPatientAccess(s.audit, actor, action, criteria, patients)
+}
+
+func recordPatientAccess(audit repository.AuditRepository, actor model.Actor, action string, criteria any, patients []model.Patient) error {
+	if err := writeAudit(audit, actor, action, criteria, patients); err != nil {
+		return errors.Join(ErrAuditFailed, err)
+	}
+	return nil
+}
+
+// recordChange audits a change that has already been committed. Failing the
+// request at this point would report an error for a change that persisted, so
+// a failed audit write is logged instead.
+func recordChange(audit repository.AuditRepository, actor model.Actor, action string, criteria any, patients []model.Patient) {
+	if err := writeAudit(audit, actor, action, criteria, patients); err != nil {
+		log.Printf("audit: %s by staff %d (request %s) not recorded: %v", action, actor.StaffID, actor.RequestID, err)
+	}
+}
+
+func writeAudit(audit repository.AuditRepository, actor model.Actor, action string, criteria any, patients []model.Patient) error {
+	raw, err := json.Marshal(criteria)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, len(patients))
+	for i, p := range patients {
+		ids[i] = p.ID
+	}
+	return audit.Record(model.AuditEntry{
+		StaffID:    actor.StaffID,
+		Hospital:   actor.Hospital,
+		Action:     action,
+		Criteria:   raw,
+		PatientIDs: ids,
+		ClientIP:   actor.ClientIP,
+		RequestID:  actor.RequestID,
+	})
+}
+
+func (s *patientService) search(ctx context.Context, hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+	lookup := hisLookup{status: model.HISStatusSkipped}
 	if (c.NationalID != nil && strings.TrimSpace(*c.NationalID) != "") || (c.PassportID != nil && strings.TrimSpace(*c.PassportID) != "") {
 		_, found, err := s.repo.FindByIdentifier(hospital, c.NationalID, c.PassportID)
 		if err != nil {
-			return nil, err
+			return model.PatientPage{}, err
 		}
 		if !found {
-			id := ""
-			if c.NationalID != nil && strings.TrimSpace(*c.NationalID) != "" {
-				id = strings.TrimSpace(*c.NationalID)
-			} else if c.PassportID != nil {
-				id = strings.TrimSpace(*c.PassportID)
-			}
-			if id != "" {
-				if externalPatient, err := s.hisClient.FetchByID(id); err == nil {
-					externalPatient.Hospital = hospital
-					_, _ = s.repo.UpsertByNationalOrPassport(hospital, externalPatient)
-				}
-			}
+			lookup = s.fetchFromHIS(ctx, hospital, c)
+		}
+	}
+	if c.HISStrict && lookup.status == model.HISStatusError {
+		return model.PatientPage{}, lookup.strictError()
+	}
+
+	page, err := s.repo.SearchByHospital(hospital, c)
+	if err != nil {
+		return model.PatientPage{}, err
+	}
+	page.HISStatus, page.HISError = lookup.status, lookup.reason
+	for i := range page.Patients {
+		page.Patients[i].Source = model.PatientSourceLocal
+		if lookup.status == model.HISStatusHit && page.Patients[i].ID == lookup.patientID {
+			page.Patients[i].Source = model.PatientSourceHIS
 		}
 	}
+	return page, nil
+}
+
+// hisLookup is the outcome of asking the HIS for a patient. reason says why
+// it failed, in words fit for staff; err is the cause.
+type hisLookup struct {
+	status    string
+	reason    string
+	err       error
+	patientID int64
+}
+
+func hisFailure(reason string, err error) hisLookup {
+	return hisLookup{status: model.HISStatusError, reason: reason, err: err}
+}
+
+// strictError is the error of a failed lookup in a strict search.
+func (l hisLookup) strictError() error {
+	var storeErr *hisStoreError
+	switch {
+	case errors.As(l.err, &storeErr):
+		return storeErr.err
+	case errors.Is(l.err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %s", ErrHISTimeout, l.reason)
+	}
+	return fmt.Errorf("%w: %s", ErrHISUnavailable, l.reason)
+}
+
+// hisStoreError is a patient the HIS returned that could not be stored
+// locally; it is our failure, not the HIS's.
+type hisStoreError struct{ err error }
+
+func (e *hisStoreError) Error() string { return e.err.Error() }
 
-	return s.repo.SearchByHospital(hospital, c)
+func (s *patientService) fetchFromHIS(ctx context.Context, hospital string, c model.PatientSearchCriteria) hisLookup {
+	hisClient, ok := s.his.ClientFor(hospital)
+	if !ok {
+		return hisLookup{status: model.HISStatusSkipped}
+	}
+	id := ""
+	if c.NationalID != nil && strings.TrimSpace(*c.NationalID) != "" {
+		id = strings.TrimSpace(*c.NationalID)
+	} else if c.PassportID != nil {
+		id = strings.TrimSpace(*c.PassportID)
+	}
+	if id == "" {
+		return hisLookup{status: model.HISStatusSkipped}
+	}
+	return s.sharedLookup(ctx, hisClient, hospital, id)
+}
+
+// sharedLookup is lookupHIS shared by concurrent callers for the same
+// patient: searches and refreshes. It runs detached from the caller that
+// started it, so that caller going away does not fail the others; the HIS
+// client's timeouts still bound it.
+func (s *patientService) sharedLookup(ctx context.Context, hisClient his.Client, hospital, id string) hisLookup {
+	ch := s.lookups.DoChan(hospital+"\x00"+id, func() (any, error) {
+		return s.lookupHIS(context.WithoutCancel(ctx), hisClient, hospital, id), nil
+	})
+	select {
+	case res := <-ch:
+		return res.Val.(hisLookup)
+	case <-ctx.Done():
+		return hisFailure("search was cancelled before the HIS answered", ctx.Err())
+	}
+}
+
+// lookupHIS fetches a patient from the HIS and stores it.
+func (s *patientService) lookupHIS(ctx context.Context, hisClient his.Client, hospital, id string) hisLookup {
+	externalPatient, err := hisClient.FetchByID(ctx, id)
+	var statusErr *his.StatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound:
+		return hisLookup{status: model.HISStatusMiss}
+	case errors.Is(err, his.ErrBreakerOpen):
+		return hisFailure("HIS is unavailable, circuit breaker is open", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return hisFailure("HIS did not respond in time", err)
+	case errors.As(err, &statusErr):
+		return hisFailure(fmt.Sprintf("HIS responded with %d", statusErr.Code), err)
+	case err != nil:
+		return hisFailure("HIS could not be reached", err)
+	}
+	if err := normalizeIDs(&externalPatient); err != nil {
+		return hisFailure("HIS returned an invalid record", err)
+	}
+	// A number the HIS sent in a form we cannot parse is kept for display
+	// but is not searchable.
+	_ = normalizePhone(&externalPatient)
+	externalPatient.Hospital = hospital
+	stored, err := s.repo.UpsertByNationalOrPassport(hospital, externalPatient)
+	if err != nil {
+		return hisFailure("HIS record could not be stored", &hisStoreError{err: err})
+	}
+	_ = detectDuplicates(s.duplicates, hospital, stored)
+	return hisLookup{status: model.HISStatusHit, patientID: stored.ID}
 }
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"agnos/internal/model"
+	"agnos/internal/repository"
+)
+
+// History returns the versions of a patient, newest first. The old and new
+// values of each change are masked like the patient itself. Merged patients
+// keep their history.
+func (s *patientService) History(actor model.Actor, id int64) ([]model.PatientVersion, error) {
+	if _, err := s.find(actor.Hospital, id); err != nil {
+		return nil, err
+	}
+	versions, err := s.repo.Versions(actor.Hospital, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recordAccess(actor, model.AuditActionPatientHistory, nil, []model.Patient{{ID: id}}); err != nil {
+		return nil, err
+	}
+	return describeVersions(versions, func(p model.Patient) model.Patient {
+		return maskPatient(s.masks, actor, p)
+	})
+}
+
+// Restore writes an earlier version back to the patient. The restore is itself
+// kept as a new version, so it can be undone the same way. Restoring a deleted
+// patient undeletes it.
+func (s *patientService) Restore(actor model.Actor, id int64, version int) (model.Patient, error) {
+	current, err := s.find(actor.Hospital, id)
+	switch {
+	case err != nil:
+		return model.Patient{}, err
+	case current.ErasedAt != nil:
+		return model.Patient{}, ErrPatientNotFound
+	case current.MergedInto != nil:
+		return model.Patient{ID: current.ID, Hospital: current.Hospital, MergedInto: current.MergedInto}, ErrPatientMerged
+	}
+	stored, err := s.repo.Restore(actor.Hospital, id, version, actor.StaffID)
+	switch {
+	case errors.Is(err, repository.ErrPatientConflict):
+		return model.Patient{}, ErrPatientConflict
+	case errors.Is(err, sql.ErrNoRows):
+		return model.Patient{}, ErrVersionNotFound
+	case err != nil:
+		return model.Patient{}, err
+	}
+	_ = detectDuplicates(s.duplicates, actor.Hospital, stored)
+	recordChange(s.audit, actor, model.AuditActionPatientRestore, map[string]int{"version": version}, []model.Patient{stored})
+	return maskPatient(s.masks, actor, stored), nil
+}
+
+// describeVersions fills in the changes of each version, oldest first, from
+// the records passed through mask, and returns the versions newest first.
+func describeVersions(versions []model.PatientVersion, mask func(model.Patient) model.Patient) ([]model.PatientVersion, error) {
+	out := make([]model.PatientVersion, len(versions))
+	var before map[string]any
+	for i, v := range versions {
+		after, err := displayFields(mask(v.Record))
+		if err != nil {
+			return nil, err
+		}
+		v.Changes = make(map[string]model.FieldChange, len(v.Fields))
+		for _, f := range v.Fields {
+			v.Changes[f] = model.FieldChange{From: before[f], To: after[f]}
+		}
+		out[len(versions)-1-i] = v
+		before = after
+	}
+	return out, nil
+}
+
+func displayFields(p model.Patient) (map[string]any, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	return out, json.Unmarshal(raw, &out)
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"agnos/internal/his"
+	"agnos/internal/model"
+)
+
+// maxBackgroundRefreshes bounds the refreshes started by reads that run at
+// once. A stale patient found while all are busy is left to the sync worker.
+const maxBackgroundRefreshes = 4
+
+// revalidate refreshes, in the background, the patients whose last sync with
+// the HIS is older than the hospital's sync TTL. Patients entered by staff
+// were never synced and are left alone.
+func (s *patientService) revalidate(hospital string, patients []model.Patient) {
+	ttl := s.his.SyncTTL(hospital)
+	if ttl <= 0 {
+		return
+	}
+	hisClient, ok := s.his.ClientFor(hospital)
+	if !ok {
+		return
+	}
+	cutoff := time.Now().Add(-ttl)
+	for _, p := range patients {
+		if p.LastSyncedAt == nil || p.LastSyncedAt.After(cutoff) {
+			continue
+		}
+		select {
+		case s.refreshing <- struct{}{}:
+		default:
+			return
+		}
+		go func() {
+			defer func() { <-s.refreshing }()
+			_ = s.refresh(context.Background(), hisClient, hospital, p)
+		}()
+	}
+}
+
+// RefreshStale syncs again, for every hospital with a sync TTL, up to batch
+// patients last synced longer ago than the TTL, least recently synced first.
+// It waits pause between HIS calls and stops a hospital's pass when its HIS
+// becomes unavailable.
+func (s *patientService) RefreshStale(ctx context.Context, batch int, pause time.Duration) ([]model.HISSyncRun, error) {
+	var runs []model.HISSyncRun
+	for _, hospital := range s.his.Hospitals() {
+		ttl := s.his.SyncTTL(hospital)
+		hisClient, ok := s.his.ClientFor(hospital)
+		if ttl <= 0 || !ok {
+			continue
+		}
+		patients, err := s.repo.StaleSynced(hospital, time.Now().Add(-ttl), batch)
+		if err != nil {
+			return runs, err
+		}
+		run := model.HISSyncRun{Hospital: hospital}
+		for i, p := range patients {
+			if i > 0 {
+				if err := sleepContext(ctx, pause); err != nil {
+					return append(runs, run), err
+				}
+			}
+			lookup := s.refresh(ctx, hisClient, hospital, p)
+			run.Checked++
+			switch lookup.status {
+			case model.HISStatusHit:
+				run.Refreshed++
+			case model.HISStatusMiss:
+				run.Missing++
+			case model.HISStatusError:
+				run.Failed++
+			}
+			if errors.Is(lookup.err, his.ErrBreakerOpen) {
+				run.Stopped = true
+				break
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// refresh looks the patient up in the HIS again by national ID or passport.
+// A patient the HIS no longer has, or that has neither identifier any more,
+// is kept as it is and marked as checked, so it is not retried before the TTL
+// passes again.
+func (s *patientService) refresh(ctx context.Context, hisClient his.Client, hospital string, p model.Patient) hisLookup {
+	id := ""
+	if p.NationalID != nil && strings.TrimSpace(*p.NationalID) != "" {
+		id = *p.NationalID
+	} else if p.PassportID != nil {
+		id = *p.PassportID
+	}
+	lookup := hisLookup{status: model.HISStatusSkipped}
+	if id != "" {
+		lookup = s.sharedLookup(ctx, hisClient, hospital, id)
+	}
+	if lookup.status == model.HISStatusMiss || lookup.status == model.HISStatusSkipped {
+		if err := s.repo.MarkSynced(hospital, p.ID); err != nil {
+			return hisFailure("patient could not be marked as synced", err)
+		}
+	}
+	return lookup
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"agnos/internal/his"
+	"agnos/internal/masking"
+	"agnos/internal/model"
+)
+
+var testActor = model.Actor{StaffID: 1, Hospital: "A", Role: "admin", RequestID: "req-1"}
+
+func strPtr(s string) *string { return &s }
+
+func newTestPatient(nationalID string) model.Patient {
+	return model.Patient{FirstNameEN: strPtr("John"), LastNameEN: strPtr("Doe"), NationalID: strPtr(nationalID)}
+}
+
+func newTestPatientService(repo *memPatients, audit *memAudit, registry his.Registry) PatientService {
+	if registry == nil {
+		registry = his.NewStaticRegistry(nil)
+	}
+	return NewPatientService(repo, audit, registry, &memMPI{patients: repo}, masking.Policies{})
+}
+
+func TestCommittedChangesSurviveAuditFailure(t *testing.T) {
+	repo, audit := newMemPatients(), &memAudit{err: errors.New("audit log down")}
+	patients := newTestPatientService(repo, audit, nil)
+
+	created, err := patients.Create(testActor, newTestPatient("1234567890121"))
+	if err != nil || created.ID == 0 {
+		t.Fatalf("expected the created patient despite the audit failure, got %+v (%v)", created, err)
+	}
+	updated, err := patients.Update(testActor, created.ID, json.RawMessage(`{"first_name_en":"Jon"}`))
+	if err != nil || *updated.FirstNameEN != "Jon" {
+		t.Fatalf("expected the updated patient despite the audit failure, got %+v (%v)", updated, err)
+	}
+
+	other, err := patients.Create(testActor, newTestPatient("1101700230708"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	merges := NewMPIService(&memMPI{patients: repo}, repo, audit, masking.Policies{})
+	m, err := merges.Merge(testActor, created.ID, other.ID, "same person")
+	if err != nil || m.ID == 0 {
+		t.Fatalf("expected the merge despite the audit failure, got %+v (%v)", m, err)
+	}
+	if _, err := merges.Unmerge(testActor, m.ID); err != nil {
+		t.Fatalf("expected the unmerge despite the audit failure, got %v", err)
+	}
+
+	if err := patients.Delete(testActor, created.ID); err != nil {
+		t.Fatalf("expected the delete despite the audit failure, got %v", err)
+	}
+	if repo.patients[created.ID].DeletedAt == nil {
+		t.Fatalf("expected the patient to be deleted")
+	}
+
+	if _, err := patients.Search(context.Background(), testActor, model.PatientSearchCriteria{NationalID: strPtr("1101700230708")}); !errors.Is(err, ErrAuditFailed) {
+		t.Fatalf("expected a read to fail without its audit entry, got %v", err)
+	}
+}
+
+func TestDeletedPatientReleasesIdentifiers(t *testing.T) {
+	repo, audit := newMemPatients(), &memAudit{}
+	patients := newTestPatientService(repo, audit, nil)
+
+	deleted, err := patients.Create(testActor, newTestPatient("1234567890121"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := patients.Delete(testActor, deleted.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	again, err := patients.Create(testActor, newTestPatient("1234567890121"))
+	if err != nil {
+		t.Fatalf("expected the national ID of a deleted patient to be free, got %v", err)
+	}
+	if _, err := patients.Restore(testActor, deleted.ID, 1); !errors.Is(err, ErrPatientConflict) {
+		t.Fatalf("expected restoring the deleted patient to conflict, got %v", err)
+	}
+
+	if err := patients.Delete(testActor, again.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := patients.Restore(testActor, deleted.ID, 1); err != nil {
+		t.Fatalf("expected the restore to succeed once the identifier is free, got %v", err)
+	}
+}
+
+type fakeHIS struct {
+	patient model.Patient
+	err     error
+	calls   int
+}
+
+func (f *fakeHIS) FetchByID(ctx context.Context, id string) (model.Patient, error) {
+	f.calls++
+	return f.patient, f.err
+}
+
+func TestSearchReportsHISOutcome(t *testing.T) {
+	byID := model.PatientSearchCriteria{NationalID: strPtr("1234567890121")}
+	cases := []struct {
+		name     string
+		client   *fakeHIS
+		criteria model.PatientSearchCriteria
+		status   string
+		found    bool
+	}{
+		{"hit", &fakeHIS{patient: newTestPatient("1234567890121")}, byID, model.HISStatusHit, true},
+		{"miss", &fakeHIS{err: &his.StatusError{Hospital: "A", Code: 404}}, byID, model.HISStatusMiss, false},
+		{"error", &fakeHIS{err: &his.StatusError{Hospital: "A", Code: 500}}, byID, model.HISStatusError, false},
+		{"breaker open", &fakeHIS{err: his.ErrBreakerOpen}, byID, model.HISStatusError, false},
+		{"no identifier", &fakeHIS{}, model.PatientSearchCriteria{FirstName: strPtr("John")}, model.HISStatusSkipped, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMemPatients()
+			registry := his.NewStaticRegistry(map[string]his.Client{"A": tc.client})
+			patients := newTestPatientService(repo, &memAudit{}, registry)
+
+			page, err := patients.Search(context.Background(), testActor, tc.criteria)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			if page.HISStatus != tc.status {
+				t.Fatalf("expected HIS status %q, got %q (%s)", tc.status, page.HISStatus, page.HISError)
+			}
+			if tc.status == model.HISStatusError && page.HISError == "" {
+				t.Fatalf("expected a reason for the HIS error")
+			}
+			if tc.found != (len(page.Patients) == 1) {
+				t.Fatalf("expected found=%v, got %d patients", tc.found, len(page.Patients))
+			}
+			if tc.found && page.Patients[0].Source != model.PatientSourceHIS {
+				t.Fatalf("expected the fetched patient to come from the HIS, got %q", page.Patients[0].Source)
+			}
+		})
+	}
+}
+
+func TestSearchSkipsHISForStoredPatients(t *testing.T) {
+	repo := newMemPatients()
+	client := &fakeHIS{err: errors.New("should not be called")}
+	patients := newTestPatientService(repo, &memAudit{}, his.NewStaticRegistry(map[string]his.Client{"A": client}))
+	if _, err := patients.Create(testActor, newTestPatient("1234567890121")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	page, err := patients.Search(context.Background(), testActor, model.PatientSearchCriteria{NationalID: strPtr("1234567890121")})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if page.HISStatus != model.HISStatusSkipped || client.calls != 0 {
+		t.Fatalf("expected the HIS to be skipped, got %q after %d calls", page.HISStatus, client.calls)
+	}
+	if len(page.Patients) != 1 || page.Patients[0].Source != model.PatientSourceLocal {
+		t.Fatalf("expected the stored patient from the local store, got %+v", page.Patients)
+	}
+}
+
+func TestStrictSearchFailsWhenHISFails(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"unavailable", &his.StatusError{Hospital: "A", Code: 503}, ErrHISUnavailable},
+		{"timeout", context.DeadlineExceeded, ErrHISTimeout},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := his.NewStaticRegistry(map[string]his.Client{"A": &fakeHIS{err: tc.err}})
+			patients := newTestPatientService(newMemPatients(), &memAudit{}, registry)
+			c := model.PatientSearchCriteria{NationalID: strPtr("1234567890121"), HISStrict: true}
+			if _, err := patients.Search(context.Background(), testActor, c); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"agnos/internal/identifier"
+	"agnos/internal/model"
+	"agnos/internal/phone"
+)
+
+var ErrInvalidPatient = errors.New("invalid patient")
+
+var (
+	passportIDPattern = regexp.MustCompile(`^[A-Z0-9]{6,20}$`)
+)
+
+// normalizePatient trims every field, turns blanks into nil and checks the
+// record. It returns an error wrapping ErrInvalidPatient that names the first
+// invalid field.
+func normalizePatient(p *model.Patient) error {
+	for _, f := range []**string{
+		&p.FirstNameTH, &p.MiddleNameTH, &p.LastNameTH,
+		&p.FirstNameEN, &p.MiddleNameEN, &p.LastNameEN,
+		&p.PatientHN, &p.NationalID, &p.PassportID, &p.PhoneNumber, &p.Email, &p.Gender,
+	} {
+		if *f == nil {
+			continue
+		}
+		v := strings.TrimSpace(**f)
+		if v == "" {
+			*f = nil
+			continue
+		}
+		*f = &v
+	}
+	upper(p.Gender)
+
+	if (p.FirstNameTH == nil || p.LastNameTH == nil) && (p.FirstNameEN == nil || p.LastNameEN == nil) {
+		return invalidPatient("first and last name are required in Thai or English")
+	}
+	if err := normalizeIDs(p); err != nil {
+		return invalidPatient(err.Error())
+	}
+	if p.NationalID == nil && p.PassportID == nil {
+		return invalidPatient("national_id or passport_id is required")
+	}
+	if p.PassportID != nil && !passportIDPattern.MatchString(*p.PassportID) {
+		return invalidPatient("passport_id must be 6-20 letters or digits")
+	}
+	if err := normalizePhone(p); err != nil {
+		return invalidPatient("phone_number is not a valid phone number")
+	}
+	if p.Email != nil {
+		if addr, err := mail.ParseAddress(*p.Email); err != nil || addr.Address != *p.Email {
+			return invalidPatient("email is invalid")
+		}
+	}
+	if p.Gender != nil && *p.Gender != "M" && *p.Gender != "F" {
+		return invalidPatient("gender must be M or F")
+	}
+	if p.DateOfBirth != nil {
+		dob := time.Date(p.DateOfBirth.Year(), p.DateOfBirth.Month(), p.DateOfBirth.Day(), 0, 0, 0, 0, time.UTC)
+		if dob.After(time.Now()) {
+			return invalidPatient("date_of_birth is in the future")
+		}
+		p.DateOfBirth = &dob
+	}
+	return nil
+}
+
+// normalizeIDs puts the national and passport IDs of p into canonical form,
+// clearing blank ones.
+func normalizeIDs(p *model.Patient) error {
+	var err error
+	p.NationalID, p.PassportID, err = canonicalIDs(p.NationalID, p.PassportID)
+	return err
+}
+
+// normalizePhone sets the E.164 form of p's phone number, keeping the number
+// as written for display.
+func normalizePhone(p *model.Patient) error {
+	p.PhoneE164 = nil
+	if p.PhoneNumber == nil {
+		return nil
+	}
+	e164, err := phone.Normalize(*p.PhoneNumber, phone.DefaultRegion)
+	if err != nil {
+		return err
+	}
+	p.PhoneE164 = &e164
+	return nil
+}
+
+func normalizeSearchIDs(c *model.PatientSearchCriteria) error {
+	var err error
+	c.NationalID, c.PassportID, err = canonicalIDs(c.NationalID, c.PassportID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSearch, err)
+	}
+	return nil
+}
+
+func canonicalIDs(nationalID, passportID *string) (*string, *string, error) {
+	if nationalID != nil {
+		if strings.TrimSpace(*nationalID) == "" {
+			nationalID = nil
+		} else {
+			id, err := identifier.NationalID(*nationalID)
+			if err != nil {
+				return nil, nil, err
+			}
+			nationalID = &id
+		}
+	}
+	if passportID != nil {
+		if id := identifier.Passport(*passportID); id == "" {
+			passportID = nil
+		} else {
+			passportID = &id
+		}
+	}
+	return nationalID, passportID, nil
+}
+
+func upper(v *string) {
+	if v != nil {
+		*v = strings.ToUpper(*v)
+	}
+}
+
+func invalidPatient(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPatient, reason)
+}
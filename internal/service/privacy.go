@@ -0,0 +1,294 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"agnos/internal/model"
+	"agnos/internal/repository"
+)
+
+// DefaultPrivacyRequestDue is the PDPA deadline for answering a data subject
+// request.
+const DefaultPrivacyRequestDue = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidPrivacyRequest  = errors.New("invalid privacy request")
+	ErrPrivacyRequestNotFound = errors.New("privacy request not found")
+	ErrPrivacyRequestClosed   = errors.New("privacy request is already closed")
+	ErrLegalHold              = errors.New("patient is under legal hold")
+	ErrLegalHoldNotFound      = errors.New("legal hold not found")
+)
+
+// PrivacyService handles PDPA data subject requests: exporting everything
+// stored about a patient and erasing it, subject to legal holds.
+type PrivacyService interface {
+	CreateRequest(actor model.Actor, patientID int64, kind, note string) (model.PrivacyRequest, error)
+	Requests(actor model.Actor, status string) ([]model.PrivacyRequest, error)
+	Request(actor model.Actor, id int64) (model.PrivacyRequest, error)
+	Export(actor model.Actor, id int64) (model.PrivacyRequest, model.PatientExport, error)
+	Erase(actor model.Actor, id int64) (model.PrivacyRequest, error)
+	Reject(actor model.Actor, id int64, reason string) (model.PrivacyRequest, error)
+	PlaceHold(actor model.Actor, patientID int64, reason string) (model.LegalHold, error)
+	ReleaseHold(actor model.Actor, id int64) (model.LegalHold, error)
+	Holds(actor model.Actor, patientID int64) ([]model.LegalHold, error)
+}
+
+type privacyService struct {
+	repo     repository.PrivacyRepository
+	patients repository.PatientRepository
+	merges   repository.MPIRepository
+	audit    repository.AuditRepository
+	due      time.Duration
+}
+
+// NewPrivacyService returns the privacy service. New requests are due after
+// due, or DefaultPrivacyRequestDue if due is not positive.
+func NewPrivacyService(repo repository.PrivacyRepository, patients repository.PatientRepository, merges repository.MPIRepository, audit repository.AuditRepository, due time.Duration) PrivacyService {
+	if due <= 0 {
+		due = DefaultPrivacyRequestDue
+	}
+	return &privacyService{repo: repo, patients: patients, merges: merges, audit: audit, due: due}
+}
+
+func (s *privacyService) CreateRequest(actor model.Actor, patientID int64, kind, note string) (model.PrivacyRequest, error) {
+	if kind != model.PrivacyRequestExport && kind != model.PrivacyRequestErasure {
+		return model.PrivacyRequest{}, fmt.Errorf("%w: kind must be %q or %q", ErrInvalidPrivacyRequest, model.PrivacyRequestExport, model.PrivacyRequestErasure)
+	}
+	p, err := s.patients.FindByID(actor.Hospital, patientID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return model.PrivacyRequest{}, ErrPatientNotFound
+	case err != nil:
+		return model.PrivacyRequest{}, err
+	case p.ErasedAt != nil:
+		return model.PrivacyRequest{}, ErrPatientNotFound
+	case p.MergedInto != nil:
+		return model.PrivacyRequest{}, ErrPatientMerged
+	}
+	req, err := s.repo.CreateRequest(model.PrivacyRequest{
+		Hospital:    actor.Hospital,
+		PatientID:   patientID,
+		Kind:        kind,
+		Note:        strings.TrimSpace(note),
+		RequestedBy: actor.StaffID,
+		DueAt:       time.Now().UTC().Add(s.due),
+	})
+	return withOverdue(req), err
+}
+
+// Requests lists the requests with the given status, or all of them, by due
+// date.
+func (s *privacyService) Requests(actor model.Actor, status string) ([]model.PrivacyRequest, error) {
+	switch status {
+	case "", model.PrivacyRequestPending, model.PrivacyRequestCompleted, model.PrivacyRequestRejected:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidPrivacyRequest, status)
+	}
+	requests, err := s.repo.ListRequests(actor.Hospital, status)
+	if err != nil {
+		return nil, err
+	}
+	for i := range requests {
+		requests[i] = withOverdue(requests[i])
+	}
+	return requests, nil
+}
+
+func (s *privacyService) Request(actor model.Actor, id int64) (model.PrivacyRequest, error) {
+	req, err := s.repo.FindRequest(actor.Hospital, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.PrivacyRequest{}, ErrPrivacyRequestNotFound
+	}
+	return withOverdue(req), err
+}
+
+// Export completes an export request with a document of everything stored
+// about the patient: the record, the records merged into it, their versions
+// with full values, the merges and every audit entry referring to them.
+func (s *privacyService) Export(actor model.Actor, id int64) (model.PrivacyRequest, model.PatientExport, error) {
+	req, err := s.pending(actor, id, model.PrivacyRequestExport)
+	if err != nil {
+		return model.PrivacyRequest{}, model.PatientExport{}, err
+	}
+	p, err := s.patients.FindByID(actor.Hospital, req.PatientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.PrivacyRequest{}, model.PatientExport{}, ErrPatientNotFound
+	}
+	if err != nil {
+		return model.PrivacyRequest{}, model.PatientExport{}, err
+	}
+	merged, err := s.repo.MergedRecords(actor.Hospital, p.ID)
+	if err != nil {
+		return model.PrivacyRequest{}, model.PatientExport{}, err
+	}
+	doc := model.PatientExport{
+		GeneratedAt:   time.Now().UTC(),
+		RequestID:     req.ID,
+		Patient:       p,
+		MergedRecords: merged,
+		Versions:      []model.PatientVersion{},
+		AuditEntries:  []model.AuditEntry{},
+	}
+	if doc.Merges, err = s.merges.Merges(actor.Hospital, p.ID); err != nil {
+		return model.PrivacyRequest{}, model.PatientExport{}, err
+	}
+	subjects := append([]model.Patient{p}, merged...)
+	for _, subject := range subjects {
+		versions, err := s.patients.Versions(actor.Hospital, subject.ID)
+		if err != nil {
+			return model.PrivacyRequest{}, model.PatientExport{}, err
+		}
+		described, err := describeVersions(versions, func(p model.Patient) model.Patient { return p })
+		if err != nil {
+			return model.PrivacyRequest{}, model.PatientExport{}, err
+		}
+		doc.Versions = append(doc.Versions, described...)
+
+		subjectID := subject.ID
+		entries, err := s.audit.List(actor.Hospital, model.AuditFilter{PatientID: &subjectID})
+		if err != nil {
+			return model.PrivacyRequest{}, model.PatientExport{}, err
+		}
+		doc.AuditEntries = append(doc.AuditEntries, entries...)
+	}
+
+	// The export is audited before the request is closed, so a closed
+	// request always has its audit entry.
+	if err := recordPatientAccess(s.audit, actor, model.AuditActionPatientExport, map[string]int64{"request_id": req.ID}, subjects); err != nil {
+		return model.PrivacyRequest{}, model.PatientExport{}, err
+	}
+	if req, err = s.repo.CompleteExport(actor.Hospital, req.ID, actor.StaffID); err != nil {
+		return model.PrivacyRequest{}, model.PatientExport{}, closeError(err)
+	}
+	return withOverdue(req), doc, nil
+}
+
+// Erase completes an erasure request by anonymizing the patient and the
+// records merged into it. The audit log is kept as it is: it is append-only
+// and retained to meet legal obligations.
+func (s *privacyService) Erase(actor model.Actor, id int64) (model.PrivacyRequest, error) {
+	req, err := s.pending(actor, id, model.PrivacyRequestErasure)
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	req, erased, err := s.repo.CompleteErasure(actor.Hospital, req.ID, actor.StaffID)
+	switch {
+	case errors.Is(err, repository.ErrLegalHold):
+		return model.PrivacyRequest{}, ErrLegalHold
+	case errors.Is(err, sql.ErrNoRows):
+		return model.PrivacyRequest{}, ErrPatientNotFound
+	case err != nil:
+		return model.PrivacyRequest{}, closeError(err)
+	}
+	patients := make([]model.Patient, len(erased))
+	for i, id := range erased {
+		patients[i] = model.Patient{ID: id}
+	}
+	recordChange(s.audit, actor, model.AuditActionPatientErase, map[string]int64{"request_id": req.ID}, patients)
+	return withOverdue(req), nil
+}
+
+func (s *privacyService) Reject(actor model.Actor, id int64, reason string) (model.PrivacyRequest, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return model.PrivacyRequest{}, ErrReasonRequired
+	}
+	req, err := s.repo.RejectRequest(actor.Hospital, id, actor.StaffID, reason)
+	if err != nil {
+		return model.PrivacyRequest{}, closeError(err)
+	}
+	return withOverdue(req), nil
+}
+
+func (s *privacyService) PlaceHold(actor model.Actor, patientID int64, reason string) (model.LegalHold, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return model.LegalHold{}, ErrReasonRequired
+	}
+	if err := s.checkHoldPatient(actor.Hospital, patientID); err != nil {
+		return model.LegalHold{}, err
+	}
+	h, err := s.repo.PlaceHold(model.LegalHold{Hospital: actor.Hospital, PatientID: patientID, Reason: reason, PlacedBy: actor.StaffID})
+	if err != nil {
+		return model.LegalHold{}, err
+	}
+	recordChange(s.audit, actor, model.AuditActionHoldPlace, map[string]any{"hold_id": h.ID, "reason": h.Reason}, []model.Patient{{ID: patientID}})
+	return h, nil
+}
+
+func (s *privacyService) ReleaseHold(actor model.Actor, id int64) (model.LegalHold, error) {
+	h, err := s.repo.FindHold(actor.Hospital, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return model.LegalHold{}, ErrLegalHoldNotFound
+	case err != nil:
+		return model.LegalHold{}, err
+	}
+	if err := s.checkHoldPatient(actor.Hospital, h.PatientID); err != nil {
+		return model.LegalHold{}, err
+	}
+	h, err = s.repo.ReleaseHold(actor.Hospital, id, actor.StaffID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return model.LegalHold{}, ErrLegalHoldNotFound
+	case err != nil:
+		return model.LegalHold{}, err
+	}
+	recordChange(s.audit, actor, model.AuditActionHoldRelease, map[string]int64{"hold_id": h.ID}, []model.Patient{{ID: h.PatientID}})
+	return h, nil
+}
+
+// checkHoldPatient refuses holds on patients that are gone: erased or deleted
+// patients are not found, merged ones are held through their survivor.
+func (s *privacyService) checkHoldPatient(hospital string, patientID int64) error {
+	p, err := s.patients.FindByID(hospital, patientID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrPatientNotFound
+	case err != nil:
+		return err
+	case p.ErasedAt != nil, p.DeletedAt != nil:
+		return ErrPatientNotFound
+	case p.MergedInto != nil:
+		return ErrPatientMerged
+	}
+	return nil
+}
+
+func (s *privacyService) Holds(actor model.Actor, patientID int64) ([]model.LegalHold, error) {
+	return s.repo.Holds(actor.Hospital, patientID)
+}
+
+// pending returns request id if it is a pending request of kind.
+func (s *privacyService) pending(actor model.Actor, id int64, kind string) (model.PrivacyRequest, error) {
+	req, err := s.Request(actor, id)
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	if req.Kind != kind {
+		return model.PrivacyRequest{}, fmt.Errorf("%w: request %d is an %s request", ErrInvalidPrivacyRequest, id, req.Kind)
+	}
+	if req.Status != model.PrivacyRequestPending {
+		return model.PrivacyRequest{}, ErrPrivacyRequestClosed
+	}
+	return req, nil
+}
+
+func closeError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrPrivacyRequestClosed):
+		return ErrPrivacyRequestClosed
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrPrivacyRequestNotFound
+	}
+	return err
+}
+
+func withOverdue(req model.PrivacyRequest) model.PrivacyRequest {
+	req.Overdue = req.Status == model.PrivacyRequestPending && time.Now().After(req.DueAt)
+	return req
+}
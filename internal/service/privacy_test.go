@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"agnos/internal/masking"
+	"agnos/internal/model"
+)
+
+func TestErasureRespectsLegalHolds(t *testing.T) {
+	repo, audit := newMemPatients(), &memAudit{}
+	patients := newTestPatientService(repo, audit, nil)
+	merges := NewMPIService(&memMPI{patients: repo}, repo, audit, masking.Policies{})
+	store := &memPrivacy{patients: repo}
+	privacy := NewPrivacyService(store, repo, &memMPI{patients: repo}, audit, 0)
+
+	survivor, err := patients.Create(testActor, newTestPatient("1234567890121"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	dup, err := patients.Create(testActor, newTestPatient("1101700230708"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := merges.Merge(testActor, survivor.ID, dup.ID, "same person"); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if _, err := privacy.PlaceHold(testActor, dup.ID, "litigation"); !errors.Is(err, ErrPatientMerged) {
+		t.Fatalf("expected a hold on a merged patient to be refused, got %v", err)
+	}
+
+	hold, err := privacy.PlaceHold(testActor, survivor.ID, "litigation")
+	if err != nil {
+		t.Fatalf("place hold: %v", err)
+	}
+	req, err := privacy.CreateRequest(testActor, survivor.ID, model.PrivacyRequestErasure, "")
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	if _, err := privacy.Erase(testActor, req.ID); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("expected the erasure to be blocked by the hold, got %v", err)
+	}
+	if got, _ := privacy.Request(testActor, req.ID); got.Status != model.PrivacyRequestPending {
+		t.Fatalf("expected the request to stay pending, got %q", got.Status)
+	}
+	if err := patients.Delete(testActor, survivor.ID); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("expected the delete to be blocked by the hold, got %v", err)
+	}
+
+	if _, err := privacy.ReleaseHold(testActor, hold.ID); err != nil {
+		t.Fatalf("release hold: %v", err)
+	}
+	if _, err := privacy.ReleaseHold(testActor, hold.ID); !errors.Is(err, ErrLegalHoldNotFound) {
+		t.Fatalf("expected a released hold not to be found, got %v", err)
+	}
+	closed, err := privacy.Erase(testActor, req.ID)
+	if err != nil || closed.Status != model.PrivacyRequestCompleted {
+		t.Fatalf("expected the erasure to complete, got %+v (%v)", closed, err)
+	}
+	for _, id := range []int64{survivor.ID, dup.ID} {
+		if p := repo.patients[id]; p.ErasedAt == nil || p.NationalID != nil || p.FirstNameEN != nil {
+			t.Fatalf("expected patient %d to be anonymized, got %+v", id, p)
+		}
+	}
+	if _, err := patients.Get(testActor, survivor.ID); !errors.Is(err, ErrPatientNotFound) {
+		t.Fatalf("expected an erased patient not to be found, got %v", err)
+	}
+	if _, err := privacy.PlaceHold(testActor, survivor.ID, "too late"); !errors.Is(err, ErrPatientNotFound) {
+		t.Fatalf("expected a hold on an erased patient to be refused, got %v", err)
+	}
+	if _, err := privacy.Erase(testActor, req.ID); !errors.Is(err, ErrPrivacyRequestClosed) {
+		t.Fatalf("expected a second erasure to find the request closed, got %v", err)
+	}
+
+	want := []string{
+		model.AuditActionPatientCreate, model.AuditActionPatientCreate, model.AuditActionPatientMerge,
+		model.AuditActionHoldPlace, model.AuditActionHoldRelease, model.AuditActionPatientErase,
+	}
+	if got := audit.actions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected audit actions %v, got %v", want, got)
+	}
+}
@@ -1,63 +1,369 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"agnos/internal/model"
+	"agnos/internal/passwd"
+	"agnos/internal/rbac"
 	"agnos/internal/repository"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
-var ErrInvalidCredentials = errors.New("invalid credentials")
+var (
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrStaffNotFound       = errors.New("staff not found")
+	ErrInvalidStaff        = errors.New("username, password, hospital are required")
+	ErrStaffConflict       = errors.New("a staff member with this username already exists")
+)
 
 type StaffService interface {
-	Create(username, password, hospital string) (model.Staff, error)
-	Login(username, password, hospital string) (string, error)
+	Create(username, password, hospital, role string) (model.Staff, error)
+	BootstrapAdmin(username, password, hospital string) (model.Staff, bool, error)
+	Login(username, password, hospital, clientIP string) (model.LoginResult, error)
+	VerifyMFA(mfaToken, code, recoveryCode, clientIP string) (model.TokenPair, error)
+	EnrollMFA(staffID int64) (model.MFAEnrollment, error)
+	ActivateMFA(staffID int64, code string) ([]string, error)
+	ResetMFA(hospital string, actorID, staffID int64) error
+	MFARequired(hospital string) bool
+	List(hospital string) ([]model.Staff, error)
+	SetActive(hospital string, actorID, staffID int64, active bool) error
+	ChangePassword(staffID int64, oldPassword, newPassword, clientIP string) error
+	ResetPassword(hospital string, actorID, staffID int64, newPassword string) error
+	Refresh(refreshToken string) (model.TokenPair, error)
+	Logout(staffID int64, sessionID, tokenID string, expiresAt time.Time) error
+	RevokeAllSessions(hospital string, staffID int64) error
+	IsRevoked(tokenID, sessionID string, staffID int64, issuedAt time.Time) (bool, error)
+	Unlock(hospital string, actorID, staffID int64) error
+	ListSecurityEvents(hospital string, limit int) ([]model.SecurityEvent, error)
+}
+
+type StaffConfig struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Lockout         LockoutPolicy
+	MFA             MFAConfig
+	PasswordPolicy  passwd.Policy
+	PasswordHash    passwd.Params
+}
+
+type TokenKeys interface {
+	Sign(claims jwt.Claims) (string, error)
+	Keyfunc(token *jwt.Token) (interface{}, error)
+	Methods() []string
 }
 
 type staffService struct {
-	repo      repository.StaffRepository
-	jwtSecret []byte
-	tokenTTL  time.Duration
+	repo       repository.StaffRepository
+	sessions   repository.SessionRepository
+	events     repository.SecurityEventRepository
+	audit      repository.AuditRepository
+	guard      *loginGuard
+	keys       TokenKeys
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	mfa        MFAConfig
+	policy     passwd.Policy
+	hashParams passwd.Params
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewStaffService(
+	repo repository.StaffRepository,
+	sessions repository.SessionRepository,
+	throttles repository.LoginThrottleRepository,
+	events repository.SecurityEventRepository,
+	audit repository.AuditRepository,
+	keys TokenKeys,
+	cfg StaffConfig,
+) StaffService {
+	return &staffService{
+		repo:       repo,
+		sessions:   sessions,
+		events:     events,
+		audit:      audit,
+		guard:      &loginGuard{throttles: throttles, events: events, policy: cfg.Lockout},
+		keys:       keys,
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		mfa:        cfg.MFA,
+		policy:     cfg.PasswordPolicy,
+		hashParams: cfg.PasswordHash,
+	}
+}
+
+func (s *staffService) Create(username, password, hospital, role string) (model.Staff, error) {
+	username, hash, hospital, err := s.prepare(username, password, hospital)
+	if err != nil {
+		return model.Staff{}, err
+	}
+	role = strings.ToLower(strings.TrimSpace(role))
+	if role == "" {
+		role = rbac.DefaultRole
+	}
+	if !rbac.ValidRole(role) {
+		return model.Staff{}, ErrInvalidRole
+	}
+	staff, err := s.repo.Create(username, hash, hospital, role)
+	if errors.Is(err, repository.ErrStaffConflict) {
+		return model.Staff{}, ErrStaffConflict
+	}
+	return staff, err
 }
 
-func NewStaffService(repo repository.StaffRepository, jwtSecret string, tokenTTL time.Duration) StaffService {
-	return &staffService{repo: repo, jwtSecret: []byte(jwtSecret), tokenTTL: tokenTTL}
+// BootstrapAdmin creates the first admin of a hospital. It is a no-op while the
+// hospital has an active admin, so it is safe to run on every startup.
+func (s *staffService) BootstrapAdmin(username, password, hospital string) (model.Staff, bool, error) {
+	username, hash, hospital, err := s.prepare(username, password, hospital)
+	if err != nil {
+		return model.Staff{}, false, err
+	}
+	return s.repo.CreateIfNoAdmin(username, hash, hospital)
 }
 
-func (s *staffService) Create(username, password, hospital string) (model.Staff, error) {
+func (s *staffService) prepare(username, password, hospital string) (string, string, string, error) {
 	username = strings.TrimSpace(username)
 	hospital = strings.TrimSpace(hospital)
 	if username == "" || strings.TrimSpace(password) == "" || hospital == "" {
-		return model.Staff{}, errors.New("username, password, hospital are required")
+		return "", "", "", ErrInvalidStaff
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := s.hashPassword(username, password)
 	if err != nil {
-		return model.Staff{}, err
+		return "", "", "", err
 	}
-	return s.repo.Create(username, string(hash), hospital)
+	return username, hash, hospital, nil
 }
 
-func (s *staffService) Login(username, password, hospital string) (string, error) {
-	user, err := s.repo.FindByUsernameAndHospital(strings.TrimSpace(username), strings.TrimSpace(hospital))
+func (s *staffService) Login(username, password, hospital, clientIP string) (model.LoginResult, error) {
+	username = strings.TrimSpace(username)
+	hospital = strings.TrimSpace(hospital)
+	if err := s.guard.check(username, hospital, clientIP); err != nil {
+		return model.LoginResult{}, s.loginFailed(err, 0, username, hospital, clientIP, "throttled")
+	}
+
+	user, err := s.repo.FindByUsernameAndHospital(username, hospital)
 	if err != nil {
-		return "", ErrInvalidCredentials
+		s.verifyDummy(password)
+		if err := s.guard.fail(username, hospital, clientIP, nil); err != nil {
+			return model.LoginResult{}, s.loginFailed(err, 0, username, hospital, clientIP, "unknown_user")
+		}
+		return model.LoginResult{}, s.loginFailed(ErrInvalidCredentials, 0, username, hospital, clientIP, "unknown_user")
 	}
-	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
-		return "", ErrInvalidCredentials
+	ok, rehash, err := passwd.Verify(user.PasswordHash, password, s.hashParams)
+	if err != nil {
+		return model.LoginResult{}, err
+	}
+	if !ok {
+		if err := s.guard.fail(username, hospital, clientIP, &user.ID); err != nil {
+			return model.LoginResult{}, s.loginFailed(err, user.ID, username, hospital, clientIP, "invalid_password")
+		}
+		return model.LoginResult{}, s.loginFailed(ErrInvalidCredentials, user.ID, username, hospital, clientIP, "invalid_password")
+	}
+	if rehash {
+		s.upgradeHash(user, password)
+	}
+	if !user.Active {
+		return model.LoginResult{}, s.loginFailed(ErrAccountDisabled, user.ID, username, hospital, clientIP, "deactivated")
+	}
+
+	if user.MFAEnabled {
+		challenge, err := s.mfaChallenge(user)
+		if err != nil {
+			return model.LoginResult{}, err
+		}
+		return model.LoginResult{MFARequired: true, MFAToken: challenge}, nil
+	}
+
+	if err := s.guard.succeed(username, hospital); err != nil {
+		return model.LoginResult{}, err
+	}
+	if err := s.auditLogin(model.AuditActionLoginSucceeded, user.ID, username, hospital, clientIP, ""); err != nil {
+		return model.LoginResult{}, err
+	}
+	tokens, err := s.startSession(user, false)
+	if err != nil {
+		return model.LoginResult{}, err
+	}
+	return model.LoginResult{TokenPair: &tokens}, nil
+}
+
+// verifyDummy spends the same time as checking a real password, so a login for
+// an unknown username cannot be told apart from a wrong password by timing.
+func (s *staffService) verifyDummy(password string) {
+	s.dummyOnce.Do(func() {
+		s.dummyHash, _ = passwd.Hash("dummy password for unknown users", s.hashParams)
+	})
+	if s.dummyHash != "" {
+		_, _, _ = passwd.Verify(s.dummyHash, password, s.hashParams)
+	}
+}
+
+// loginFailed records a failed login in the audit chain and returns cause,
+// unless the audit write itself failed.
+func (s *staffService) loginFailed(cause error, staffID int64, username, hospital, clientIP, reason string) error {
+	if err := s.auditLogin(model.AuditActionLoginFailed, staffID, username, hospital, clientIP, reason); err != nil {
+		return err
+	}
+	return cause
+}
+
+func (s *staffService) auditLogin(action string, staffID int64, username, hospital, clientIP, reason string) error {
+	criteria := map[string]string{"username": username}
+	if reason != "" {
+		criteria["reason"] = reason
+	}
+	raw, err := json.Marshal(criteria)
+	if err != nil {
+		return err
+	}
+	if err := s.audit.Record(model.AuditEntry{
+		StaffID:  staffID,
+		Hospital: hospital,
+		Action:   action,
+		Criteria: raw,
+		ClientIP: clientIP,
+	}); err != nil {
+		return errors.Join(ErrAuditFailed, err)
+	}
+	return nil
+}
+
+func (s *staffService) startSession(user model.Staff, mfaVerified bool) (model.TokenPair, error) {
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	session, err := s.sessions.Create(user.ID, mfaVerified, refreshHash, time.Now().Add(s.refreshTTL))
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	return s.issue(user, session, refreshToken)
+}
+
+func (s *staffService) Refresh(refreshToken string) (model.TokenPair, error) {
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	}
+	next, nextHash, err := newRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	session, err := s.sessions.Rotate(hashToken(refreshToken), nextHash, time.Now().Add(s.refreshTTL))
+	if errors.Is(err, repository.ErrRefreshTokenInvalid) || errors.Is(err, repository.ErrRefreshTokenReused) {
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	user, err := s.repo.FindByID(session.StaffID)
+	if err != nil || !user.Active {
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	}
+	return s.issue(user, session, next)
+}
+
+func (s *staffService) Logout(staffID int64, sessionID, tokenID string, expiresAt time.Time) error {
+	if sessionID != "" {
+		if err := s.sessions.Revoke(staffID, sessionID); err != nil {
+			return err
+		}
+	}
+	return s.sessions.RevokeAccessToken(tokenID, expiresAt)
+}
+
+func (s *staffService) RevokeAllSessions(hospital string, staffID int64) error {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil || user.Hospital != hospital {
+		return ErrStaffNotFound
+	}
+	return s.sessions.RevokeAllForStaff(staffID)
+}
+
+func (s *staffService) IsRevoked(tokenID, sessionID string, staffID int64, issuedAt time.Time) (bool, error) {
+	return s.sessions.IsAccessTokenRevoked(tokenID, sessionID, staffID, issuedAt)
+}
+
+func (s *staffService) Unlock(hospital string, actorID, staffID int64) error {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil || user.Hospital != hospital {
+		return ErrStaffNotFound
+	}
+	return s.guard.unlock(user, actorID)
+}
+
+func (s *staffService) ListSecurityEvents(hospital string, limit int) ([]model.SecurityEvent, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return s.events.ListByHospital(hospital, limit)
+}
+
+func (s *staffService) issue(user model.Staff, session model.Session, refreshToken string) (model.TokenPair, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	amr := []string{"pwd"}
+	if session.MFAVerified {
+		amr = append(amr, "otp")
 	}
 
 	now := time.Now()
 	claims := jwt.MapClaims{
+		"typ":      tokenTypeAccess,
+		"jti":      jti,
+		"sid":      session.ID,
+		"amr":      amr,
 		"staff_id": user.ID,
 		"hospital": user.Hospital,
+		"role":     user.Role,
 		"iat":      now.Unix(),
-		"exp":      now.Add(s.tokenTTL).Unix(),
+		"exp":      now.Add(s.accessTTL).Unix(),
+	}
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	return model.TokenPair{
+		AccessToken:  signed,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL / time.Second),
+	}, nil
+}
+
+func newRefreshToken() (string, string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+
+	"agnos/internal/model"
+	"agnos/internal/passwd"
+)
+
+var (
+	ErrAccountDisabled      = errors.New("account is deactivated")
+	ErrCannotDeactivateSelf = errors.New("cannot deactivate your own account")
+)
+
+func (s *staffService) List(hospital string) ([]model.Staff, error) {
+	return s.repo.ListByHospital(hospital)
+}
+
+// SetActive deactivates or reactivates a staff member of the admin's hospital.
+// Deactivation also revokes every session, so existing tokens stop working.
+func (s *staffService) SetActive(hospital string, actorID, staffID int64, active bool) error {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil || user.Hospital != hospital {
+		return ErrStaffNotFound
+	}
+	if !active && user.ID == actorID {
+		return ErrCannotDeactivateSelf
+	}
+	if err := s.repo.SetActive(user.ID, active); err != nil {
+		return err
+	}
+	eventType := model.SecurityEventReactivated
+	if !active {
+		eventType = model.SecurityEventDeactivated
+		if err := s.sessions.RevokeAllForStaff(user.ID); err != nil {
+			return err
+		}
+	}
+	return s.recordStaffEvent(user, eventType, actorID)
+}
+
+// ChangePassword sets a new password for the caller. The old password is
+// checked under the same throttling and lockout as a login, so a stolen access
+// token cannot be used to guess it.
+func (s *staffService) ChangePassword(staffID int64, oldPassword, newPassword, clientIP string) error {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil {
+		return ErrStaffNotFound
+	}
+	if err := s.guard.check(user.Username, user.Hospital, clientIP); err != nil {
+		return err
+	}
+	if ok, _, err := passwd.Verify(user.PasswordHash, oldPassword, s.hashParams); err != nil || !ok {
+		if err := s.guard.fail(user.Username, user.Hospital, clientIP, &user.ID); err != nil {
+			return err
+		}
+		return ErrInvalidCredentials
+	}
+	if err := s.guard.succeed(user.Username, user.Hospital); err != nil {
+		return err
+	}
+	if err := s.setPassword(user, newPassword); err != nil {
+		return err
+	}
+	return s.recordStaffEvent(user, model.SecurityEventPasswordChanged, user.ID)
+}
+
+// ResetPassword lets an admin set a new password for a staff member of the
+// same hospital, e.g. when it was forgotten or may have leaked.
+func (s *staffService) ResetPassword(hospital string, actorID, staffID int64, newPassword string) error {
+	user, err := s.repo.FindByID(staffID)
+	if err != nil || user.Hospital != hospital {
+		return ErrStaffNotFound
+	}
+	if err := s.setPassword(user, newPassword); err != nil {
+		return err
+	}
+	return s.recordStaffEvent(user, model.SecurityEventPasswordReset, actorID)
+}
+
+func (s *staffService) setPassword(user model.Staff, password string) error {
+	hash, err := s.hashPassword(user.Username, password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(user.ID, hash); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAllForStaff(user.ID)
+}
+
+func (s *staffService) recordStaffEvent(user model.Staff, eventType string, actorID int64) error {
+	return s.events.Record(model.SecurityEvent{
+		Hospital:     user.Hospital,
+		Type:         eventType,
+		StaffID:      &user.ID,
+		Username:     user.Username,
+		ActorStaffID: &actorID,
+	})
+}
+
+func (s *staffService) hashPassword(username, password string) (string, error) {
+	if err := s.policy.Validate(username, password); err != nil {
+		return "", err
+	}
+	return passwd.Hash(password, s.hashParams)
+}
+
+// upgradeHash replaces a bcrypt (or outdated argon2id) hash after a successful
+// login. It is best effort: on failure the old hash keeps working and the
+// upgrade is retried on the next login.
+func (s *staffService) upgradeHash(user model.Staff, password string) {
+	hash, err := passwd.Hash(password, s.hashParams)
+	if err != nil {
+		return
+	}
+	_ = s.repo.UpgradePasswordHash(user.ID, user.PasswordHash, hash)
+}
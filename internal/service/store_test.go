@@ -0,0 +1,374 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"agnos/internal/model"
+	"agnos/internal/mpi"
+	"agnos/internal/repository"
+)
+
+// memPatients is an in-memory patient store with the rules of the Postgres
+// repository that the services rely on: identifiers are unique among live
+// patients, and deleted, erased and merged patients stay in place.
+type memPatients struct {
+	patients map[int64]model.Patient
+	holds    map[int64]bool
+	nextID   int64
+}
+
+func newMemPatients() *memPatients {
+	return &memPatients{patients: map[int64]model.Patient{}, holds: map[int64]bool{}}
+}
+
+func live(p model.Patient) bool {
+	return p.MergedInto == nil && p.ErasedAt == nil && p.DeletedAt == nil
+}
+
+func sameID(a, b *string) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+// clone deep-copies p, so stored patients are not changed through pointers
+// handed out to callers.
+func clone(p model.Patient) model.Patient {
+	raw, _ := json.Marshal(p)
+	var out model.Patient
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
+
+func (m *memPatients) conflicts(p model.Patient) bool {
+	for _, other := range m.patients {
+		if other.ID != p.ID && other.Hospital == p.Hospital && live(other) &&
+			(sameID(other.NationalID, p.NationalID) || sameID(other.PassportID, p.PassportID)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memPatients) SearchByHospital(hospital string, c model.PatientSearchCriteria) (model.PatientPage, error) {
+	page := model.PatientPage{Patients: []model.Patient{}}
+	for _, p := range m.patients {
+		if p.Hospital == hospital && live(p) && (c.NationalID == nil || sameID(p.NationalID, c.NationalID)) &&
+			(c.PassportID == nil || sameID(p.PassportID, c.PassportID)) {
+			page.Patients = append(page.Patients, clone(p))
+		}
+	}
+	return page, nil
+}
+
+func (m *memPatients) FindByIdentifier(hospital string, nationalID, passportID *string) (model.Patient, bool, error) {
+	for _, p := range m.patients {
+		if p.Hospital == hospital && live(p) && (sameID(p.NationalID, nationalID) || sameID(p.PassportID, passportID)) {
+			return clone(p), true, nil
+		}
+	}
+	return model.Patient{}, false, nil
+}
+
+func (m *memPatients) UpsertByNationalOrPassport(hospital string, p model.Patient) (model.Patient, error) {
+	p.Hospital = hospital
+	if existing, found, _ := m.FindByIdentifier(hospital, p.NationalID, p.PassportID); found {
+		p.ID = existing.ID
+	}
+	return m.store(p)
+}
+
+func (m *memPatients) StaleSynced(hospital string, before time.Time, limit int) ([]model.Patient, error) {
+	return nil, nil
+}
+
+func (m *memPatients) MarkSynced(hospital string, id int64) error {
+	return nil
+}
+
+func (m *memPatients) FindByID(hospital string, id int64) (model.Patient, error) {
+	p, ok := m.patients[id]
+	if !ok || p.Hospital != hospital {
+		return model.Patient{}, sql.ErrNoRows
+	}
+	return clone(p), nil
+}
+
+func (m *memPatients) Create(hospital string, p model.Patient, staffID int64) (model.Patient, error) {
+	p.ID, p.Hospital = 0, hospital
+	return m.store(p)
+}
+
+func (m *memPatients) Update(hospital string, p model.Patient, staffID int64) (model.Patient, error) {
+	if current, ok := m.patients[p.ID]; !ok || current.Hospital != hospital || !live(current) {
+		return model.Patient{}, sql.ErrNoRows
+	}
+	return m.store(p)
+}
+
+func (m *memPatients) Delete(hospital string, id int64, staffID int64) (bool, error) {
+	p, ok := m.patients[id]
+	if !ok || p.Hospital != hospital || !live(p) {
+		return false, nil
+	}
+	if m.holds[id] {
+		return false, repository.ErrLegalHold
+	}
+	now := time.Now().UTC()
+	p.DeletedAt = &now
+	m.patients[id] = p
+	return true, nil
+}
+
+func (m *memPatients) Versions(hospital string, id int64) ([]model.PatientVersion, error) {
+	return nil, nil
+}
+
+// Restore keeps no versions: it only undeletes the patient as it is.
+func (m *memPatients) Restore(hospital string, id int64, version int, staffID int64) (model.Patient, error) {
+	p, ok := m.patients[id]
+	if !ok || p.Hospital != hospital {
+		return model.Patient{}, sql.ErrNoRows
+	}
+	p.DeletedAt = nil
+	return m.store(p)
+}
+
+func (m *memPatients) store(p model.Patient) (model.Patient, error) {
+	if m.conflicts(p) {
+		return model.Patient{}, repository.ErrPatientConflict
+	}
+	if p.ID == 0 {
+		m.nextID++
+		p.ID = m.nextID
+	}
+	m.patients[p.ID] = clone(p)
+	return clone(p), nil
+}
+
+// memMPI merges and unmerges patients of a memPatients like the Postgres
+// repository does. It never finds duplicate candidates.
+type memMPI struct {
+	patients *memPatients
+	merges   []model.PatientMerge
+}
+
+func (m *memMPI) Candidates(hospital string, p model.Patient, limit int) ([]model.Patient, error) {
+	return nil, nil
+}
+
+func (m *memMPI) QueueDuplicate(d model.DuplicateCandidate) error {
+	return nil
+}
+
+func (m *memMPI) ListDuplicates(hospital string, limit int) ([]model.DuplicateCandidate, error) {
+	return nil, nil
+}
+
+func (m *memMPI) DismissDuplicate(hospital string, id, staffID int64) (bool, error) {
+	return false, nil
+}
+
+func (m *memMPI) Merge(hospital string, survivorID, mergedID, staffID int64, reason string) (model.PatientMerge, error) {
+	survivor, err := m.patients.FindByID(hospital, survivorID)
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	merged, err := m.patients.FindByID(hospital, mergedID)
+	if err != nil {
+		return model.PatientMerge{}, err
+	}
+	if !live(survivor) || !live(merged) {
+		return model.PatientMerge{}, repository.ErrMergeConflict
+	}
+
+	filled, fields := mpi.Fill(survivor, merged)
+	tombstone := clone(merged)
+	tombstone.NationalID, tombstone.PassportID, tombstone.MergedInto = nil, nil, &survivorID
+	m.patients.patients[mergedID] = tombstone
+	if _, err := m.patients.store(filled); err != nil {
+		m.patients.patients[mergedID] = merged
+		return model.PatientMerge{}, err
+	}
+
+	merge := model.PatientMerge{
+		ID: int64(len(m.merges) + 1), Hospital: hospital, SurvivorID: survivorID, MergedID: mergedID, StaffID: staffID,
+		Reason: reason, FilledFields: fields, MergedBefore: merged, CreatedAt: time.Now().UTC(),
+	}
+	m.merges = append(m.merges, merge)
+	return merge, nil
+}
+
+func (m *memMPI) Unmerge(hospital string, mergeID, staffID int64) (model.PatientMerge, error) {
+	if mergeID < 1 || mergeID > int64(len(m.merges)) || m.merges[mergeID-1].Hospital != hospital {
+		return model.PatientMerge{}, sql.ErrNoRows
+	}
+	merge := m.merges[mergeID-1]
+	if merge.RevertedAt != nil {
+		return model.PatientMerge{}, repository.ErrMergeConflict
+	}
+	survivor := m.patients.patients[merge.SurvivorID]
+	tombstone := m.patients.patients[merge.MergedID]
+	if survivor.MergedInto != nil || tombstone.MergedInto == nil || *tombstone.MergedInto != merge.SurvivorID {
+		return model.PatientMerge{}, repository.ErrMergeConflict
+	}
+
+	if _, err := m.patients.store(mpi.Unfill(survivor, merge.MergedBefore, merge.FilledFields)); err != nil {
+		return model.PatientMerge{}, err
+	}
+	restored := clone(merge.MergedBefore)
+	restored.ID = merge.MergedID
+	if _, err := m.patients.store(restored); err != nil {
+		m.patients.patients[merge.SurvivorID] = survivor
+		return model.PatientMerge{}, err
+	}
+
+	now := time.Now().UTC()
+	merge.RevertedBy, merge.RevertedAt = &staffID, &now
+	m.merges[mergeID-1] = merge
+	return merge, nil
+}
+
+func (m *memMPI) Merges(hospital string, patientID int64) ([]model.PatientMerge, error) {
+	out := []model.PatientMerge{}
+	for i := len(m.merges) - 1; i >= 0; i-- {
+		if mg := m.merges[i]; mg.Hospital == hospital && (mg.SurvivorID == patientID || mg.MergedID == patientID) {
+			out = append(out, mg)
+		}
+	}
+	return out, nil
+}
+
+// memPrivacy keeps privacy requests and legal holds, and erases patients of a
+// memPatients like the Postgres repository does.
+type memPrivacy struct {
+	patients *memPatients
+	requests []model.PrivacyRequest
+	holds    []model.LegalHold
+}
+
+func (m *memPrivacy) CreateRequest(req model.PrivacyRequest) (model.PrivacyRequest, error) {
+	req.ID, req.Status, req.CreatedAt = int64(len(m.requests)+1), model.PrivacyRequestPending, time.Now().UTC()
+	m.requests = append(m.requests, req)
+	return req, nil
+}
+
+func (m *memPrivacy) ListRequests(hospital, status string) ([]model.PrivacyRequest, error) {
+	out := []model.PrivacyRequest{}
+	for _, req := range m.requests {
+		if req.Hospital == hospital && (status == "" || req.Status == status) {
+			out = append(out, req)
+		}
+	}
+	return out, nil
+}
+
+func (m *memPrivacy) FindRequest(hospital string, id int64) (model.PrivacyRequest, error) {
+	if id < 1 || id > int64(len(m.requests)) || m.requests[id-1].Hospital != hospital {
+		return model.PrivacyRequest{}, sql.ErrNoRows
+	}
+	return m.requests[id-1], nil
+}
+
+func (m *memPrivacy) close(hospital string, id, staffID int64, status, resolution string) (model.PrivacyRequest, error) {
+	req, err := m.FindRequest(hospital, id)
+	if err != nil {
+		return model.PrivacyRequest{}, err
+	}
+	if req.Status != model.PrivacyRequestPending {
+		return model.PrivacyRequest{}, repository.ErrPrivacyRequestClosed
+	}
+	now := time.Now().UTC()
+	req.Status, req.ClosedBy, req.ClosedAt, req.Resolution = status, &staffID, &now, resolution
+	m.requests[id-1] = req
+	return req, nil
+}
+
+func (m *memPrivacy) RejectRequest(hospital string, id, staffID int64, reason string) (model.PrivacyRequest, error) {
+	return m.close(hospital, id, staffID, model.PrivacyRequestRejected, reason)
+}
+
+func (m *memPrivacy) CompleteExport(hospital string, id, staffID int64) (model.PrivacyRequest, error) {
+	return m.close(hospital, id, staffID, model.PrivacyRequestCompleted, "")
+}
+
+func (m *memPrivacy) CompleteErasure(hospital string, id, staffID int64) (model.PrivacyRequest, []int64, error) {
+	req, err := m.FindRequest(hospital, id)
+	if err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	var ids []int64
+	for _, p := range m.patients.patients {
+		if p.Hospital == hospital && (p.ID == req.PatientID || (p.MergedInto != nil && *p.MergedInto == req.PatientID)) {
+			ids = append(ids, p.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return model.PrivacyRequest{}, nil, sql.ErrNoRows
+	}
+	for _, pid := range ids {
+		if m.patients.holds[pid] {
+			return model.PrivacyRequest{}, nil, repository.ErrLegalHold
+		}
+	}
+	if req, err = m.close(hospital, id, staffID, model.PrivacyRequestCompleted, ""); err != nil {
+		return model.PrivacyRequest{}, nil, err
+	}
+	now := time.Now().UTC()
+	for _, pid := range ids {
+		p := m.patients.patients[pid]
+		m.patients.patients[pid] = model.Patient{ID: p.ID, Hospital: p.Hospital, MergedInto: p.MergedInto, DeletedAt: p.DeletedAt, ErasedAt: &now}
+	}
+	return req, ids, nil
+}
+
+func (m *memPrivacy) MergedRecords(hospital string, id int64) ([]model.Patient, error) {
+	out := []model.Patient{}
+	for _, p := range m.patients.patients {
+		if p.Hospital == hospital && p.MergedInto != nil && *p.MergedInto == id {
+			out = append(out, clone(p))
+		}
+	}
+	return out, nil
+}
+
+func (m *memPrivacy) PlaceHold(h model.LegalHold) (model.LegalHold, error) {
+	h.ID, h.PlacedAt = int64(len(m.holds)+1), time.Now().UTC()
+	m.holds = append(m.holds, h)
+	m.patients.holds[h.PatientID] = true
+	return h, nil
+}
+
+func (m *memPrivacy) FindHold(hospital string, id int64) (model.LegalHold, error) {
+	if id < 1 || id > int64(len(m.holds)) || m.holds[id-1].Hospital != hospital {
+		return model.LegalHold{}, sql.ErrNoRows
+	}
+	return m.holds[id-1], nil
+}
+
+func (m *memPrivacy) ReleaseHold(hospital string, id, staffID int64) (model.LegalHold, error) {
+	h, err := m.FindHold(hospital, id)
+	if err != nil || h.ReleasedAt != nil {
+		return model.LegalHold{}, sql.ErrNoRows
+	}
+	now := time.Now().UTC()
+	h.ReleasedBy, h.ReleasedAt = &staffID, &now
+	m.holds[id-1] = h
+	m.patients.holds[h.PatientID] = false
+	for _, other := range m.holds {
+		if other.PatientID == h.PatientID && other.ReleasedAt == nil {
+			m.patients.holds[h.PatientID] = true
+		}
+	}
+	return h, nil
+}
+
+func (m *memPrivacy) Holds(hospital string, patientID int64) ([]model.LegalHold, error) {
+	out := []model.LegalHold{}
+	for i := len(m.holds) - 1; i >= 0; i-- {
+		if h := m.holds[i]; h.Hospital == hospital && h.PatientID == patientID {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,50 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := CodeAt(secret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		if got != want {
+			t.Fatalf("at %d expected %s got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateAllowsSkew(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("secret: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	prev, _ := CodeAt(secret, Step(now)-1)
+	if _, ok := Validate(secret, prev, now, 1); !ok {
+		t.Fatalf("expected previous step to validate with skew 1")
+	}
+	old, _ := CodeAt(secret, Step(now)-3)
+	if _, ok := Validate(secret, old, now, 1); ok {
+		t.Fatalf("expected code outside skew to fail")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Agnos", "alice@hospital-a", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Agnos:alice@hospital-a?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}